@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/telegram"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// pauseReason is the kill switch reason set by /pause
+const pauseReason = "paused by operator"
+
+// registerCommands registers the operator commands of the Telegram bot. The commands reading or pausing
+// trading apply to every market unless one is named, those changing it need the market when several trade.
+func registerCommands(ts *telegram.TelegramService, markets *markets) {
+	ts.Handle("status", "/status [market]", func(args []string) (string, error) {
+		selected, err := selectMarkets(markets, args, 0)
+		if err != nil {
+			return "", err
+		}
+		statuses := make([]string, 0, len(selected))
+		for _, m := range selected {
+			statuses = append(statuses, marketStatus(m))
+		}
+		return strings.Join(statuses, "\n\n"), nil
+	})
+
+	// The balances are read without waiting for the trade in flight, that would block the command loop
+	ts.Handle("balances", "/balances [market]", func(args []string) (string, error) {
+		selected, err := selectMarkets(markets, args, 0)
+		if err != nil {
+			return "", err
+		}
+		lines := make([]string, 0, len(selected))
+		for _, m := range selected {
+			balances := m.executor.GetBalances()
+			switch {
+			case balances != nil:
+				lines = append(lines, m.pair+"\n"+formatBalances(balances))
+			case m.executor.LastBalances() != nil:
+				lines = append(lines, m.pair+": failed to read the balances, last known:\n"+formatBalances(m.executor.LastBalances()))
+			default:
+				lines = append(lines, m.pair+": failed to read the balances, see the logs")
+			}
+		}
+		return strings.Join(lines, "\n\n"), nil
+	})
+
+	ts.Handle("pnl", "/pnl [market]", func(args []string) (string, error) {
+		selected, err := selectMarkets(markets, args, 0)
+		if err != nil {
+			return "", err
+		}
+		reports := make([]string, 0, len(selected))
+		for _, m := range selected {
+			reports = append(reports, m.pair+" "+m.pnl.Report())
+		}
+		return strings.Join(reports, "\n"), nil
+	})
+
+	ts.Handle("pause", "/pause [market]", func(args []string) (string, error) {
+		selected, err := selectMarkets(markets, args, 0)
+		if err != nil {
+			return "", err
+		}
+		lines := make([]string, 0, len(selected))
+		for _, m := range selected {
+			if halted, reason := m.risk.IsHalted(); halted {
+				lines = append(lines, fmt.Sprintf("Trading on %s is already halted: %s", m.pair, reason))
+				continue
+			}
+			m.risk.Halt(pauseReason)
+			lines = append(lines, fmt.Sprintf("Trading on %s paused, /resume to resume", m.pair))
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+
+	ts.Handle("resume", "/resume [market] <reason>", func(args []string) (string, error) {
+		selected, reason := markets.list(), args
+		if len(args) > 0 {
+			if m := markets.get(args[0]); m != nil {
+				selected, reason = []*market{m}, args[1:]
+			}
+		}
+		if len(reason) == 0 {
+			return "", fmt.Errorf("expected the reason for resuming")
+		}
+
+		lines := make([]string, 0, len(selected))
+		for _, m := range selected {
+			if halted, _ := m.risk.IsHalted(); !halted {
+				lines = append(lines, fmt.Sprintf("Trading on %s is not paused", m.pair))
+				continue
+			}
+			if err := m.risk.Resume(strings.Join(reason, " ")); err != nil {
+				return "", fmt.Errorf("%s: %w", m.pair, err)
+			}
+			lines = append(lines, fmt.Sprintf("Trading on %s resumed", m.pair))
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+
+	ts.Handle("threshold", "/threshold [market] <pct>", func(args []string) (string, error) {
+		m, args, err := selectMarket(markets, args, 1)
+		if err != nil {
+			return "", err
+		}
+		if len(args) != 1 {
+			return "", fmt.Errorf("expected a percentage")
+		}
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(args[0], "%"), 64)
+		if err != nil || pct <= 0 {
+			return "", fmt.Errorf("invalid threshold %s", args[0])
+		}
+		previous := m.arbitrage.GetThreshold()
+		m.arbitrage.SetThreshold(pct)
+		return fmt.Sprintf("Threshold of %s changed from %.2f%% to %.2f%%", m.pair, previous, pct), nil
+	})
+
+	ts.HandleConfirmed("trade", "/trade [market] <buy|sell> <size>", func(args []string) (string, func() (string, error), error) {
+		m, args, err := selectMarket(markets, args, 2)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(args) != 2 {
+			return "", nil, fmt.Errorf("expected a direction and a size")
+		}
+		var buyOnUniswap bool
+		switch strings.ToLower(args[0]) {
+		case "buy":
+			buyOnUniswap = true
+		case "sell":
+			buyOnUniswap = false
+		default:
+			return "", nil, fmt.Errorf("invalid direction %s", args[0])
+		}
+		size, err := decimal.NewFromString(args[1])
+		if err != nil || !size.IsPositive() {
+			return "", nil, fmt.Errorf("invalid size %s", args[1])
+		}
+
+		token0, _, _ := strings.Cut(m.pair, "-")
+		summary := fmt.Sprintf("Sell %s %s on Uniswap and buy them back on KuCoin", size.String(), token0)
+		if buyOnUniswap {
+			summary = fmt.Sprintf("Buy %s %s on Uniswap and sell them on KuCoin", size.String(), token0)
+		}
+		return summary, func() (string, error) {
+			tradeID, err := m.executor.ExecuteTrade(buyOnUniswap, size)
+			if err != nil {
+				if tradeID != "" {
+					return "", fmt.Errorf("trade %s: %w", tradeID, err)
+				}
+				return "", err
+			}
+			return fmt.Sprintf("Trade %s executed\n%s", tradeID, m.pnl.Report()), nil
+		}, nil
+	})
+}
+
+// marketStatus describes the prices and the risk state of a market
+func marketStatus(m *market) string {
+	uniswapPrice, kucoinPrice := m.pricing.GetPrices()
+	status := m.risk.GetStatus()
+
+	state := "running"
+	if status.Halted {
+		state = "halted: " + status.HaltReason
+	}
+	mode := "live"
+	if paperTrading {
+		mode = "paper"
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s (%s), trading %s", m.pair, mode, state),
+		fmt.Sprintf("Uniswap price: %.8f", uniswapPrice),
+		fmt.Sprintf("KuCoin price: %.8f", kucoinPrice),
+	}
+	if uniswapPrice != 0 {
+		lines = append(lines, fmt.Sprintf("Price difference: %.2f%%, threshold %.2f%%", (kucoinPrice-uniswapPrice)/uniswapPrice*100, m.arbitrage.GetThreshold()))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Trades in the last hour: %d", status.TradesLastHour),
+		fmt.Sprintf("Daily realized PnL: %s, daily gas: %s ETH", status.DailyRealizedPnL.StringFixed(6), status.DailyGas.StringFixed(6)),
+		fmt.Sprintf("Consecutive failed legs: %d", status.ConsecutiveFailures),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// selectMarkets returns the market named by the first of args, or every market if args holds no more than
+// the n other arguments
+func selectMarkets(markets *markets, args []string, n int) ([]*market, error) {
+	if len(args) <= n {
+		list := markets.list()
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no market is running")
+		}
+		return list, nil
+	}
+	m := markets.get(args[0])
+	if m == nil {
+		return nil, fmt.Errorf("unknown market %s", args[0])
+	}
+	return []*market{m}, nil
+}
+
+// selectMarket returns the market named by the first of args and the other arguments. The market may be left
+// out of the n other arguments when a single one trades.
+func selectMarket(markets *markets, args []string, n int) (*market, []string, error) {
+	if len(args) > n {
+		m := markets.get(args[0])
+		if m == nil {
+			return nil, nil, fmt.Errorf("unknown market %s", args[0])
+		}
+		return m, args[1:], nil
+	}
+
+	list := markets.list()
+	if len(list) != 1 {
+		pairs := make([]string, 0, len(list))
+		for _, m := range list {
+			pairs = append(pairs, m.pair)
+		}
+		return nil, nil, fmt.Errorf("expected a market, one of %s", strings.Join(pairs, ", "))
+	}
+	return list[0], args, nil
+}
+
+// formatBalances lists balances per venue and token
+func formatBalances(balances pnl.Balances) string {
+	venues := make([]string, 0, len(balances))
+	for venue := range balances {
+		venues = append(venues, venue)
+	}
+	sort.Strings(venues)
+
+	var lines []string
+	for _, venue := range venues {
+		tokens := make([]string, 0, len(balances[venue]))
+		for token := range balances[venue] {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+
+		lines = append(lines, venue+":")
+		for _, token := range tokens {
+			lines = append(lines, fmt.Sprintf("  %s %s", balances[venue][token].String(), token))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -2,11 +2,87 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"rattrap/arbitrage-bot/internal/execution"
+	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/paper"
+	"rattrap/arbitrage-bot/internal/risk"
+	"rattrap/arbitrage-bot/internal/secret"
+	"rattrap/arbitrage-bot/internal/uniswap"
+	"rattrap/arbitrage-bot/internal/utils"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
+)
+
+// Default values for optional configuration
+const (
+	DefaultEthGasReserve = "0.05"
+	DefaultSlippageMode  = uniswap.SlippageFixed
+	DefaultSlippageBps   = 10
+	DefaultSwapDeadline  = 15 * time.Minute
+	DefaultKucoinFeeBps  = 10
+	DefaultMinProfitBps  = 0
+	DefaultLegOrder      = execution.LegOrderDexFirst
+	DefaultLegTimeout    = 2 * time.Minute
+	DefaultMarkInterval  = 5 * time.Minute
+	DefaultThresholdPct  = 1.0
+
+	DefaultHedgeOrderType        = kucoin.OrderTypeLimit
+	DefaultHedgeTimeInForce      = kucoin.TimeInForceIOC
+	DefaultHedgePriceBandBps     = 50
+	DefaultRebalanceOrderType    = kucoin.OrderTypeLimit
+	DefaultRebalanceTimeInForce  = kucoin.TimeInForceGTC
+	DefaultRebalancePriceBandBps = 0
+
+	DefaultSimulationMaxDivergenceBps = 50
+
+	DefaultRiskMaxConsecutiveFailures = 3
+
+	DefaultLedgerPath = "data/ledger.db"
+
+	DefaultReconcileInterval     = 15 * time.Minute
+	DefaultReconcileToleranceBps = 100
+
+	DefaultLogFormat     = logging.FormatText
+	DefaultLogMaxSizeMB  = 100
+	DefaultLogMaxAgeDays = 30
+	DefaultLogMaxBackups = 10
+
+	DefaultSignerType = SignerTypeKey
+
+	DefaultMetricsAddr          = ":9090"
+	DefaultHealthMaxSnapshotAge = 5 * time.Minute
+
+	DefaultShutdownTimeout = 5 * time.Minute
+
+	DefaultConfigWatchInterval = 10 * time.Second
+
+	DefaultNotifyImmediateLevel = notify.LevelWarning
+	DefaultNotifyDigestInterval = 15 * time.Minute
+	DefaultNotifyDedupWindow    = 10 * time.Minute
+	DefaultNotifyRateLimit      = 10
+	DefaultTelegramNotifyLevel  = notify.LevelInfo
+	DefaultWebhookNotifyLevel   = notify.LevelInfo
+	DefaultSlackNotifyLevel     = notify.LevelWarning
+	DefaultDiscordNotifyLevel   = notify.LevelWarning
+)
+
+// Signer types
+const (
+	SignerTypeKey      = "key"      // Raw private key from ETHEREUM_PRIVATE_KEY
+	SignerTypeKeystore = "keystore" // Encrypted JSON keystore
+	SignerTypeExternal = "external" // External signer such as Clef
+	SignerTypeMnemonic = "mnemonic" // Accounts derived from a BIP-39 mnemonic
 )
 
 // Custom errors for missing configuration values
@@ -14,50 +90,225 @@ var (
 	ErrMissingAPIKey                 = fmt.Errorf("missing KuCoin API keys")
 	ErrMissingRPCURL                 = fmt.Errorf("missing Ethereum RPC URL")
 	ErrMissingPrivateKey             = fmt.Errorf("missing Ethereum private key")
+	ErrMissingKeystorePath           = fmt.Errorf("missing keystore path")
+	ErrMissingSignerURL              = fmt.Errorf("missing external signer URL")
+	ErrMissingMnemonic               = fmt.Errorf("missing BIP-39 mnemonic")
 	ErrMissingUniswapPoolAddress     = fmt.Errorf("missing Uniswap V3 pool address")
 	ErrMissingUniswapTickLensAddress = fmt.Errorf("missing Uniswap V3 tick lens address")
 	ErrMissingTradingPair            = fmt.Errorf("missing trading pair")
+	ErrMissingControlToken           = fmt.Errorf("missing control API token")
 )
 
 // Config stores all the configuration values for the arbitrage bot.
 type Config struct {
-	KucoinAPIKey           string         // KuCoin API Key
-	KucoinAPISecret        string         // KuCoin API Secret
-	KucoinAPIPassphrase    string         // KuCoin API Passphrase
-	EthereumRPCURL         string         // Ethereum RPC URL (e.g., Infura or Alchemy)
-	EthereumPrivateKey     string         // Private key to sign transactions on Ethereum
-	TelegramChannelID      int64          // Telegram Channel ID
-	TelegramBotToken       string         // Telegram Bot Token
-	UniswapPoolAddress     common.Address // Uniswap V3 pool address
-	UniswapTickLensAddress common.Address // Uniswap V3 tick lens address
-	TradingPair            string         // Trading pair to monitor
+	KucoinAPIKey           secret.Secret            // KuCoin API Key
+	KucoinAPISecret        secret.Secret            // KuCoin API Secret
+	KucoinAPIPassphrase    secret.Secret            // KuCoin API Passphrase
+	EthereumRPCURL         secret.Secret            // Ethereum RPC URL (e.g., Infura or Alchemy), may embed an API key
+	EthereumPrivateKey     secret.Secret            // Private key to sign transactions on Ethereum
+	SignerType             string                   // How transactions are signed, one of the SignerType constants
+	KeystorePath           string                   // Encrypted JSON keystore of the signing key
+	KeystorePassphrase     secret.Secret            // Passphrase of the keystore, prompted for if empty
+	SignerURL              secret.Secret            // JSON-RPC endpoint of the external signer, may embed credentials
+	SignerAddress          common.Address           // Account of the external signer, its first account if unset
+	Mnemonic               secret.Secret            // BIP-39 mnemonic the accounts are derived from
+	MnemonicPassphrase     secret.Secret            // Optional BIP-39 passphrase of the mnemonic
+	HDPaths                []string                 // Derivation paths of the accounts, indexed by HD_ACCOUNT
+	TelegramChannelID      int64                    // Telegram Channel ID
+	TelegramBotToken       secret.Secret            // Telegram Bot Token
+	TelegramAdminIDs       []int64                  // Telegram users allowed to send commands, commands are disabled if empty
+	UniswapTickLensAddress common.Address           // Uniswap V3 tick lens address
+	Markets                map[string]*MarketConfig // Markets to trade and their settings, by trading pair
+	UniswapNativeETH       bool                     // Swap from/to native ETH instead of WETH
+	EthGasReserve          *big.Int                 // Minimum ETH (wei) kept for gas, never traded away
+	Simulation             uniswap.SimulationConfig // Swap simulation settings
+	PaperBalances          paper.Balances           // Starting virtual balances of every market in paper trading mode
+	LedgerPath             string                   // Path of the trade ledger database
+	ReconcileInterval      time.Duration            // Interval between balance snapshots
+	ReconcileToleranceBps  int64                    // Balance drift tolerated by the reconciliation, in bps
+	MetricsAddr            string                   // Listen address of the metrics and health endpoints, empty to disable
+	ControlAddr            string                   // Listen address of the control API, empty to disable
+	ControlToken           secret.Secret            // Bearer token required by the control API
+	HealthMaxSnapshotAge   time.Duration            // Pool snapshot age after which the bot is unhealthy
+	Notifiers              *NotifierConfig          // Notification routing and backends
+	ShutdownTimeout        time.Duration            // How long the shutdown waits for the trade in flight
+	ShutdownCancelOrders   bool                     // Cancel the open KuCoin orders of the markets on shutdown
+	ConfigWatchInterval    time.Duration            // How often the .env file is checked for changes, 0 to only reload on SIGHUP
+}
+
+// NotifierConfig stores the notification settings. Each notifier receives the levels from its own minimum.
+type NotifierConfig struct {
+	Options           notify.Options // Routing, deduplication and rate limiting of notifications
+	TelegramLevel     notify.Level   // Lowest notification level sent to Telegram
+	WebhookURL        secret.Secret  // JSON webhook, disabled if empty
+	WebhookSecret     secret.Secret  // HMAC key signing the webhook body, unsigned if empty
+	WebhookLevel      notify.Level   // Lowest notification level sent to the webhook
+	SlackWebhookURL   secret.Secret  // Slack incoming webhook, disabled if empty
+	SlackLevel        notify.Level   // Lowest notification level sent to Slack
+	DiscordWebhookURL secret.Secret  // Discord webhook, disabled if empty
+	DiscordLevel      notify.Level   // Lowest notification level sent to Discord
+}
+
+// MarketConfig stores the settings of a single market. Every setting can be overridden per market by
+// prefixing its environment variable with the trading pair, e.g. ELON_USDT_SLIPPAGE_BPS.
+type MarketConfig struct {
+	TradingPair  string           // Trading pair of the market
+	PoolAddress  common.Address   // Uniswap V3 pool of the market
+	HDAccount    int              // Index of the derived account trading the market with the mnemonic signer
+	ThresholdPct float64          // Price difference between the venues that triggers a trade, in percent
+	Execution    execution.Config // Execution settings of the market
+	Risk         risk.Limits      // Hard risk limits checked before every trade of the market
+}
+
+// TradingPairs returns the trading pairs of the markets, sorted
+func (c *Config) TradingPairs() []string {
+	pairs := make([]string, 0, len(c.Markets))
+	for pair := range c.Markets {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// envFile is the optional file the environment variables are loaded from
+const envFile = ".env"
+
+var (
+	// processEnv holds the variables set before envFile was first loaded, they take precedence over the file
+	processEnv map[string]bool
+	// fileEnv holds the variables last loaded from envFile
+	fileEnv map[string]string
+)
+
+// loadEnvFile loads envFile into the environment if it exists. Loading it again applies the changes made to
+// the file since, variables removed from it are unset.
+func loadEnvFile() error {
+	if processEnv == nil {
+		processEnv = make(map[string]bool)
+		for _, variable := range os.Environ() {
+			name, _, _ := strings.Cut(variable, "=")
+			processEnv[name] = true
+		}
+	}
+
+	values, err := godotenv.Read(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("invalid %s file: %w", envFile, err)
+	}
+
+	// Variables removed from the file are unset, unless the process environment set them
+	for name := range fileEnv {
+		if _, ok := values[name]; !ok && !processEnv[name] {
+			os.Unsetenv(name)
+		}
+	}
+	for name, value := range values {
+		if !processEnv[name] {
+			os.Setenv(name, value)
+		}
+	}
+	fileEnv = values
+	return nil
 }
 
-// LoadConfig loads the configuration values from environment variables or .env file.
+// LoadConfig loads the configuration values from environment variables or .env file. It is called again to
+// reload the configuration.
 func LoadConfig() (*Config, error) {
-	// Load .env file if it exists
-	_ = godotenv.Load()
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
 
 	config := &Config{}
 
-	// Load KuCoin API keys from environment variables
-	config.KucoinAPIKey = os.Getenv("KUCOIN_API_KEY")
-	config.KucoinAPISecret = os.Getenv("KUCOIN_API_SECRET")
-	config.KucoinAPIPassphrase = os.Getenv("KUCOIN_API_PASSPHRASE")
-	if config.KucoinAPIKey == "" || config.KucoinAPISecret == "" || config.KucoinAPIPassphrase == "" {
+	// The .env file holds secrets, refuse to run if anyone can read it
+	if err := secret.AuditFile(envFile); err != nil {
+		return nil, err
+	}
+
+	// Secrets are read from their environment variable or from the file named by <NAME>_FILE
+	for name, value := range map[string]*secret.Secret{
+		"KUCOIN_API_KEY":        &config.KucoinAPIKey,
+		"KUCOIN_API_SECRET":     &config.KucoinAPISecret,
+		"KUCOIN_API_PASSPHRASE": &config.KucoinAPIPassphrase,
+		"ETHEREUM_RPC_URL":      &config.EthereumRPCURL,
+		"ETHEREUM_PRIVATE_KEY":  &config.EthereumPrivateKey,
+		"TELEGRAM_BOT_TOKEN":    &config.TelegramBotToken,
+		"KEYSTORE_PASSPHRASE":   &config.KeystorePassphrase,
+		"MNEMONIC":              &config.Mnemonic,
+		"MNEMONIC_PASSPHRASE":   &config.MnemonicPassphrase,
+		"CONTROL_TOKEN":         &config.ControlToken,
+	} {
+		s, err := secret.FromEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		*value = s
+	}
+
+	// Check KuCoin API keys
+	if config.KucoinAPIKey.IsEmpty() || config.KucoinAPISecret.IsEmpty() || config.KucoinAPIPassphrase.IsEmpty() {
 		return nil, ErrMissingAPIKey
 	}
 
-	// Load Ethereum RPC URL (Infura/Alchemy)
-	config.EthereumRPCURL = os.Getenv("ETHEREUM_RPC_URL")
-	if config.EthereumRPCURL == "" {
+	// Check Ethereum RPC URL (Infura/Alchemy)
+	if config.EthereumRPCURL.IsEmpty() {
 		return nil, ErrMissingRPCURL
 	}
 
-	// Load Ethereum private key for signing transactions
-	config.EthereumPrivateKey = os.Getenv("ETHEREUM_PRIVATE_KEY")
-	if config.EthereumPrivateKey == "" {
-		return nil, ErrMissingPrivateKey
+	// Load the signer of Ethereum transactions
+	config.SignerType = DefaultSignerType
+	if signerType := os.Getenv("SIGNER_TYPE"); signerType != "" {
+		config.SignerType = signerType
+	}
+	switch config.SignerType {
+	case SignerTypeKey:
+		if config.EthereumPrivateKey.IsEmpty() {
+			return nil, ErrMissingPrivateKey
+		}
+	case SignerTypeKeystore:
+		config.KeystorePath = os.Getenv("KEYSTORE_PATH")
+		if config.KeystorePath == "" {
+			return nil, ErrMissingKeystorePath
+		}
+		if err := secret.AuditFile(config.KeystorePath); err != nil {
+			return nil, err
+		}
+	case SignerTypeExternal:
+		signerURL, err := secret.FromEnv("SIGNER_URL")
+		if err != nil {
+			return nil, err
+		}
+		if signerURL.IsEmpty() {
+			return nil, ErrMissingSignerURL
+		}
+		config.SignerURL = signerURL
+		if address := os.Getenv("SIGNER_ADDRESS"); address != "" {
+			if !common.IsHexAddress(address) {
+				return nil, fmt.Errorf("invalid SIGNER_ADDRESS: %s", address)
+			}
+			config.SignerAddress = common.HexToAddress(address)
+		}
+	case SignerTypeMnemonic:
+		if config.Mnemonic.IsEmpty() {
+			return nil, ErrMissingMnemonic
+		}
+		config.HDPaths = []string{uniswap.DefaultHDPath}
+		if hdPaths := os.Getenv("HD_PATHS"); hdPaths != "" {
+			config.HDPaths = nil
+			for _, path := range strings.Split(hdPaths, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					config.HDPaths = append(config.HDPaths, path)
+				}
+			}
+			if len(config.HDPaths) == 0 {
+				return nil, fmt.Errorf("invalid HD_PATHS: %s", hdPaths)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid SIGNER_TYPE: %s", config.SignerType)
+	}
+	if config.SignerType != SignerTypeKey && !config.EthereumPrivateKey.IsEmpty() {
+		return nil, fmt.Errorf("ETHEREUM_PRIVATE_KEY must not be set with the %s signer", config.SignerType)
 	}
 
 	// Load Telegram configuration
@@ -70,13 +321,15 @@ func LoadConfig() (*Config, error) {
 		}
 		config.TelegramChannelID = tgID
 	}
-	config.TelegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
-
-	uniswapPoolAddress := os.Getenv("UNISWAP_POOL_ADDRESS")
-	if uniswapPoolAddress == "" {
-		return nil, ErrMissingUniswapPoolAddress
+	if adminIDs := os.Getenv("TELEGRAM_ADMIN_IDS"); adminIDs != "" {
+		for _, v := range strings.Split(adminIDs, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TELEGRAM_ADMIN_IDS: %s", adminIDs)
+			}
+			config.TelegramAdminIDs = append(config.TelegramAdminIDs, id)
+		}
 	}
-	config.UniswapPoolAddress = common.HexToAddress(uniswapPoolAddress)
 
 	uniswapTickLensAddress := os.Getenv("UNISWAP_TICKLENS_ADDRESS")
 	if uniswapTickLensAddress == "" {
@@ -84,11 +337,588 @@ func LoadConfig() (*Config, error) {
 	}
 	config.UniswapTickLensAddress = common.HexToAddress(uniswapTickLensAddress)
 
-	tradingPair := os.Getenv("TRADING_PAIR")
-	if tradingPair == "" {
+	// Markets are listed as <pair>,<pair>,..., each traded on its own pool
+	tradingPairs := os.Getenv("TRADING_PAIR")
+	if tradingPairs == "" {
 		return nil, ErrMissingTradingPair
 	}
-	config.TradingPair = tradingPair
+	config.Markets = make(map[string]*MarketConfig)
+	pools := make(map[common.Address]string)
+	for _, tradingPair := range strings.Split(tradingPairs, ",") {
+		tradingPair = strings.TrimSpace(tradingPair)
+		token0, token1, found := strings.Cut(tradingPair, "-")
+		if !found || token0 == "" || token1 == "" {
+			return nil, fmt.Errorf("invalid TRADING_PAIR: %s", tradingPairs)
+		}
+		if config.Markets[tradingPair] != nil {
+			return nil, fmt.Errorf("invalid TRADING_PAIR: %s is listed twice", tradingPair)
+		}
+
+		market, err := LoadMarketConfig(tradingPair)
+		if err != nil {
+			return nil, err
+		}
+		if config.SignerType == SignerTypeMnemonic && market.HDAccount >= len(config.HDPaths) {
+			return nil, fmt.Errorf("invalid HD_ACCOUNT for %s: %d, only %d paths are configured in HD_PATHS", tradingPair, market.HDAccount, len(config.HDPaths))
+		}
+		if other, ok := pools[market.PoolAddress]; ok {
+			return nil, fmt.Errorf("invalid UNISWAP_POOL_ADDRESS for %s: %s is the pool of %s", tradingPair, market.PoolAddress.Hex(), other)
+		}
+		pools[market.PoolAddress] = tradingPair
+		config.Markets[tradingPair] = market
+	}
+
+	uniswapNativeETH := os.Getenv("UNISWAP_NATIVE_ETH")
+	if uniswapNativeETH != "" {
+		nativeETH, err := strconv.ParseBool(uniswapNativeETH)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UNISWAP_NATIVE_ETH: %w", err)
+		}
+		config.UniswapNativeETH = nativeETH
+	}
+
+	ethGasReserve := os.Getenv("ETH_GAS_RESERVE")
+	if ethGasReserve == "" {
+		ethGasReserve = DefaultEthGasReserve
+	}
+	reserve, err := utils.ParseUnits(ethGasReserve, 18)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ETH_GAS_RESERVE: %w", err)
+	}
+	config.EthGasReserve = reserve
+
+	simulation, err := loadSimulationConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Simulation = simulation
+
+	paperBalances, err := parsePaperBalances(os.Getenv("PAPER_BALANCES"))
+	if err != nil {
+		return nil, err
+	}
+	config.PaperBalances = paperBalances
+
+	config.LedgerPath = os.Getenv("LEDGER_PATH")
+	if config.LedgerPath == "" {
+		config.LedgerPath = DefaultLedgerPath
+	}
+
+	config.ReconcileInterval = DefaultReconcileInterval
+	if reconcileInterval := os.Getenv("RECONCILE_INTERVAL"); reconcileInterval != "" {
+		d, err := time.ParseDuration(reconcileInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid RECONCILE_INTERVAL: %s", reconcileInterval)
+		}
+		config.ReconcileInterval = d
+	}
+
+	config.ReconcileToleranceBps = DefaultReconcileToleranceBps
+	if toleranceBps := os.Getenv("RECONCILE_TOLERANCE_BPS"); toleranceBps != "" {
+		bps, err := strconv.ParseInt(toleranceBps, 10, 64)
+		if err != nil || bps < 0 {
+			return nil, fmt.Errorf("invalid RECONCILE_TOLERANCE_BPS: %s", toleranceBps)
+		}
+		config.ReconcileToleranceBps = bps
+	}
+
+	config.MetricsAddr = DefaultMetricsAddr
+	if metricsAddr, ok := os.LookupEnv("METRICS_ADDR"); ok {
+		config.MetricsAddr = metricsAddr
+	}
+
+	// The control API trades and cancels orders, it is never served without a token
+	config.ControlAddr = os.Getenv("CONTROL_ADDR")
+	if config.ControlAddr != "" && config.ControlToken.IsEmpty() {
+		return nil, ErrMissingControlToken
+	}
+
+	config.HealthMaxSnapshotAge = DefaultHealthMaxSnapshotAge
+	if maxAge := os.Getenv("HEALTH_MAX_SNAPSHOT_AGE"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid HEALTH_MAX_SNAPSHOT_AGE: %s", maxAge)
+		}
+		config.HealthMaxSnapshotAge = d
+	}
+
+	config.ShutdownTimeout = DefaultShutdownTimeout
+	if shutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT"); shutdownTimeout != "" {
+		d, err := time.ParseDuration(shutdownTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %s", shutdownTimeout)
+		}
+		config.ShutdownTimeout = d
+	}
+
+	if cancelOrders := os.Getenv("SHUTDOWN_CANCEL_ORDERS"); cancelOrders != "" {
+		cancel, err := strconv.ParseBool(cancelOrders)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHUTDOWN_CANCEL_ORDERS: %w", err)
+		}
+		config.ShutdownCancelOrders = cancel
+	}
+
+	config.ConfigWatchInterval = DefaultConfigWatchInterval
+	if watchInterval := os.Getenv("CONFIG_WATCH_INTERVAL"); watchInterval != "" {
+		d, err := time.ParseDuration(watchInterval)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CONFIG_WATCH_INTERVAL: %s", watchInterval)
+		}
+		config.ConfigWatchInterval = d
+	}
+
+	notifiers, err := LoadNotifierConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Notifiers = notifiers
 
 	return config, nil
 }
+
+// LoadNotifierConfig loads the notification settings from environment variables. It does not need the rest
+// of the configuration, so that the notify-test command runs on its own.
+func LoadNotifierConfig() (*NotifierConfig, error) {
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
+	config := &NotifierConfig{
+		Options: notify.Options{
+			ImmediateLevel: DefaultNotifyImmediateLevel,
+			DigestInterval: DefaultNotifyDigestInterval,
+			DedupWindow:    DefaultNotifyDedupWindow,
+			RateLimit:      DefaultNotifyRateLimit,
+		},
+		TelegramLevel: DefaultTelegramNotifyLevel,
+		WebhookLevel:  DefaultWebhookNotifyLevel,
+		SlackLevel:    DefaultSlackNotifyLevel,
+		DiscordLevel:  DefaultDiscordNotifyLevel,
+	}
+
+	// Webhook URLs embed tokens, they are secrets
+	for name, value := range map[string]*secret.Secret{
+		"WEBHOOK_URL":         &config.WebhookURL,
+		"WEBHOOK_SECRET":      &config.WebhookSecret,
+		"SLACK_WEBHOOK_URL":   &config.SlackWebhookURL,
+		"DISCORD_WEBHOOK_URL": &config.DiscordWebhookURL,
+	} {
+		s, err := secret.FromEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		*value = s
+	}
+
+	for key, value := range map[string]*notify.Level{
+		"NOTIFY_IMMEDIATE_LEVEL": &config.Options.ImmediateLevel,
+		"TELEGRAM_NOTIFY_LEVEL":  &config.TelegramLevel,
+		"WEBHOOK_NOTIFY_LEVEL":   &config.WebhookLevel,
+		"SLACK_NOTIFY_LEVEL":     &config.SlackLevel,
+		"DISCORD_NOTIFY_LEVEL":   &config.DiscordLevel,
+	} {
+		if v := os.Getenv(key); v != "" {
+			level, err := notify.ParseLevel(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*value = level
+		}
+	}
+
+	for key, value := range map[string]*time.Duration{
+		"NOTIFY_DIGEST_INTERVAL": &config.Options.DigestInterval,
+		"NOTIFY_DEDUP_WINDOW":    &config.Options.DedupWindow,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s: %s", key, v)
+			}
+			*value = d
+		}
+	}
+
+	if rateLimit := os.Getenv("NOTIFY_RATE_LIMIT"); rateLimit != "" {
+		n, err := strconv.Atoi(rateLimit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid NOTIFY_RATE_LIMIT: %s", rateLimit)
+		}
+		config.Options.RateLimit = n
+	}
+
+	return config, nil
+}
+
+// LoadLogOptions loads the logger settings from environment variables, level comes from the command line
+func LoadLogOptions(level string) (logging.Options, error) {
+	if err := loadEnvFile(); err != nil {
+		return logging.Options{}, err
+	}
+
+	opts := logging.Options{
+		Level:           level,
+		Format:          DefaultLogFormat,
+		File:            os.Getenv("LOG_FILE"),
+		MaxSizeMB:       DefaultLogMaxSizeMB,
+		MaxAgeDays:      DefaultLogMaxAgeDays,
+		MaxBackups:      DefaultLogMaxBackups,
+		ComponentLevels: make(map[string]string),
+	}
+
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		switch format {
+		case logging.FormatText, logging.FormatJSON:
+			opts.Format = format
+		default:
+			return opts, fmt.Errorf("invalid LOG_FORMAT: %s", format)
+		}
+	}
+
+	for key, value := range map[string]*int{
+		"LOG_MAX_SIZE_MB":  &opts.MaxSizeMB,
+		"LOG_MAX_AGE_DAYS": &opts.MaxAgeDays,
+		"LOG_MAX_BACKUPS":  &opts.MaxBackups,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("invalid %s: %s", key, v)
+			}
+			*value = n
+		}
+	}
+
+	if interval := os.Getenv("LOG_ROTATE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid LOG_ROTATE_INTERVAL: %s", interval)
+		}
+		opts.RotateInterval = d
+	}
+
+	// Per component levels, as <component>=<level>,...
+	if levels := os.Getenv("LOG_LEVELS"); levels != "" {
+		for _, entry := range strings.Split(levels, ",") {
+			component, componentLevel, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || component == "" {
+				return opts, fmt.Errorf("invalid LOG_LEVELS entry: %s", entry)
+			}
+			opts.ComponentLevels[component] = componentLevel
+		}
+	}
+
+	return opts, nil
+}
+
+// loadRiskLimits loads the hard risk limits of the given market, unset limits are disabled
+func loadRiskLimits(tradingPair string) (risk.Limits, error) {
+	limits := risk.Limits{
+		MaxInventory:           make(map[string]decimal.Decimal),
+		MaxConsecutiveFailures: DefaultRiskMaxConsecutiveFailures,
+	}
+
+	for key, value := range map[string]*decimal.Decimal{
+		"RISK_MAX_NOTIONAL":   &limits.MaxNotional,
+		"RISK_MAX_DAILY_LOSS": &limits.MaxDailyLoss,
+		"RISK_MAX_DAILY_GAS":  &limits.MaxDailyGas,
+	} {
+		if v := getMarketEnv(tradingPair, key); v != "" {
+			d, err := decimal.NewFromString(v)
+			if err != nil || d.IsNegative() {
+				return limits, fmt.Errorf("invalid %s for %s: %s", key, tradingPair, v)
+			}
+			*value = d
+		}
+	}
+
+	for key, value := range map[string]*int{
+		"RISK_MAX_TRADES_PER_HOUR":      &limits.MaxTradesPerHour,
+		"RISK_MAX_CONSECUTIVE_FAILURES": &limits.MaxConsecutiveFailures,
+	} {
+		if v := getMarketEnv(tradingPair, key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return limits, fmt.Errorf("invalid %s for %s: %s", key, tradingPair, v)
+			}
+			*value = n
+		}
+	}
+
+	// Format: <token>=<amount>,...
+	if maxInventory := getMarketEnv(tradingPair, "RISK_MAX_INVENTORY"); maxInventory != "" {
+		for _, entry := range strings.Split(maxInventory, ",") {
+			token, amount, found := strings.Cut(strings.TrimSpace(entry), "=")
+			d, err := decimal.NewFromString(amount)
+			if !found || err != nil || d.IsNegative() {
+				return limits, fmt.Errorf("invalid RISK_MAX_INVENTORY entry for %s: %s", tradingPair, entry)
+			}
+			limits.MaxInventory[token] = d
+		}
+	}
+
+	return limits, nil
+}
+
+// loadSimulationConfig loads the swap simulation settings
+func loadSimulationConfig() (uniswap.SimulationConfig, error) {
+	simulation := uniswap.SimulationConfig{
+		MaxDivergenceBps: DefaultSimulationMaxDivergenceBps,
+		StorageSlots:     make(map[common.Address]uniswap.StorageSlots),
+	}
+
+	if maxDivergence := os.Getenv("SIMULATION_MAX_DIVERGENCE_BPS"); maxDivergence != "" {
+		bps, err := strconv.ParseInt(maxDivergence, 10, 64)
+		if err != nil || bps < 0 {
+			return simulation, fmt.Errorf("invalid SIMULATION_MAX_DIVERGENCE_BPS: %s", maxDivergence)
+		}
+		simulation.MaxDivergenceBps = bps
+	}
+
+	if stateOverride := os.Getenv("SIMULATION_STATE_OVERRIDE"); stateOverride != "" {
+		override, err := strconv.ParseBool(stateOverride)
+		if err != nil {
+			return simulation, fmt.Errorf("invalid SIMULATION_STATE_OVERRIDE: %w", err)
+		}
+		simulation.StateOverride = override
+	}
+
+	// Format: <token address>:<balance slot>:<allowance slot>,...
+	if storageSlots := os.Getenv("SIMULATION_STORAGE_SLOTS"); storageSlots != "" {
+		for _, entry := range strings.Split(storageSlots, ",") {
+			parts := strings.Split(strings.TrimSpace(entry), ":")
+			if len(parts) != 3 || !common.IsHexAddress(parts[0]) {
+				return simulation, fmt.Errorf("invalid SIMULATION_STORAGE_SLOTS entry: %s", entry)
+			}
+			balanceSlot, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return simulation, fmt.Errorf("invalid balance slot in SIMULATION_STORAGE_SLOTS: %s", entry)
+			}
+			allowanceSlot, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return simulation, fmt.Errorf("invalid allowance slot in SIMULATION_STORAGE_SLOTS: %s", entry)
+			}
+			simulation.StorageSlots[common.HexToAddress(parts[0])] = uniswap.StorageSlots{Balance: balanceSlot, Allowance: allowanceSlot}
+		}
+	}
+
+	return simulation, nil
+}
+
+// parsePaperBalances parses virtual balances given as <venue>:<token>=<amount>,...
+func parsePaperBalances(value string) (paper.Balances, error) {
+	balances := make(paper.Balances)
+	if value == "" {
+		return balances, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		venueToken, amount, found := strings.Cut(strings.TrimSpace(entry), "=")
+		venue, token, foundToken := strings.Cut(venueToken, ":")
+		if !found || !foundToken {
+			return nil, fmt.Errorf("invalid PAPER_BALANCES entry: %s", entry)
+		}
+		venue = strings.ToUpper(venue)
+		if venue != paper.VenueUniswap && venue != paper.VenueKucoin {
+			return nil, fmt.Errorf("invalid venue in PAPER_BALANCES entry: %s", entry)
+		}
+		d, err := decimal.NewFromString(amount)
+		if err != nil || d.IsNegative() {
+			return nil, fmt.Errorf("invalid amount in PAPER_BALANCES entry: %s", entry)
+		}
+		if balances[venue] == nil {
+			balances[venue] = make(map[string]decimal.Decimal)
+		}
+		balances[venue][token] = d
+	}
+
+	return balances, nil
+}
+
+// LoadMarketConfig loads the settings of the given market from environment variables.
+func LoadMarketConfig(tradingPair string) (*MarketConfig, error) {
+	_, token1 := utils.GetTokensFromTradingPair(tradingPair)
+	market := &MarketConfig{
+		TradingPair:  tradingPair,
+		ThresholdPct: DefaultThresholdPct,
+		Execution: execution.Config{
+			SlippageMode: DefaultSlippageMode,
+			SlippageBps:  DefaultSlippageBps,
+			Deadline:     DefaultSwapDeadline,
+			KucoinFeeBps: DefaultKucoinFeeBps,
+			MinProfitBps: DefaultMinProfitBps,
+			LegOrder:     DefaultLegOrder,
+			LegTimeout:   DefaultLegTimeout,
+			MarkInterval: DefaultMarkInterval,
+			// ETH is valued against the quote token on KuCoin, e.g. ETH-USDT
+			EthPriceSymbol: "ETH-" + token1,
+			HedgeOrder: kucoin.OrderOptions{
+				Type:         DefaultHedgeOrderType,
+				TimeInForce:  DefaultHedgeTimeInForce,
+				PriceBandBps: DefaultHedgePriceBandBps,
+			},
+			RebalanceOrder: kucoin.OrderOptions{
+				Type:         DefaultRebalanceOrderType,
+				TimeInForce:  DefaultRebalanceTimeInForce,
+				PostOnly:     true,
+				PriceBandBps: DefaultRebalancePriceBandBps,
+			},
+		},
+	}
+
+	poolAddress := getMarketEnv(tradingPair, "UNISWAP_POOL_ADDRESS")
+	if poolAddress == "" {
+		return nil, fmt.Errorf("%w for %s", ErrMissingUniswapPoolAddress, tradingPair)
+	}
+	if !common.IsHexAddress(poolAddress) {
+		return nil, fmt.Errorf("invalid UNISWAP_POOL_ADDRESS for %s: %s", tradingPair, poolAddress)
+	}
+	market.PoolAddress = common.HexToAddress(poolAddress)
+
+	if hdAccount := getMarketEnv(tradingPair, "HD_ACCOUNT"); hdAccount != "" {
+		index, err := strconv.Atoi(hdAccount)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid HD_ACCOUNT for %s: %s", tradingPair, hdAccount)
+		}
+		market.HDAccount = index
+	}
+
+	if threshold := getMarketEnv(tradingPair, "ARBITRAGE_THRESHOLD_PCT"); threshold != "" {
+		pct, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || pct <= 0 {
+			return nil, fmt.Errorf("invalid ARBITRAGE_THRESHOLD_PCT for %s: %s", tradingPair, threshold)
+		}
+		market.ThresholdPct = pct
+	}
+
+	if slippageMode := getMarketEnv(tradingPair, "SLIPPAGE_MODE"); slippageMode != "" {
+		switch slippageMode {
+		case uniswap.SlippageFixed, uniswap.SlippageImpact, uniswap.SlippageHedge:
+			market.Execution.SlippageMode = slippageMode
+		default:
+			return nil, fmt.Errorf("invalid SLIPPAGE_MODE for %s: %s", tradingPair, slippageMode)
+		}
+	}
+
+	for key, value := range map[string]*int64{
+		"SLIPPAGE_BPS":   &market.Execution.SlippageBps,
+		"KUCOIN_FEE_BPS": &market.Execution.KucoinFeeBps,
+		"MIN_PROFIT_BPS": &market.Execution.MinProfitBps,
+	} {
+		if v := getMarketEnv(tradingPair, key); v != "" {
+			bps, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || bps < 0 {
+				return nil, fmt.Errorf("invalid %s for %s: %s", key, tradingPair, v)
+			}
+			*value = bps
+		}
+	}
+
+	if deadline := getMarketEnv(tradingPair, "SWAP_DEADLINE"); deadline != "" {
+		d, err := time.ParseDuration(deadline)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SWAP_DEADLINE for %s: %s", tradingPair, deadline)
+		}
+		market.Execution.Deadline = d
+	}
+
+	if legOrder := getMarketEnv(tradingPair, "LEG_ORDER"); legOrder != "" {
+		switch legOrder {
+		case execution.LegOrderDexFirst, execution.LegOrderCexFirst, execution.LegOrderSimultaneous:
+			market.Execution.LegOrder = legOrder
+		default:
+			return nil, fmt.Errorf("invalid LEG_ORDER for %s: %s", tradingPair, legOrder)
+		}
+	}
+
+	if legTimeout := getMarketEnv(tradingPair, "LEG_TIMEOUT"); legTimeout != "" {
+		d, err := time.ParseDuration(legTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid LEG_TIMEOUT for %s: %s", tradingPair, legTimeout)
+		}
+		market.Execution.LegTimeout = d
+	}
+
+	if markInterval := getMarketEnv(tradingPair, "PNL_MARK_INTERVAL"); markInterval != "" {
+		d, err := time.ParseDuration(markInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid PNL_MARK_INTERVAL for %s: %s", tradingPair, markInterval)
+		}
+		market.Execution.MarkInterval = d
+	}
+
+	if ethPriceSymbol := getMarketEnv(tradingPair, "PNL_ETH_PRICE_SYMBOL"); ethPriceSymbol != "" {
+		market.Execution.EthPriceSymbol = ethPriceSymbol
+	}
+
+	for prefix, opts := range map[string]*kucoin.OrderOptions{
+		"HEDGE":     &market.Execution.HedgeOrder,
+		"REBALANCE": &market.Execution.RebalanceOrder,
+	} {
+		if err := loadOrderOptions(tradingPair, prefix, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	limits, err := loadRiskLimits(tradingPair)
+	if err != nil {
+		return nil, err
+	}
+	market.Risk = limits
+
+	return market, nil
+}
+
+// loadOrderOptions loads the KuCoin order options of a leg, e.g. HEDGE_ORDER_TYPE
+func loadOrderOptions(tradingPair, prefix string, opts *kucoin.OrderOptions) error {
+	if orderType := getMarketEnv(tradingPair, prefix+"_ORDER_TYPE"); orderType != "" {
+		switch orderType {
+		case kucoin.OrderTypeLimit, kucoin.OrderTypeMarket:
+			opts.Type = orderType
+		default:
+			return fmt.Errorf("invalid %s_ORDER_TYPE for %s: %s", prefix, tradingPair, orderType)
+		}
+	}
+
+	if timeInForce := getMarketEnv(tradingPair, prefix+"_TIME_IN_FORCE"); timeInForce != "" {
+		switch timeInForce {
+		case kucoin.TimeInForceGTC, kucoin.TimeInForceIOC, kucoin.TimeInForceFOK:
+			opts.TimeInForce = timeInForce
+		default:
+			return fmt.Errorf("invalid %s_TIME_IN_FORCE for %s: %s", prefix, tradingPair, timeInForce)
+		}
+	}
+
+	if postOnly := getMarketEnv(tradingPair, prefix+"_POST_ONLY"); postOnly != "" {
+		p, err := strconv.ParseBool(postOnly)
+		if err != nil {
+			return fmt.Errorf("invalid %s_POST_ONLY for %s: %w", prefix, tradingPair, err)
+		}
+		opts.PostOnly = p
+	}
+
+	if priceBand := getMarketEnv(tradingPair, prefix+"_PRICE_BAND_BPS"); priceBand != "" {
+		bps, err := strconv.ParseInt(priceBand, 10, 64)
+		if err != nil || bps < 0 || bps >= 10000 {
+			return fmt.Errorf("invalid %s_PRICE_BAND_BPS for %s: %s", prefix, tradingPair, priceBand)
+		}
+		opts.PriceBandBps = bps
+	}
+
+	// KuCoin only accepts post-only on limit orders resting on the book
+	if opts.PostOnly && (opts.Type != kucoin.OrderTypeLimit || opts.TimeInForce != kucoin.TimeInForceGTC) {
+		return fmt.Errorf("%s_POST_ONLY for %s requires a GTC limit order", prefix, tradingPair)
+	}
+
+	return nil
+}
+
+// getMarketEnv returns the market specific value of key, falling back to the global one
+func getMarketEnv(tradingPair, key string) string {
+	prefix := strings.ToUpper(strings.ReplaceAll(tradingPair, "-", "_"))
+	if v := os.Getenv(prefix + "_" + key); v != "" {
+		return v
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// inEnvDir runs the test in a new directory holding the .env file, with the environment loaded from scratch
+func inEnvDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	savedProcessEnv, savedFileEnv := processEnv, fileEnv
+	processEnv, fileEnv = nil, nil
+	t.Cleanup(func() {
+		for name := range fileEnv {
+			if !processEnv[name] {
+				os.Unsetenv(name)
+			}
+		}
+		processEnv, fileEnv = savedProcessEnv, savedFileEnv
+		_ = os.Chdir(wd)
+	})
+}
+
+// writeEnvFile writes content to the .env file
+func writeEnvFile(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(envFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkEnv fails unless every variable is set to its value, or unset if the value is empty
+func checkEnv(t *testing.T, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		got, ok := os.LookupEnv(name)
+		switch {
+		case value == "" && ok:
+			t.Errorf("%s = %q, want it unset", name, got)
+		case value != "" && got != value:
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	t.Setenv("ARBITRAGE_TEST_PROCESS", "process")
+	inEnvDir(t)
+
+	writeEnvFile(t, "ARBITRAGE_TEST_A=1\nARBITRAGE_TEST_B=2\nARBITRAGE_TEST_PROCESS=file\n")
+	if err := loadEnvFile(); err != nil {
+		t.Fatalf("loadEnvFile: %v", err)
+	}
+	checkEnv(t, map[string]string{"ARBITRAGE_TEST_A": "1", "ARBITRAGE_TEST_B": "2", "ARBITRAGE_TEST_PROCESS": "process"})
+
+	// A reload applies the changes, variables removed from the file are unset, the process environment still wins
+	writeEnvFile(t, "ARBITRAGE_TEST_A=3\nARBITRAGE_TEST_C=4\n")
+	if err := loadEnvFile(); err != nil {
+		t.Fatalf("loadEnvFile: %v", err)
+	}
+	checkEnv(t, map[string]string{"ARBITRAGE_TEST_A": "3", "ARBITRAGE_TEST_B": "", "ARBITRAGE_TEST_C": "4", "ARBITRAGE_TEST_PROCESS": "process"})
+}
+
+func TestLoadEnvFileMissing(t *testing.T) {
+	inEnvDir(t)
+
+	writeEnvFile(t, "ARBITRAGE_TEST_A=1\n")
+	if err := loadEnvFile(); err != nil {
+		t.Fatalf("loadEnvFile: %v", err)
+	}
+
+	// Without a file the variables it set are unset
+	if err := os.Remove(envFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEnvFile(); err != nil {
+		t.Fatalf("loadEnvFile without a .env file: %v", err)
+	}
+	checkEnv(t, map[string]string{"ARBITRAGE_TEST_A": ""})
+}
+
+func TestLoadEnvFileInvalid(t *testing.T) {
+	inEnvDir(t)
+
+	writeEnvFile(t, "ARBITRAGE_TEST_A=\"unterminated\n")
+	if err := loadEnvFile(); err == nil {
+		t.Error("loadEnvFile of an invalid file succeeded, want an error")
+	}
+}
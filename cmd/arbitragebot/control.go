@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"rattrap/arbitrage-bot/internal/arbitrage"
+	"rattrap/arbitrage-bot/internal/control"
+	"rattrap/arbitrage-bot/internal/execution"
+	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/ledger"
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/risk"
+	"rattrap/arbitrage-bot/internal/uniswap"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// marketState is the state of a market served by the control API
+type marketState struct {
+	Market       string       `json:"market"`
+	Mode         string       `json:"mode"`
+	Halted       bool         `json:"halted"`
+	HaltReason   string       `json:"halt_reason,omitempty"`
+	UniswapPrice float64      `json:"uniswap_price"`
+	KucoinPrice  float64      `json:"kucoin_price"`
+	SpreadPct    float64      `json:"spread_pct"`
+	Params       marketParams `json:"params"`
+	Risk         riskState    `json:"risk"`
+	Balances     pnl.Balances `json:"balances"`
+	Position     pnl.Position `json:"position"`
+	LastMark     *pnl.Mark    `json:"last_mark,omitempty"`
+}
+
+// riskState is the risk state of a market
+type riskState struct {
+	TradesLastHour      int                        `json:"trades_last_hour"`
+	DailyRealizedPnL    decimal.Decimal            `json:"daily_realized_pnl"`
+	DailyGas            decimal.Decimal            `json:"daily_gas"`
+	Inventory           map[string]decimal.Decimal `json:"inventory"`
+	ConsecutiveFailures int                        `json:"consecutive_failures"`
+}
+
+// marketParams are the strategy parameters of a market that can be changed at runtime
+type marketParams struct {
+	ThresholdPct float64 `json:"threshold_pct"`
+	SlippageMode string  `json:"slippage_mode"`
+	SlippageBps  int64   `json:"slippage_bps"`
+	KucoinFeeBps int64   `json:"kucoin_fee_bps"`
+	MinProfitBps int64   `json:"min_profit_bps"`
+	LegOrder     string  `json:"leg_order"`
+}
+
+// paramsUpdate changes the parameters that are set
+type paramsUpdate struct {
+	ThresholdPct *float64 `json:"threshold_pct"`
+	SlippageMode *string  `json:"slippage_mode"`
+	SlippageBps  *int64   `json:"slippage_bps"`
+	KucoinFeeBps *int64   `json:"kucoin_fee_bps"`
+	MinProfitBps *int64   `json:"min_profit_bps"`
+	LegOrder     *string  `json:"leg_order"`
+}
+
+// rebalanceRequest is a manual rebalance order
+type rebalanceRequest struct {
+	Side string `json:"side"`
+	Size string `json:"size"`
+}
+
+// resumeRequest releases the kill switch, the reason is logged
+type resumeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// transactionsState lists the swaps in flight of the account trading a market
+type transactionsState struct {
+	Account   string      `json:"account"`
+	NextNonce uint64      `json:"next_nonce"`
+	Pending   []ledger.Tx `json:"pending"`
+}
+
+// registerControl registers the endpoints of the control API, the market endpoints serve the ready markets
+func registerControl(server *control.Server, currentConfig func() *Config, markets *markets, tradeLedger *ledger.Ledger) {
+	// onMarket serves the requests on a ready market
+	onMarket := func(handler func(r *http.Request, m *market) (interface{}, error)) control.Handler {
+		return func(r *http.Request) (interface{}, error) {
+			m := markets.get(r.PathValue("market"))
+			if m == nil {
+				return nil, control.Errorf(http.StatusNotFound, "unknown market %s", r.PathValue("market"))
+			}
+			return handler(r, m)
+		}
+	}
+
+	server.Handle("GET /v1/config", func(r *http.Request) (interface{}, error) {
+		return currentConfig(), nil
+	})
+
+	// The ledger is locked while the bot runs, exports read this backup instead
+	server.HandleStream("GET /v1/ledger/backup", func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		return tradeLedger.Backup(w)
+	})
+
+	server.Handle("GET /v1/markets", func(r *http.Request) (interface{}, error) {
+		list := markets.list()
+		states := make([]marketState, 0, len(list))
+		for _, m := range list {
+			states = append(states, state(m))
+		}
+		return states, nil
+	})
+
+	server.Handle("GET /v1/markets/{market}", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		return state(m), nil
+	}))
+
+	server.Handle("POST /v1/markets/{market}/pause", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		if halted, reason := m.risk.IsHalted(); halted {
+			return nil, control.Errorf(http.StatusConflict, "trading is already halted: %s", reason)
+		}
+		m.risk.Halt(pauseReason)
+		return state(m), nil
+	}))
+
+	server.Handle("POST /v1/markets/{market}/resume", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		if halted, _ := m.risk.IsHalted(); !halted {
+			return nil, control.Errorf(http.StatusConflict, "trading is not paused")
+		}
+		var request resumeRequest
+		if err := control.Decode(r, &request); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(request.Reason) == "" {
+			return nil, control.Errorf(http.StatusBadRequest, "missing reason")
+		}
+		if err := m.risk.Resume(request.Reason); err != nil {
+			return nil, control.Errorf(http.StatusConflict, "%s", err)
+		}
+		return state(m), nil
+	}))
+
+	server.Handle("PATCH /v1/markets/{market}/params", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		var update paramsUpdate
+		if err := control.Decode(r, &update); err != nil {
+			return nil, err
+		}
+		if err := setParams(update, m.arbitrage, m.executor); err != nil {
+			return nil, control.Errorf(http.StatusBadRequest, "%s", err)
+		}
+		return getParams(m.arbitrage, m.executor), nil
+	}))
+
+	server.Handle("POST /v1/markets/{market}/balances/refresh", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		balances := m.executor.SnapshotBalances()
+		if balances == nil {
+			return nil, control.Errorf(http.StatusBadGateway, "failed to read the balances, see the logs")
+		}
+		return balances, nil
+	}))
+
+	server.Handle("POST /v1/markets/{market}/rebalance", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		var request rebalanceRequest
+		if err := control.Decode(r, &request); err != nil {
+			return nil, err
+		}
+		if request.Side != "buy" && request.Side != "sell" {
+			return nil, control.Errorf(http.StatusBadRequest, "invalid side %q, expected buy or sell", request.Side)
+		}
+		if size, err := decimal.NewFromString(request.Size); err != nil || !size.IsPositive() {
+			return nil, control.Errorf(http.StatusBadRequest, "invalid size %q", request.Size)
+		}
+
+		result, err := m.executor.Rebalance(request.Side, request.Size)
+		switch {
+		case errors.Is(err, execution.ErrDraining), errors.Is(err, risk.ErrHalted), errors.Is(err, risk.ErrLimitExceeded):
+			return nil, control.Errorf(http.StatusConflict, "%s", err)
+		case errors.Is(err, kucoin.ErrOrderTooSmall), errors.Is(err, kucoin.ErrOutsidePriceBand):
+			return nil, control.Errorf(http.StatusBadRequest, "%s", err)
+		case err != nil:
+			return nil, err
+		}
+		return result, nil
+	}))
+
+	server.Handle("GET /v1/markets/{market}/orders", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		// Paper orders are filled or left unfilled on the spot, none rests on the book
+		if paperTrading {
+			return []*kucoin.OrderResult{}, nil
+		}
+		return m.kucoin.OpenOrders(r.Context())
+	}))
+
+	server.Handle("DELETE /v1/markets/{market}/orders", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		if paperTrading {
+			return nil, control.Errorf(http.StatusConflict, "no KuCoin orders in paper trading mode")
+		}
+		orderIDs, err := m.kucoin.CancelOpenOrders(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]string{"cancelled": orderIDs}, nil
+	}))
+
+	server.Handle("DELETE /v1/markets/{market}/orders/{id}", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		if paperTrading {
+			return nil, control.Errorf(http.StatusConflict, "no KuCoin orders in paper trading mode")
+		}
+		if err := m.kucoin.CancelOrder(r.Context(), r.PathValue("id")); err != nil {
+			return nil, err
+		}
+		return map[string][]string{"cancelled": {r.PathValue("id")}}, nil
+	}))
+
+	server.Handle("GET /v1/markets/{market}/transactions", onMarket(func(r *http.Request, m *market) (interface{}, error) {
+		return transactionsState{
+			Account:   m.uniswap.Address().Hex(),
+			NextNonce: m.uniswap.GetNonce(),
+			Pending:   m.executor.PendingTxs(),
+		}, nil
+	}))
+}
+
+// state returns the state of m
+func state(m *market) marketState {
+	uniswapPrice, kucoinPrice := m.pricing.GetPrices()
+	status := m.risk.GetStatus()
+	s := marketState{
+		Market:       m.pair,
+		Mode:         "live",
+		Halted:       status.Halted,
+		HaltReason:   status.HaltReason,
+		UniswapPrice: uniswapPrice,
+		KucoinPrice:  kucoinPrice,
+		Params:       getParams(m.arbitrage, m.executor),
+		Risk: riskState{
+			TradesLastHour:      status.TradesLastHour,
+			DailyRealizedPnL:    status.DailyRealizedPnL,
+			DailyGas:            status.DailyGas,
+			Inventory:           status.Inventory,
+			ConsecutiveFailures: status.ConsecutiveFailures,
+		},
+		Balances: m.executor.LastBalances(),
+		Position: m.pnl.GetPosition(),
+	}
+	if paperTrading {
+		s.Mode = "paper"
+	}
+	if uniswapPrice != 0 {
+		s.SpreadPct = (kucoinPrice - uniswapPrice) / uniswapPrice * 100
+	}
+	if history := m.pnl.GetHistory(); len(history) > 0 {
+		s.LastMark = &history[len(history)-1]
+	}
+	return s
+}
+
+// getParams returns the current strategy parameters
+func getParams(arbitrageService *arbitrage.ArbitrageService, executor *execution.Executor) marketParams {
+	config := executor.GetConfig()
+	return marketParams{
+		ThresholdPct: arbitrageService.GetThreshold(),
+		SlippageMode: config.SlippageMode,
+		SlippageBps:  config.SlippageBps,
+		KucoinFeeBps: config.KucoinFeeBps,
+		MinProfitBps: config.MinProfitBps,
+		LegOrder:     config.LegOrder,
+	}
+}
+
+// setParams validates and applies the parameters set in update, all of them or none
+func setParams(update paramsUpdate, arbitrageService *arbitrage.ArbitrageService, executor *execution.Executor) error {
+	if update.ThresholdPct != nil && *update.ThresholdPct <= 0 {
+		return errors.New("invalid threshold_pct, must be positive")
+	}
+
+	config := executor.GetConfig()
+	if update.SlippageMode != nil {
+		switch *update.SlippageMode {
+		case uniswap.SlippageFixed, uniswap.SlippageImpact, uniswap.SlippageHedge:
+			config.SlippageMode = *update.SlippageMode
+		default:
+			return errors.New("invalid slippage_mode " + *update.SlippageMode)
+		}
+	}
+	for name, value := range map[string]struct {
+		update *int64
+		field  *int64
+	}{
+		"slippage_bps":   {update.SlippageBps, &config.SlippageBps},
+		"kucoin_fee_bps": {update.KucoinFeeBps, &config.KucoinFeeBps},
+		"min_profit_bps": {update.MinProfitBps, &config.MinProfitBps},
+	} {
+		if value.update == nil {
+			continue
+		}
+		if *value.update < 0 {
+			return errors.New("invalid " + name + ", must not be negative")
+		}
+		*value.field = *value.update
+	}
+	if update.LegOrder != nil {
+		switch *update.LegOrder {
+		case execution.LegOrderDexFirst, execution.LegOrderCexFirst, execution.LegOrderSimultaneous:
+			config.LegOrder = *update.LegOrder
+		default:
+			return errors.New("invalid leg_order " + *update.LegOrder)
+		}
+	}
+
+	if update.ThresholdPct != nil {
+		arbitrageService.SetThreshold(*update.ThresholdPct)
+	}
+	if config != executor.GetConfig() {
+		executor.SetConfig(config)
+	}
+	return nil
+}
+
+// isLoopback returns whether the listen address addr is only reachable from the local host
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"rattrap/arbitrage-bot/internal/export"
+	"rattrap/arbitrage-bot/internal/ledger"
+	"time"
+)
+
+// dateLayout is the layout of date only bounds
+const dateLayout = "2006-01-02"
+
+// backupTimeout bounds the download of a ledger backup from the running bot
+const backupTimeout = 5 * time.Minute
+
+// runExport implements the export subcommand: it dumps the trades of the ledger for a date range
+func runExport(args []string) error {
+	if err := loadEnvFile(); err != nil {
+		return err
+	}
+
+	ledgerPath := os.Getenv("LEDGER_PATH")
+	if ledgerPath == "" {
+		ledgerPath = DefaultLedgerPath
+	}
+
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.StringVar(&ledgerPath, "ledger", ledgerPath, "Path of the trade ledger database")
+	format := fs.String("format", export.FormatCSV, "Output format (csv, jsonl)")
+	fromArg := fs.String("from", "", "Start of the range, inclusive (YYYY-MM-DD or RFC 3339)")
+	toArg := fs.String("to", "", "End of the range (YYYY-MM-DD, inclusive, or RFC 3339, exclusive)")
+	output := fs.String("output", "", "Output file (default: standard output)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *format != export.FormatCSV && *format != export.FormatJSONL {
+		return fmt.Errorf("invalid format: %s", *format)
+	}
+
+	from, err := parseBound(*fromArg, false)
+	if err != nil {
+		return fmt.Errorf("invalid from: %w", err)
+	}
+	to, err := parseBound(*toArg, true)
+	if err != nil {
+		return fmt.Errorf("invalid to: %w", err)
+	}
+
+	// The running bot locks the ledger, it then serves a backup through the control API
+	tradeLedger, err := ledger.OpenReadOnly(ledgerPath)
+	if errors.Is(err, ledger.ErrLocked) && os.Getenv("CONTROL_ADDR") != "" {
+		backupPath, backupErr := downloadBackup(os.Getenv("CONTROL_ADDR"), os.Getenv("CONTROL_TOKEN"))
+		if backupErr != nil {
+			return fmt.Errorf("%w, and %w", err, backupErr)
+		}
+		defer os.Remove(backupPath)
+		tradeLedger, err = ledger.OpenReadOnly(backupPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer tradeLedger.Close()
+
+	trades, err := tradeLedger.ListTrades(from, to)
+	if err != nil {
+		return fmt.Errorf("Failed to list trades: %w", err)
+	}
+
+	addresses, err := tradeLedger.TokenAddresses()
+	if err != nil {
+		return fmt.Errorf("Failed to read token addresses: %w", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("Failed to create %s: %w", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return export.Write(w, *format, export.Rows(trades, addresses))
+}
+
+// downloadBackup downloads a backup of the ledger from the control API listening on addr to a temporary
+// file, and returns its path
+func downloadBackup(addr, token string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CONTROL_ADDR: %w", err)
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	request, err := http.NewRequest(http.MethodGet, "http://"+net.JoinHostPort(host, port)+"/v1/ledger/backup", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := (&http.Client{Timeout: backupTimeout}).Do(request)
+	if err != nil {
+		return "", fmt.Errorf("Failed to download the ledger from the control API: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to download the ledger from the control API: status %d", response.StatusCode)
+	}
+
+	f, err := os.CreateTemp("", "ledger-*.db")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create the ledger backup: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, response.Body); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("Failed to download the ledger from the control API: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// parseBound parses a range bound. A date only end bound covers the whole day.
+func parseBound(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
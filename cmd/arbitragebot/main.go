@@ -3,18 +3,34 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"rattrap/arbitrage-bot/internal/arbitrage"
-	"rattrap/arbitrage-bot/internal/execution"
-	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/control"
+	"rattrap/arbitrage-bot/internal/health"
+	"rattrap/arbitrage-bot/internal/ledger"
 	"rattrap/arbitrage-bot/internal/logging"
-	"rattrap/arbitrage-bot/internal/pricing"
+	"rattrap/arbitrage-bot/internal/metrics"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/supervisor"
 	"rattrap/arbitrage-bot/internal/telegram"
-	"rattrap/arbitrage-bot/internal/uniswap"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// Exit codes of the bot
+const (
+	exitOK            = 0
+	exitStartupError  = 1 // The configuration, the signer or the ledger could not be loaded
+	exitDrainTimeout  = 2 // A trade was still in flight when the shutdown timed out
+	exitShutdownError = 3 // Open orders could not be cancelled, a service did not stop or the ledger could not be flushed
+)
+
+// stopTimeout bounds the stop of the services once the trade in flight is done
+const stopTimeout = 30 * time.Second
+
 var (
 	paperTrading bool
 	logLevel     string
@@ -23,11 +39,32 @@ var (
 func init() {
 	flag.BoolVar(&paperTrading, "paper", false, "Enable paper trading mode")
 	flag.StringVar(&logLevel, "logLevel", "debug", "Log level (debug, info, warn, error, fatal, panic)")
-	flag.Parse()
 }
 
 func main() {
-	logger := logging.MakeLogger(logLevel)
+	flag.Parse()
+	logOptions, err := LoadLogOptions(logLevel)
+	if err != nil {
+		logging.MakeLogger(logLevel).WithError(err).Fatal("Failed to load logging configuration")
+	}
+	logger, err := logging.NewLogger(logOptions)
+	if err != nil {
+		logging.MakeLogger(logLevel).WithError(err).Fatal("Failed to initialize the logger")
+	}
+
+	if flag.Arg(0) == "export" {
+		if err := runExport(flag.Args()[1:]); err != nil {
+			logger.WithError(err).Fatal("Failed to export trades")
+		}
+		return
+	}
+
+	if flag.Arg(0) == "notify-test" {
+		if err := runNotifyTest(flag.Args()[1:], logger); err != nil {
+			logger.WithError(err).Fatal("Failed to send the test notification")
+		}
+		return
+	}
 
 	config, err := LoadConfig()
 	if err != nil {
@@ -42,60 +79,234 @@ func main() {
 
 	logger.Debugf("Loaded configuration %+v", config)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// An interrupt stops the bot, also while it is starting
+	runCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP reloads the configuration, the reloads received while starting are applied once it started
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
 
 	// Initialize Telegram service
-	telegramService := telegram.NewTelegramService(config.TelegramBotToken, config.TelegramChannelID)
-	err = telegramService.SendMessage("Arbitrage bot started")
-	if err != nil {
-		logger.WithError(err).Fatal("Failed to send message to Telegram")
+	telegramService := telegram.NewTelegramService(config.TelegramBotToken.Reveal(), config.TelegramChannelID, logger)
+
+	// Notifications are routed to the notifiers, their failures never stop trading
+	notifier := notify.NewDispatcher(config.Notifiers.Options, logger)
+	if telegramService.IsStarted() {
+		notifier.Add(telegramService, config.Notifiers.TelegramLevel)
+	} else {
+		logger.Warn("Telegram is not connected, notifications are not sent to Telegram")
+	}
+	for _, n := range webhookNotifiers(config.Notifiers) {
+		notifier.Add(n.notifier, n.level)
 	}
+	notifier.Notify(notify.LevelInfo, "", "Arbitrage bot started")
 
-	// Initialize Uniswap client
-	err, uniswapClient := uniswap.NewUniswapClient(config.TradingPair, config.EthereumRPCURL, config.UniswapPoolAddress, config.UniswapTickLensAddress, config.EthereumPrivateKey, ctx)
+	// Initialize the signer of Ethereum transactions, each market signs from its own account with the
+	// mnemonic signer
+	signers, err := newSigners(config, logger)
 	if err != nil {
-		logger.WithError(err).Fatal("Failed to initialize Uniswap client")
+		abort(fmt.Errorf("Failed to initialize the transaction signer: %w", err), notifier, logger)
+	}
+	logger.Infof("Signing transactions with the %s signer", config.SignerType)
+
+	// Paper trading runs against virtual balances
+	if paperTrading && len(config.PaperBalances) == 0 {
+		abort(fmt.Errorf("PAPER_BALANCES is required in paper trading mode"), notifier, logger)
 	}
 
-	// Initialize KuCoin API client
-	err, kucoinClient := kucoin.NewKucoinClient(config.TradingPair, config.KucoinAPIKey, config.KucoinAPISecret, config.KucoinAPIPassphrase, ctx)
+	// Every trade is recorded in the ledger
+	tradeLedger, err := ledger.Open(config.LedgerPath)
 	if err != nil {
-		logger.WithError(err).Fatal("Failed to initialize KuCoin client")
+		abort(fmt.Errorf("Failed to open the trade ledger: %w", err), notifier, logger)
 	}
 
-	// Start fetching prices
-	priceService := pricing.NewPricingService(uniswapClient, kucoinClient, logger)
-	priceService.Start()
+	// Components checked by the health endpoints
+	checker := health.NewChecker()
+
+	// Services are started in dependency order and restarted when they fail
+	sup := supervisor.NewSupervisor(checker, logger)
+	sup.Add(supervisor.Component{Name: "notify", Run: notifier.Run})
 
-	// Start the execution service
-	execution := execution.NewExecutor(paperTrading, config.TradingPair, uniswapClient, kucoinClient, logger)
-	execution.Start()
+	// Expose Prometheus metrics and health endpoints, also while the venues are connecting
+	if config.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", checker.Handler(health.Liveness))
+		mux.Handle("/readyz", checker.Handler(health.Liveness, health.Readiness))
+		sup.Add(supervisor.Component{Name: "monitoring", Run: func(ctx context.Context) error {
+			return serve(ctx, config.MetricsAddr, mux)
+		}})
+	}
 
-	// Start arbitrage detection and execution loop
-	arbitrageService := arbitrage.NewArbitrageService(priceService, execution, telegramService, logger)
+	// Receive operator commands from the Telegram admins
+	commands := telegramService.IsStarted() && len(config.TelegramAdminIDs) > 0
 
-	// Run the arbitrage loop
-	arbitrageService.RunArbitrageLoop()
+	// Each market runs its own services, the reloads apply to the markets running
+	markets := newMarkets(paperTrading, tradeLedger, notifier, checker, sup, signers, logger)
+	reloader := newReloader(config, reloadSignal, commands, notifier, telegramService, markets, logger)
+	markets.currentConfig = reloader.Config
+	for _, pair := range config.TradingPairs() {
+		m, err := markets.create(config.Markets[pair])
+		if err != nil {
+			_ = tradeLedger.Close()
+			abort(err, notifier, logger)
+		}
+		for _, c := range markets.components(m) {
+			sup.Add(c)
+		}
+	}
 
-	// Handle interrupt signals
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	if err := sup.Start(runCtx); err != nil {
+		logger.WithError(err).Warn("Interrupted before the markets were started, shutting down")
+		stopCtx, cancelStop := context.WithTimeout(context.Background(), stopTimeout)
+		_ = sup.Stop(stopCtx)
+		cancelStop()
+		_ = tradeLedger.Close()
+		notifier.Close()
+		_ = logger.Close()
+		os.Exit(exitOK)
+	}
 
-	go func() {
-		<-signals
-		logger.Debug("Received an interrupt, closing connections...")
+	registerCommands(telegramService, markets)
+	if telegramService.IsStarted() && !commands {
+		logger.Info("No Telegram admin configured, commands are disabled")
+	} else if commands {
+		telegramService.SetAdmins(config.TelegramAdminIDs)
+		logger.Infof("Receiving commands from %d Telegram users", len(config.TelegramAdminIDs))
+		sup.Add(supervisor.Component{Name: "commands", Run: telegramService.RunCommands})
+	}
+
+	// Reload the configuration on SIGHUP or when the .env file changes
+	sup.Add(supervisor.Component{Name: "reload", Run: reloader.Run})
 
-		arbitrageService.Close()
-		priceService.Close()
-		kucoinClient.Close()
-		uniswapClient.Close()
+	// Serve the control API to the local operators
+	if config.ControlAddr != "" {
+		if !isLoopback(config.ControlAddr) {
+			logger.Warnf("The control API listens on %s, reachable beyond the local host", config.ControlAddr)
+		}
+		controlServer := control.NewServer(config.ControlToken.Reveal(), logger)
+		registerControl(controlServer, reloader.Config, markets, tradeLedger)
+		sup.Add(supervisor.Component{Name: "control", Run: func(ctx context.Context) error {
+			return serve(ctx, config.ControlAddr, controlServer)
+		}})
+	}
 
-		cancel() // Cancel the context to stop any ongoing operations
+	checker.Register("telegram", health.Info, func(ctx context.Context) error {
+		if !telegramService.IsStarted() {
+			return fmt.Errorf("not connected")
+		}
+		return nil
+	})
 
-		logger.Debug("Shutdown complete.")
-		os.Exit(0)
+	// Run the services until an interrupt is received
+	if err := sup.Start(runCtx); err != nil {
+		logger.WithError(err).Warn("Interrupted while starting the services")
+	}
+
+	// Resume trading on every market after the kill switch was engaged
+	resume := make(chan os.Signal, 1)
+	signal.Notify(resume, syscall.SIGUSR1)
+
+	go func() {
+		for range resume {
+			for _, m := range markets.list() {
+				if halted, _ := m.risk.IsHalted(); !halted {
+					continue
+				}
+				if err := m.risk.Resume("SIGUSR1"); err != nil {
+					logger.WithField("market", m.pair).WithError(err).Warn("Refused to resume trading")
+				}
+			}
+		}
 	}()
 
-	// Keep the main goroutine running until an interrupt is received
-	<-ctx.Done()
+	<-runCtx.Done()
+	stop() // A second interrupt kills the process
+	signal.Stop(reloadSignal)
+	config = reloader.Config()
+	logger.Infof("Received an interrupt, shutting down within %s...", config.ShutdownTimeout)
+	code := exitOK
+
+	// Stop taking trades on every market at once and wait for those in flight to reach a terminal state
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	var drained sync.WaitGroup
+	var drainLock sync.Mutex
+	for _, m := range markets.list() {
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			if err := m.executor.Drain(drainCtx); err != nil {
+				logger.WithField("market", m.pair).WithError(err).Error("Shutdown timed out before the trade in flight finished, check the ledger and balances")
+				drainLock.Lock()
+				code = exitDrainTimeout
+				drainLock.Unlock()
+			}
+		}()
+	}
+	drained.Wait()
+	cancelDrain()
+
+	if config.ShutdownCancelOrders && !paperTrading {
+		for _, m := range markets.list() {
+			cancelCtx, cancelOrders := context.WithTimeout(context.Background(), 30*time.Second)
+			orderIDs, err := m.kucoin.CancelOpenOrders(cancelCtx)
+			cancelOrders()
+			if err != nil {
+				logger.WithField("market", m.pair).WithError(err).Error("Failed to cancel the open KuCoin orders")
+				code = max(code, exitShutdownError)
+			} else {
+				logger.WithField("market", m.pair).Infof("Cancelled %d open KuCoin orders %v", len(orderIDs), orderIDs)
+			}
+		}
+	}
+
+	// Services stop in reverse start order, each one before its dependencies
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), stopTimeout)
+	if err := sup.Stop(stopCtx); err != nil {
+		logger.WithError(err).Error("Shutdown timed out before the services stopped")
+		code = max(code, exitShutdownError)
+	}
+	cancelStop()
+
+	if err := tradeLedger.Close(); err != nil {
+		logger.WithError(err).Error("Failed to close the trade ledger")
+		code = max(code, exitShutdownError)
+	}
+
+	if code == exitOK {
+		notifier.Notify(notify.LevelInfo, "", "Arbitrage bot stopped")
+	} else {
+		notifier.Notify(notify.LevelCritical, "", fmt.Sprintf("Arbitrage bot stopped uncleanly (exit code %d), check the logs", code))
+	}
+	notifier.Close()
+
+	logger.Infof("Shutdown complete with exit code %d", code)
+	_ = logger.Close()
+	os.Exit(code)
+}
+
+// abort stops a bot that failed to start before any service ran: the failure is logged and notified, and
+// the bot exits with exitStartupError
+func abort(err error, notifier *notify.Dispatcher, logger *logging.Logger) {
+	logger.WithError(err).Error("Failed to start the bot")
+	notifier.Notify(notify.LevelCritical, "", "Arbitrage bot failed to start: "+err.Error())
+	notifier.Close()
+	_ = logger.Close()
+	os.Exit(exitStartupError)
+}
+
+// serve serves handler on addr until ctx is cancelled
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	errChan := make(chan error, 1)
+	go func() { errChan <- server.ListenAndServe() }()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
 }
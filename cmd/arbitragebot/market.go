@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/arbitrage"
+	"rattrap/arbitrage-bot/internal/execution"
+	"rattrap/arbitrage-bot/internal/health"
+	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/ledger"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/metrics"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/paper"
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/pricing"
+	"rattrap/arbitrage-bot/internal/reconcile"
+	"rattrap/arbitrage-bot/internal/risk"
+	"rattrap/arbitrage-bot/internal/supervisor"
+	"rattrap/arbitrage-bot/internal/uniswap"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// market holds the services trading a single market. The risk manager and the PnL engine are created with
+// the market, the other services by its components as they start.
+type market struct {
+	pair       string
+	config     *MarketConfig // Settings the market was created with
+	signer     uniswap.Signer
+	risk       *risk.Manager
+	pnl        *pnl.Engine
+	uniswap    *uniswap.UniswapClient
+	kucoin     *kucoin.KucoinClient
+	pricing    *pricing.PricingService
+	paper      *paper.Engine
+	executor   *execution.Executor
+	reconciler *reconcile.Reconciler
+	arbitrage  *arbitrage.ArbitrageService
+	removed    bool // Set once the market is being removed, under the lock of the markets
+}
+
+// marketStartTimeout bounds the wait for a market added by a reload to start, it keeps connecting after it
+const marketStartTimeout = 30 * time.Second
+
+// marketChecks are the health checks of every market, named <pair>/<check>
+var marketChecks = []string{"ethereum", "kucoin_rest", "pool_snapshot", "risk"}
+
+// markets creates and removes the markets traded by the bot, and tracks those ready to be operated
+type markets struct {
+	paperTrading  bool
+	ledger        *ledger.Ledger
+	notifier      *notify.Dispatcher
+	checker       *health.Checker
+	sup           *supervisor.Supervisor
+	signers       *signers
+	nonces        *uniswap.NonceManager // Shared by the markets, several may trade from the same account
+	tradeLock     sync.Mutex            // Shared by the executors, the markets share the KuCoin account
+	logger        *logging.Logger
+	currentConfig func() *Config // Running configuration, read by the components as they start
+	lock          sync.Mutex
+	created       map[string]*market // By trading pair, until the services of the market are removed
+	ready         map[string]*market // By trading pair
+}
+
+// newMarkets initializes the markets of a bot
+func newMarkets(paperTrading bool, tradeLedger *ledger.Ledger, notifier *notify.Dispatcher, checker *health.Checker, sup *supervisor.Supervisor, signers *signers, logger *logging.Logger) *markets {
+	return &markets{
+		paperTrading: paperTrading,
+		ledger:       tradeLedger,
+		notifier:     notifier,
+		checker:      checker,
+		sup:          sup,
+		signers:      signers,
+		nonces:       uniswap.NewNonceManager(),
+		logger:       logger,
+		created:      make(map[string]*market),
+		ready:        make(map[string]*market),
+	}
+}
+
+// create creates a market and restores its risk state and PnL. Live, the kill switch, the daily limits, the
+// residual position and the realized PnL carry over a restart, paper trading starts afresh like its virtual
+// balances.
+func (ms *markets) create(config *MarketConfig) (*market, error) {
+	pair := config.TradingPair
+	ms.lock.Lock()
+	_, exists := ms.created[pair]
+	ms.lock.Unlock()
+	if exists {
+		return nil, fmt.Errorf("Failed to create %s, the services of its previous run are still running", pair)
+	}
+
+	signer, err := ms.signers.forMarket(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize the transaction signer of %s: %w", pair, err)
+	}
+	ms.logger.Infof("Trading %s from %s", pair, signer.Address().Hex())
+
+	m := &market{
+		pair:   pair,
+		config: config,
+		signer: signer,
+		risk:   risk.NewManager(pair, config.Risk, ms.logger),
+		pnl:    pnl.NewEngine(pair, ms.logger),
+	}
+
+	// Risk limits are checked before every trade
+	m.risk.OnHalt(func(reason string) {
+		ms.notifier.Notify(notify.LevelCritical, "halt/"+pair, fmt.Sprintf("Trading halted on %s: %s", pair, reason))
+	})
+	if !ms.paperTrading {
+		if err := execution.RestoreRisk(m.risk, ms.ledger, pair); err != nil {
+			return nil, fmt.Errorf("Failed to restore the risk state of %s: %w", pair, err)
+		}
+		m.risk.OnChange(func(state risk.State) {
+			if err := ms.ledger.RecordRiskState(pair, &state); err != nil {
+				ms.logger.WithField("market", pair).WithError(err).Error("Failed to persist the risk state")
+			}
+		})
+		if err := execution.RestorePnL(m.pnl, ms.ledger, pair); err != nil {
+			return nil, fmt.Errorf("Failed to restore the PnL of %s: %w", pair, err)
+		}
+	}
+	metrics.RegisterRisk(pair, m.risk.GetStatus)
+	if halted, reason := m.risk.IsHalted(); halted {
+		ms.notifier.Notify(notify.LevelCritical, "halt/"+pair, fmt.Sprintf("Trading on %s still halted after the restart: %s", pair, reason))
+	}
+
+	ms.lock.Lock()
+	ms.created[pair] = m
+	ms.lock.Unlock()
+	return m, nil
+}
+
+// add creates a market and starts its services, waiting for them until ctx is cancelled. The services keep
+// starting after that, the market is ready once its arbitrage loop started.
+func (ms *markets) add(ctx context.Context, config *MarketConfig) error {
+	m, err := ms.create(config)
+	if err != nil {
+		return err
+	}
+	for _, c := range ms.components(m) {
+		ms.sup.Add(c)
+	}
+	if err := ms.sup.Start(ctx); err != nil {
+		return fmt.Errorf("%s is still starting: %w", m.pair, err)
+	}
+	return nil
+}
+
+// remove stops trading the market of pair. It is no longer operated right away, then its trade in flight is
+// waited for up to drainTimeout before its services are stopped. If the trade is still in flight at the
+// timeout, the services keep running until the next restart so that it can settle.
+func (ms *markets) remove(pair string, drainTimeout time.Duration) error {
+	ms.lock.Lock()
+	m, ready := ms.created[pair], ms.ready[pair] != nil
+	if m != nil {
+		m.removed = true
+		delete(ms.ready, pair)
+		for _, check := range marketChecks {
+			ms.checker.Unregister(pair + "/" + check)
+		}
+	}
+	ms.lock.Unlock()
+	if m == nil {
+		return nil
+	}
+
+	// The executor only trades once the market is ready
+	if ready {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+		err := m.executor.Drain(drainCtx)
+		cancelDrain()
+		if err != nil {
+			return fmt.Errorf("Failed to remove %s, its trade in flight did not finish, its services run until the restart: %w", pair, err)
+		}
+	}
+
+	var names []string
+	for _, c := range ms.components(m) {
+		names = append(names, c.Name)
+	}
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancelStop()
+	if err := ms.sup.Remove(stopCtx, names...); err != nil {
+		ms.logger.WithField("market", pair).WithError(err).Error("Failed to stop the services of the market")
+	}
+	metrics.RemoveMarket(pair)
+
+	ms.lock.Lock()
+	delete(ms.created, pair)
+	ms.lock.Unlock()
+	ms.logger.WithField("market", pair).Info("Stopped trading the market")
+	return nil
+}
+
+// components returns the components running m, named after its trading pair. The venues are connected
+// first, retried until they are reachable, and the market is ready once its arbitrage loop started. The
+// clients live until they are stopped, after the trade in flight.
+func (ms *markets) components(m *market) []supervisor.Component {
+	name := func(component string) string { return m.pair + "/" + component }
+
+	return []supervisor.Component{
+		{
+			Name: name("uniswap"),
+			Start: func(ctx context.Context) error {
+				config := ms.currentConfig()
+				err, client := uniswap.NewUniswapClient(m.pair, config.EthereumRPCURL.Reveal(), ms.marketConfig(m).PoolAddress, config.UniswapTickLensAddress, m.signer, ms.nonces, config.UniswapNativeETH, config.EthGasReserve, config.Simulation, ms.logger, ctx)
+				if err != nil {
+					return err
+				}
+				m.uniswap = client
+				return nil
+			},
+			Stop: func() { m.uniswap.Close() },
+		},
+		{
+			Name: name("kucoin"),
+			Start: func(ctx context.Context) error {
+				config := ms.currentConfig()
+				err, client := kucoin.NewKucoinClient(m.pair, config.KucoinAPIKey.Reveal(), config.KucoinAPISecret.Reveal(), config.KucoinAPIPassphrase.Reveal(), ms.logger, ctx)
+				if err != nil {
+					return err
+				}
+				m.kucoin = client
+				return nil
+			},
+			Stop: func() { m.kucoin.Close() },
+		},
+		{
+			// Prices are fetched on every tick of the arbitrage loop
+			Name:      name("pricing"),
+			DependsOn: []string{name("uniswap"), name("kucoin")},
+			Start: func(context.Context) error {
+				m.pricing = pricing.NewPricingService(m.pair, m.uniswap, m.kucoin, ms.logger)
+				m.pricing.Start()
+				return nil
+			},
+			Stop: func() { m.pricing.Close() },
+		},
+		{
+			// Trades are executed once the balances are known
+			Name:      name("execution"),
+			DependsOn: []string{name("uniswap"), name("kucoin")},
+			Start: func(context.Context) error {
+				if m.executor == nil {
+					config := ms.marketConfig(m)
+					if ms.paperTrading {
+						m.paper = paper.NewEngine(m.pair, ms.currentConfig().PaperBalances, config.Execution.KucoinFeeBps, ms.currentConfig().Simulation.StateOverride, m.uniswap, m.kucoin, ms.logger)
+					}
+					m.executor = execution.NewExecutor(ms.paperTrading, m.paper, m.pair, config.Execution, &ms.tradeLock, m.risk, ms.ledger, m.pnl, m.uniswap, m.kucoin, ms.logger)
+				}
+				if m.executor.GetBalances() == nil {
+					return fmt.Errorf("Failed to get the balances")
+				}
+				return nil
+			},
+			Run: func(ctx context.Context) error { return m.executor.Run(ctx) },
+		},
+		{
+			// Balances are snapshotted and reconciled against the ledger
+			Name:      name("reconcile"),
+			DependsOn: []string{name("execution"), "notify"},
+			Start: func(context.Context) error {
+				config := ms.currentConfig()
+				m.reconciler = reconcile.NewReconciler(ms.paperTrading, m.executor, ms.ledger, ms.notifier, m.pair, m.uniswap.Address().Hex(), config.ReconcileInterval, config.ReconcileToleranceBps, ms.logger)
+				return nil
+			},
+			Run: func(ctx context.Context) error { return m.reconciler.Run(ctx) },
+		},
+		{
+			// Arbitrage detection and execution loop
+			Name:      name("arbitrage"),
+			DependsOn: []string{name("pricing"), name("execution"), name("reconcile"), "notify"},
+			Start: func(context.Context) error {
+				m.arbitrage = arbitrage.NewArbitrageService(m.pair, m.pricing, m.executor, ms.notifier, ms.marketConfig(m).ThresholdPct, ms.logger)
+				ms.register(m)
+				return nil
+			},
+			Run: func(ctx context.Context) error { return m.arbitrage.Run(ctx) },
+		},
+	}
+}
+
+// register registers the health checks of m and makes it ready to be operated. An outage of a venue is not
+// fixed by restarting the bot, it only takes the bot out of rotation.
+func (ms *markets) register(m *market) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if m.removed {
+		return
+	}
+
+	ms.checker.Register(m.pair+"/ethereum", health.Readiness, m.uniswap.Ping)
+	ms.checker.Register(m.pair+"/kucoin_rest", health.Readiness, m.kucoin.Ping)
+	ms.checker.Register(m.pair+"/pool_snapshot", health.Readiness, func(ctx context.Context) error {
+		_, snapshotTime := m.uniswap.GetSnapshot()
+		if age := time.Since(snapshotTime); age > ms.currentConfig().HealthMaxSnapshotAge {
+			return fmt.Errorf("pool snapshot is %s old", age.Round(time.Second))
+		}
+		return nil
+	})
+	ms.checker.Register(m.pair+"/risk", health.Readiness, func(ctx context.Context) error {
+		if status := m.risk.GetStatus(); status.Halted {
+			return fmt.Errorf("trading halted: %s", status.HaltReason)
+		}
+		return nil
+	})
+	ms.ready[m.pair] = m
+}
+
+// marketConfig returns the running settings of m, those it was created with once it is no longer configured
+func (ms *markets) marketConfig(m *market) *MarketConfig {
+	if config := ms.currentConfig().Markets[m.pair]; config != nil {
+		return config
+	}
+	return m.config
+}
+
+// get returns the ready market of the trading pair, nil if there is none
+func (ms *markets) get(pair string) *market {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, m := range ms.ready {
+		if strings.EqualFold(m.pair, pair) {
+			return m
+		}
+	}
+	return nil
+}
+
+// list returns the ready markets, sorted by trading pair
+func (ms *markets) list() []*market {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	list := make([]*market, 0, len(ms.ready))
+	for _, m := range ms.ready {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].pair < list[j].pair })
+	return list
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/secret"
+	"time"
+)
+
+// leveledNotifier is a notifier and the lowest level it receives
+type leveledNotifier struct {
+	notifier notify.Notifier
+	level    notify.Level
+}
+
+// webhookNotifiers creates the webhook notifiers enabled by the configuration
+func webhookNotifiers(config *NotifierConfig) []leveledNotifier {
+	var notifiers []leveledNotifier
+	if !config.WebhookURL.IsEmpty() {
+		notifiers = append(notifiers, leveledNotifier{notify.NewWebhookNotifier(config.WebhookURL.Reveal(), config.WebhookSecret.Reveal()), config.WebhookLevel})
+	}
+	if !config.SlackWebhookURL.IsEmpty() {
+		notifiers = append(notifiers, leveledNotifier{notify.NewSlackNotifier(config.SlackWebhookURL.Reveal()), config.SlackLevel})
+	}
+	if !config.DiscordWebhookURL.IsEmpty() {
+		notifiers = append(notifiers, leveledNotifier{notify.NewDiscordNotifier(config.DiscordWebhookURL.Reveal()), config.DiscordLevel})
+	}
+	return notifiers
+}
+
+// runNotifyTest implements the notify-test subcommand: it sends a notification to every webhook notifier
+// configured, bypassing routing, and reports the ones that failed
+func runNotifyTest(args []string, logger *logging.Logger) error {
+	fs := flag.NewFlagSet("notify-test", flag.ContinueOnError)
+	level := fs.String("level", notify.LevelWarning.String(), "Level of the test notification (info, warning, critical)")
+	text := fs.String("text", "Test notification from the arbitrage bot", "Text of the test notification")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	msgLevel, err := notify.ParseLevel(*level)
+	if err != nil {
+		return err
+	}
+
+	if err := secret.AuditFile(envFile); err != nil {
+		return err
+	}
+	config, err := LoadNotifierConfig()
+	if err != nil {
+		return err
+	}
+
+	notifiers := webhookNotifiers(config)
+	if len(notifiers) == 0 {
+		return fmt.Errorf("no webhook notifier is configured, set WEBHOOK_URL, SLACK_WEBHOOK_URL or DISCORD_WEBHOOK_URL")
+	}
+
+	log := logger.WithField("prefix", "notify")
+	msg := notify.Message{Level: msgLevel, Key: "test", Text: *text, Time: time.Now()}
+	var errs []error
+	for _, n := range notifiers {
+		notifier := n.notifier
+		ctx, cancel := context.WithTimeout(context.Background(), notify.SendTimeout)
+		err := notifier.Notify(ctx, msg)
+		cancel()
+
+		if err != nil {
+			log.WithError(err).Errorf("Failed to notify %s", notifier.Name())
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+			continue
+		}
+		log.Infof("Notified %s", notifier.Name())
+	}
+	return errors.Join(errs...)
+}
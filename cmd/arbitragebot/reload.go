@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/telegram"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unsafeSettings identify the wallet, the venue accounts and the pools. Changing them while trades may be in
+// flight could orphan a swap or a hedge, a reload changing any of them is rejected. A * matches the settings
+// of every market.
+var unsafeSettings = []string{
+	"KucoinAPIKey", "KucoinAPISecret", "KucoinAPIPassphrase",
+	"EthereumRPCURL", "EthereumPrivateKey",
+	"SignerType", "KeystorePath", "KeystorePassphrase", "SignerURL", "SignerAddress",
+	"Mnemonic", "MnemonicPassphrase", "HDPaths", "Markets.*.HDAccount",
+	"Markets.*.PoolAddress", "UniswapTickLensAddress",
+}
+
+// restartSettings are read once at startup. A reload changing them is applied without them, they take effect
+// on the next restart.
+var restartSettings = []string{
+	"TelegramChannelID", "TelegramBotToken",
+	"UniswapNativeETH", "EthGasReserve", "Simulation", "PaperBalances",
+	"LedgerPath", "MetricsAddr", "ControlAddr", "ControlToken", "ConfigWatchInterval",
+}
+
+// change is a setting that differs between two configurations
+type change struct {
+	Setting string // Path of the field, e.g. Markets.ELON-USDT.Execution.SlippageBps
+	Old     string
+	New     string
+}
+
+// reloader reloads the configuration on SIGHUP or when the .env file changes, and applies the changes to
+// the running services
+type reloader struct {
+	logger          *logrus.Entry
+	signals         <-chan os.Signal
+	notifier        *notify.Dispatcher
+	telegramService *telegram.TelegramService
+	markets         *markets
+	commands        bool       // Whether the Telegram commands run
+	reloadLock      sync.Mutex // Serializes the reloads
+	lock            sync.Mutex
+	config          *Config
+}
+
+// newReloader initializes a new reloader of config, reloading on signals
+func newReloader(config *Config, signals <-chan os.Signal, commands bool, notifier *notify.Dispatcher, telegramService *telegram.TelegramService, markets *markets, logger *logging.Logger) *reloader {
+	return &reloader{
+		logger:          logger.WithField("prefix", "reload"),
+		signals:         signals,
+		notifier:        notifier,
+		telegramService: telegramService,
+		markets:         markets,
+		commands:        commands,
+		config:          config,
+	}
+}
+
+// Config returns the configuration in use
+func (r *reloader) Config() *Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.config
+}
+
+// Run reloads the configuration on every signal, and whenever the .env file changes, until ctx is cancelled
+func (r *reloader) Run(ctx context.Context) error {
+	var ticks <-chan time.Time
+	if interval := r.Config().ConfigWatchInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	last := statEnvFile()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.signals:
+			r.logger.Info("Received SIGHUP, reloading the configuration")
+			last = statEnvFile()
+			_ = r.Reload()
+		case <-ticks:
+			if state := statEnvFile(); state != last {
+				last = state
+				r.logger.Infof("%s changed, reloading the configuration", envFile)
+				_ = r.Reload()
+			}
+		}
+	}
+}
+
+// Reload loads and validates the configuration, then applies the changes. The reload is rejected if the
+// configuration is invalid or changes an unsafe setting, the running configuration is then left as is.
+func (r *reloader) Reload() error {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	next, err := LoadConfig()
+	if err != nil {
+		r.reject(err)
+		return err
+	}
+
+	current := r.Config()
+	changes := diffConfig(current, next)
+	if len(changes) == 0 {
+		r.logger.Info("Configuration reloaded, nothing changed")
+		return nil
+	}
+
+	var unsafe, restart []string
+	for _, c := range changes {
+		r.logger.WithField("setting", c.Setting).Infof("%s: %s -> %s", c.Setting, c.Old, c.New)
+		switch {
+		case matchSetting(c.Setting, unsafeSettings):
+			unsafe = append(unsafe, c.Setting)
+		case matchSetting(c.Setting, restartSettings), c.Setting == "TelegramAdminIDs" && !r.commands:
+			restart = append(restart, c.Setting)
+		}
+	}
+	if len(unsafe) > 0 {
+		err := fmt.Errorf("Changing %s requires a restart", strings.Join(unsafe, ", "))
+		r.reject(err)
+		return err
+	}
+
+	// The settings read at startup keep their running value, so that the configuration reflects what runs
+	for _, setting := range restart {
+		field, _, _ := strings.Cut(setting, ".")
+		reflect.ValueOf(next).Elem().FieldByName(field).Set(reflect.ValueOf(current).Elem().FieldByName(field))
+		r.logger.WithField("setting", setting).Warnf("%s only applies after a restart", setting)
+	}
+
+	applied := r.apply(current, next)
+	r.lock.Lock()
+	r.config = next
+	r.lock.Unlock()
+
+	// Markets added start with the configuration they are listed in
+	for _, pair := range next.TradingPairs() {
+		if current.Markets[pair] != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), marketStartTimeout)
+		err := r.markets.add(ctx, next.Markets[pair])
+		cancel()
+		if err != nil {
+			r.logger.WithField("market", pair).WithError(err).Error("Failed to start the market added")
+			r.notifier.Notify(notify.LevelWarning, "reload", fmt.Sprintf("Failed to start %s: %s", pair, err))
+			continue
+		}
+		r.logger.WithField("market", pair).Info("Started trading the market added")
+	}
+
+	r.logger.Infof("Configuration reloaded, %d settings changed, %d pending a restart", applied, len(restart))
+	r.notifier.Notify(notify.LevelInfo, "reload", fmt.Sprintf("Configuration reloaded, %d settings changed, %d pending a restart", applied, len(restart)))
+	return nil
+}
+
+// apply hands the settings that differ between current and next to the running services, and returns the
+// number of settings changed
+func (r *reloader) apply(current, next *Config) int {
+	changes := diffConfig(current, next)
+	changed := func(prefix string) bool {
+		for _, c := range changes {
+			if matchSetting(c.Setting, []string{prefix}) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Markets removed stop first, once their trade in flight is done
+	for _, pair := range current.TradingPairs() {
+		if next.Markets[pair] != nil {
+			continue
+		}
+		if err := r.markets.remove(pair, next.ShutdownTimeout); err != nil {
+			r.logger.WithField("market", pair).WithError(err).Error("Failed to remove the market")
+			r.notifier.Notify(notify.LevelWarning, "reload", err.Error())
+		}
+	}
+
+	// Limits first, a tighter limit applies before any other change
+	markets := r.markets.list()
+	for _, m := range markets {
+		if changed("Markets." + m.pair + ".Risk") {
+			m.risk.SetLimits(next.Markets[m.pair].Risk)
+		}
+	}
+	if changed("ReconcileInterval") || changed("ReconcileToleranceBps") {
+		for _, m := range markets {
+			m.reconciler.SetOptions(next.ReconcileInterval, next.ReconcileToleranceBps)
+		}
+	}
+	if changed("Notifiers") {
+		r.routeNotifications(next.Notifiers)
+	}
+	if changed("TelegramAdminIDs") {
+		r.telegramService.SetAdmins(next.TelegramAdminIDs)
+		r.logger.Infof("Receiving commands from %d Telegram users", len(next.TelegramAdminIDs))
+	}
+	for _, m := range markets {
+		if changed("Markets." + m.pair + ".ThresholdPct") {
+			m.arbitrage.SetThreshold(next.Markets[m.pair].ThresholdPct)
+		}
+		// Waits for the trade in flight
+		if changed("Markets." + m.pair + ".Execution") {
+			m.executor.SetConfig(next.Markets[m.pair].Execution)
+		}
+	}
+
+	// Markets still starting read their settings from the configuration when they start, as do the health and
+	// shutdown settings when used and shutdown settings are read from the configuration when used
+	return len(changes)
+}
+
+// routeNotifications routes the notifications as configured by config, adding, replacing and removing the
+// notifiers
+func (r *reloader) routeNotifications(config *NotifierConfig) {
+	r.notifier.SetOptions(config.Options)
+	if r.telegramService.IsStarted() {
+		r.notifier.Add(r.telegramService, config.TelegramLevel)
+	}
+
+	enabled := make(map[string]bool)
+	for _, n := range webhookNotifiers(config) {
+		r.notifier.Add(n.notifier, n.level)
+		enabled[n.notifier.Name()] = true
+	}
+	for _, name := range []string{"webhook", "slack", "discord"} {
+		if !enabled[name] {
+			r.notifier.Remove(name)
+		}
+	}
+}
+
+// reject logs and notifies a rejected reload
+func (r *reloader) reject(err error) {
+	r.logger.WithError(err).Error("Rejected the configuration reload, the running configuration is unchanged")
+	r.notifier.Notify(notify.LevelWarning, "reload", "Rejected the configuration reload: "+err.Error())
+}
+
+// envFileState identifies a version of the .env file
+type envFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// statEnvFile returns the state of the .env file, the zero state if it does not exist
+func statEnvFile() envFileState {
+	info, err := os.Stat(envFile)
+	if err != nil {
+		return envFileState{}
+	}
+	return envFileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+// matchSetting returns whether setting is one of settings or one of their fields, a * in settings matching
+// any name
+func matchSetting(setting string, settings []string) bool {
+	names := strings.Split(setting, ".")
+	for _, s := range settings {
+		pattern := strings.Split(s, ".")
+		if len(names) < len(pattern) {
+			continue
+		}
+		matched := true
+		for i, name := range pattern {
+			if name != "*" && name != names[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConfig returns the settings that differ between old and new, secrets redacted
+func diffConfig(old, new *Config) []change {
+	var changes []change
+	diffValue("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), &changes)
+	return changes
+}
+
+// diffValue appends the differences between old and new to changes, walking down the structs that are not
+// printable values and the maps of them, by key
+func diffValue(path string, old, new reflect.Value, changes *[]change) {
+	if old.Kind() == reflect.Map && isSection(old.Type().Elem()) {
+		keys := make(map[string]reflect.Value)
+		for _, v := range [...]reflect.Value{old, new} {
+			for _, key := range v.MapKeys() {
+				keys[fmt.Sprint(key.Interface())] = key
+			}
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			// A missing entry compares as the zero value, a nil section
+			oldEntry, newEntry := old.MapIndex(keys[name]), new.MapIndex(keys[name])
+			if !oldEntry.IsValid() {
+				oldEntry = reflect.Zero(old.Type().Elem())
+			}
+			if !newEntry.IsValid() {
+				newEntry = reflect.Zero(new.Type().Elem())
+			}
+			diffValue(path+"."+name, oldEntry, newEntry, changes)
+		}
+		return
+	}
+
+	if old.Kind() == reflect.Pointer && !isPrintable(old.Type()) {
+		if old.IsNil() || new.IsNil() {
+			if old.IsNil() != new.IsNil() {
+				*changes = append(*changes, change{Setting: path, Old: formatValue(old), New: formatValue(new)})
+			}
+			return
+		}
+		old, new = old.Elem(), new.Elem()
+	}
+
+	if old.Kind() == reflect.Struct && !isPrintable(old.Type()) {
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diffValue(name, old.Field(i), new.Field(i), changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+		*changes = append(*changes, change{Setting: path, Old: formatValue(old), New: formatValue(new)})
+	}
+}
+
+// isSection returns whether values of type t are sections of settings, structs or pointers to structs that
+// are not printable values
+func isSection(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !isPrintable(t)
+}
+
+// isPrintable returns whether values of type t print themselves, such as decimals, addresses or secrets
+func isPrintable(t reflect.Type) bool {
+	stringer := reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	return t.Implements(stringer) || reflect.PointerTo(t).Implements(stringer)
+}
+
+// formatValue formats a setting for the logs, secrets print redacted
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return strconv.Quote(v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
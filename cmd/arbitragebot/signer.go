@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/uniswap"
+
+	"golang.org/x/term"
+)
+
+// signers provides the signer of each market: the account derived for it with the mnemonic signer, the
+// single configured account with the other signers
+type signers struct {
+	signer uniswap.Signer
+	wallet *uniswap.HDWallet
+}
+
+// newSigners creates the signer of Ethereum transactions selected by the configuration
+func newSigners(config *Config, logger *logging.Logger) (*signers, error) {
+	switch config.SignerType {
+	case SignerTypeKeystore:
+		passphrase := config.KeystorePassphrase.Reveal()
+		if passphrase == "" {
+			p, err := promptPassphrase(config.KeystorePath)
+			if err != nil {
+				return nil, err
+			}
+			passphrase = p
+		}
+		signer, err := uniswap.NewKeystoreSigner(config.KeystorePath, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &signers{signer: signer}, nil
+	case SignerTypeExternal:
+		signer, err := uniswap.NewExternalSigner(config.SignerURL.Reveal(), config.SignerAddress)
+		if err != nil {
+			return nil, err
+		}
+		return &signers{signer: signer}, nil
+	case SignerTypeMnemonic:
+		wallet, err := uniswap.NewHDWallet(config.Mnemonic.Reveal(), config.MnemonicPassphrase.Reveal(), config.HDPaths)
+		if err != nil {
+			return nil, err
+		}
+		log := logger.WithField("prefix", "signer")
+		for i, account := range wallet.Accounts() {
+			log.Infof("Derived account %d %s at %s", i, account.Address().Hex(), wallet.Path(i))
+		}
+		return &signers{wallet: wallet}, nil
+	default:
+		signer, err := uniswap.InitWallet(config.EthereumPrivateKey.Reveal())
+		if err != nil {
+			return nil, err
+		}
+		return &signers{signer: signer}, nil
+	}
+}
+
+// forMarket returns the signer of the account trading market
+func (s *signers) forMarket(market *MarketConfig) (uniswap.Signer, error) {
+	if s.wallet != nil {
+		return s.wallet.Account(market.HDAccount)
+	}
+	return s.signer, nil
+}
+
+// promptPassphrase reads the keystore passphrase from the terminal without echoing it
+func promptPassphrase(path string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("KEYSTORE_PASSPHRASE is not set and there is no terminal to prompt for it")
+	}
+
+	fmt.Fprintf(os.Stderr, "Passphrase of %s: ", path)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the keystore passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
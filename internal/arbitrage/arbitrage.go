@@ -1,12 +1,15 @@
 package arbitrage
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"rattrap/arbitrage-bot/internal/execution"
 	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
 	"rattrap/arbitrage-bot/internal/pricing"
 	"rattrap/arbitrage-bot/internal/telegram"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -16,62 +19,72 @@ import (
 type ArbitrageService struct {
 	pricingService *pricing.PricingService
 	executor       *execution.Executor
-	telegram       *telegram.TelegramService
+	notifier       *notify.Dispatcher
 	logger         *logrus.Entry
-	stopChan       chan struct{}
+	tradingPair    string
+	lock           sync.Mutex
+	thresholdPct   float64 // Price difference that triggers a trade, in percent
 }
 
 // NewArbitrageService initializes a new ArbitrageService
-func NewArbitrageService(pricingService *pricing.PricingService, executor *execution.Executor, telegramService *telegram.TelegramService, logger *logging.Logger) *ArbitrageService {
-	prefixedLogger := logger.WithField("prefix", "arbitrage")
+func NewArbitrageService(tradingPair string, pricingService *pricing.PricingService, executor *execution.Executor, notifier *notify.Dispatcher, thresholdPct float64, logger *logging.Logger) *ArbitrageService {
+	prefixedLogger := logger.WithField("prefix", "arbitrage").WithField("market", tradingPair)
 	prefixedLogger.Debug("Starting service")
 	return &ArbitrageService{
 		pricingService: pricingService,
 		executor:       executor,
-		telegram:       telegramService,
+		notifier:       notifier,
 		logger:         prefixedLogger,
-		stopChan:       make(chan struct{}),
+		tradingPair:    tradingPair,
+		thresholdPct:   thresholdPct,
 	}
 }
 
-func (a *ArbitrageService) RunArbitrageLoop() {
-	go func() {
-		for {
-			select {
-			case <-a.stopChan:
-				a.logger.Debug("Stopping arbitrage loop")
-				return
-			default:
-				a.pricingService.FetchPrices()
-				a.logger.Debug("Checking for arbitrage opportunities...")
-				uniswapPrice, kucoinPrice := a.pricingService.GetPrices()
+// Run checks for opportunities every minute until ctx is cancelled. A trade in progress is never
+// interrupted, Run returns once it is done.
+func (a *ArbitrageService) Run(ctx context.Context) error {
+	for {
+		a.pricingService.FetchPrices()
+		a.logger.Debug("Checking for arbitrage opportunities...")
+		uniswapPrice, kucoinPrice := a.pricingService.GetPrices()
 
-				// Calculate the difference between the two prices
-				priceDifference := kucoinPrice - uniswapPrice
-				priceDifferencePercentage := (priceDifference / uniswapPrice) * 100
+		// Calculate the difference between the two prices
+		priceDifference := kucoinPrice - uniswapPrice
+		priceDifferencePercentage := (priceDifference / uniswapPrice) * 100
 
-				stat := fmt.Sprintf("KuCoin price: %.18f, Uniswap price: %.18f, Price difference: %.18f (%.2f%%)", kucoinPrice, uniswapPrice, priceDifference, priceDifferencePercentage)
+		stat := fmt.Sprintf("%s KuCoin price: %.18f, Uniswap price: %.18f, Price difference: %.18f (%.2f%%)", a.tradingPair, kucoinPrice, uniswapPrice, priceDifference, priceDifferencePercentage)
 
-				a.logger.Info(stat)
-				err := a.telegram.SendMessage(telegram.FormatMessage(stat))
-				if err != nil {
-					a.logger.WithError(err).Error("Failed to send message to Telegram")
-				}
+		// Only the latest price stat of each market makes it to the digest
+		a.logger.Info(stat)
+		a.notifier.Notify(notify.LevelInfo, "price/"+a.tradingPair, telegram.FormatMessage(stat))
 
-				if math.Abs(priceDifferencePercentage) > 1 {
-					a.logger.Info("Arbitrage opportunity found")
-					a.executor.ExecuteArbitrage()
-				}
+		// No new opportunity is taken once the shutdown started
+		if math.Abs(priceDifferencePercentage) > a.GetThreshold() && ctx.Err() == nil {
+			a.logger.Info("Arbitrage opportunity found")
+			a.notifier.Notify(notify.LevelInfo, "opportunity/"+a.tradingPair, fmt.Sprintf("Arbitrage opportunity on %s: price difference %.2f%%", a.tradingPair, priceDifferencePercentage))
+			a.executor.ExecuteArbitrage()
+		}
 
-				time.Sleep(1 * time.Minute)
-			}
+		select {
+		case <-ctx.Done():
+			a.logger.Debug("Stopping arbitrage loop")
+			return nil
+		case <-time.After(time.Minute):
 		}
-	}()
+	}
+}
+
+// GetThreshold returns the price difference that triggers a trade, in percent
+func (a *ArbitrageService) GetThreshold() float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.thresholdPct
 }
 
-// Close closes the ArbitrageService
-func (a *ArbitrageService) Close() {
-	a.logger.Debug("Closing service")
-	close(a.stopChan)
-	a.executor.Close()
+// SetThreshold changes the price difference that triggers a trade, in percent
+func (a *ArbitrageService) SetThreshold(pct float64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.logger.Infof("Arbitrage threshold changed from %.2f%% to %.2f%%", a.thresholdPct, pct)
+	a.thresholdPct = pct
 }
@@ -0,0 +1,143 @@
+package control
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"rattrap/arbitrage-bot/internal/logging"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxBodySize bounds the body of a request
+const maxBodySize = 1 << 20
+
+// Handler serves a request and returns the value sent back as JSON
+type Handler func(r *http.Request) (interface{}, error)
+
+// Error is an error served with its HTTP status. Other errors are served with a 500 status.
+type Error struct {
+	Status  int
+	Message string
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error served with status
+func Errorf(status int, format string, args ...interface{}) error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Server serves the control API. Every request must carry the token as a bearer token.
+type Server struct {
+	token  [sha256.Size]byte
+	mux    *http.ServeMux
+	logger *logrus.Entry
+}
+
+// NewServer initializes a new Server
+func NewServer(token string, logger *logging.Logger) *Server {
+	return &Server{
+		token:  sha256.Sum256([]byte(token)),
+		mux:    http.NewServeMux(),
+		logger: logger.WithField("prefix", "control"),
+	}
+}
+
+// Handle registers a handler for pattern, e.g. "POST /v1/markets/{market}/pause"
+func (s *Server) Handle(pattern string, handler Handler) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path, "remote": r.RemoteAddr})
+
+		// Requests changing the state of the bot are audited
+		if r.Method != http.MethodGet {
+			logger.Info("Control request")
+		}
+
+		value, err := handler(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var controlErr *Error
+			if errors.As(err, &controlErr) {
+				status = controlErr.Status
+			}
+			logger.WithError(err).Warnf("Control request failed with status %d", status)
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, value)
+	})
+}
+
+// HandleStream registers a handler for pattern that writes its own response, e.g. a file download. An
+// error returned before anything was written is served as JSON.
+func (s *Server) HandleStream(pattern string, handler func(w http.ResponseWriter, r *http.Request) error) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		logger := s.logger.WithFields(logrus.Fields{"method": r.Method, "path": r.URL.Path, "remote": r.RemoteAddr})
+		logger.Info("Control request")
+
+		sw := &statusWriter{ResponseWriter: w}
+		if err := handler(sw, r); err != nil {
+			logger.WithError(err).Warn("Control request failed")
+			if !sw.written {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			}
+		}
+	})
+}
+
+// statusWriter records whether a response was started
+type statusWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *statusWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// ServeHTTP implements http.Handler, rejecting the requests without the token
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	digest := sha256.Sum256([]byte(token))
+	if !ok || subtle.ConstantTimeCompare(digest[:], s.token[:]) != 1 {
+		s.logger.WithField("remote", r.RemoteAddr).Warnf("Rejected unauthenticated control request %s %s", r.Method, r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Decode decodes the JSON body of r into v, unknown fields are rejected
+func Decode(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return Errorf(http.StatusBadRequest, "invalid body: %s", err)
+	}
+	return nil
+}
+
+// writeJSON sends value as the JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -1,159 +1,1134 @@
 package execution
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
 	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/ledger"
 	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/metrics"
+	"rattrap/arbitrage-bot/internal/paper"
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/risk"
+	"rattrap/arbitrage-bot/internal/supervisor"
 	"rattrap/arbitrage-bot/internal/uniswap"
 	"rattrap/arbitrage-bot/internal/utils"
-	"strconv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	coreentities "github.com/daoleno/uniswap-sdk-core/entities"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 )
 
+// Config holds the per-market execution settings
+type Config struct {
+	SlippageMode string        // How the swap amountOutMinimum is derived: fixed, impact or hedge
+	SlippageBps  int64         // Slippage tolerance, or buffer on top of the price impact, in basis points
+	Deadline     time.Duration // How long a swap stays valid once signed
+	KucoinFeeBps int64         // KuCoin taker fee used to price the hedge, in basis points
+	MinProfitBps int64         // Minimum profit over the hedge required in hedge mode, in basis points
+
+	LegOrder       string              // Order in which the legs are executed: dex-first, cex-first or simultaneous
+	LegTimeout     time.Duration       // How long simultaneous legs are waited for
+	HedgeOrder     kucoin.OrderOptions // How the KuCoin hedge leg of an arbitrage is placed
+	RebalanceOrder kucoin.OrderOptions // How KuCoin orders placed to rebalance inventory are placed
+
+	MarkInterval   time.Duration // How often the inventory is marked
+	EthPriceSymbol string        // KuCoin symbol used to value ETH in token1
+}
+
+// Leg ordering modes
+const (
+	LegOrderDexFirst     = "dex-first"    // Swap on Uniswap, then hedge on KuCoin
+	LegOrderCexFirst     = "cex-first"    // Order on KuCoin, then swap the filled share on Uniswap
+	LegOrderSimultaneous = "simultaneous" // Fire both legs in parallel
+)
+
+// ErrDraining is returned for trades requested while the executor shuts down
+var ErrDraining = fmt.Errorf("shutting down, not accepting new trades")
+
+var (
+	errLegSkipped = fmt.Errorf("leg skipped after the other leg failed")
+	errLegTimeout = fmt.Errorf("leg timed out")
+)
+
+// arbitrage describes the two legs of an arbitrage
+type arbitrage struct {
+	tradeID      string
+	buyOnUniswap bool
+	kucoinPrice  float64
+	swapAmount   *coreentities.CurrencyAmount // Input of the Uniswap swap
+	orderSide    string
+	orderAmount  *coreentities.CurrencyAmount // Expected size of the KuCoin order, in token0
+	config       Config                       // Execution settings the trade was started with
+}
+
+// legResults holds the outcome of both legs of an arbitrage
+type legResults struct {
+	swapIn   *coreentities.CurrencyAmount
+	swapOut  *coreentities.CurrencyAmount
+	swapGas  decimal.Decimal
+	swapErr  error
+	order    *kucoin.OrderResult
+	orderErr error
+}
+
 // Executor handles trade execution for both KuCoin and Uniswap
 type Executor struct {
 	paperTrading  bool
+	paper         *paper.Engine
+	config        Config
+	risk          *risk.Manager
+	ledger        *ledger.Ledger
 	uniswapClient *uniswap.UniswapClient
 	kucoinClient  *kucoin.KucoinClient
 	logger        *logrus.Entry
 	tradingPair   string
 	token0        string
 	token1        string
-	balances      map[string]string
+	pnl           *pnl.Engine
+	tradeLock     *sync.Mutex // Held while trading, so balances are never read mid-trade
+	lock          sync.Mutex
+	balances      pnl.Balances
+	pending       map[string]ledger.Tx // Swaps sent and not mined yet, by hash
+	draining      bool
 }
 
-// NewExecutor initializes a new Executor
-func NewExecutor(paperTrading bool, tradingPair string, uniswapClient *uniswap.UniswapClient, kucoinClient *kucoin.KucoinClient, logger *logging.Logger) *Executor {
-	prefixedLogger := logger.WithField("prefix", "execution")
+// NewExecutor initializes a new Executor. The executors of markets sharing a venue account share tradeLock, so
+// that no market reads the balances while another one trades.
+func NewExecutor(paperTrading bool, paperEngine *paper.Engine, tradingPair string, config Config, tradeLock *sync.Mutex, riskManager *risk.Manager, tradeLedger *ledger.Ledger, pnlEngine *pnl.Engine, uniswapClient *uniswap.UniswapClient, kucoinClient *kucoin.KucoinClient, logger *logging.Logger) *Executor {
+	prefixedLogger := logger.WithField("prefix", "execution").WithField("market", tradingPair)
 	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
+	if tradeLock == nil {
+		tradeLock = &sync.Mutex{}
+	}
 
 	return &Executor{
 		paperTrading:  paperTrading,
+		paper:         paperEngine,
+		config:        config,
+		risk:          riskManager,
+		ledger:        tradeLedger,
 		uniswapClient: uniswapClient,
 		kucoinClient:  kucoinClient,
 		logger:        prefixedLogger,
 		tradingPair:   tradingPair,
 		token0:        token0,
 		token1:        token1,
-		balances:      make(map[string]string),
+		pnl:           pnlEngine,
+		tradeLock:     tradeLock,
+		balances:      make(pnl.Balances),
+		pending:       make(map[string]ledger.Tx),
 	}
 }
 
-func (e *Executor) Start() {
-	e.logger.Debug("Starting service")
-	e.GetBalances()
+// Run periodically marks the inventory of both venues, and settles the swaps left pending, until ctx is
+// cancelled
+func (e *Executor) Run(ctx context.Context) error {
+	// Orders only carry symbols, the ledger maps them to the pool tokens for the exports
+	token0, token1 := e.uniswapClient.GetTokens()
+	e.record(e.ledger.RecordTokenAddresses(map[string]string{e.token0: token0.Address.Hex(), e.token1: token1.Address.Hex()}))
+
+	if !e.paperTrading {
+		e.resolvePending(ctx)
+	}
+	e.mark()
+
+	for {
+		// The interval is read on every mark, so a new interval applies from the next one
+		timer := time.NewTimer(e.GetConfig().MarkInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			if !e.paperTrading {
+				e.resolvePending(ctx)
+			}
+			e.mark()
+		}
+	}
 }
 
-// GetBalances
-func (e *Executor) GetBalances() {
-	ethBalance, err := e.uniswapClient.GetEthBalance()
+// mark values the inventory and the residual position at the current KuCoin price and records the mark
+func (e *Executor) mark() {
+	balances := e.GetBalances()
+	if balances == nil {
+		return
+	}
+
+	price, err := e.kucoinClient.GetPrice()
 	if err != nil {
-		e.logger.WithError(err).Error("Failed to get ETH balance")
+		e.logger.WithError(err).Error("Failed to get KuCoin price")
 		return
 	}
 
-	e.balances["ETH"] = ethBalance.ToExact()
+	mark := e.pnl.Mark(decimal.NewFromFloat(price), e.ethPrice(price), balances)
+	e.record(e.ledger.RecordMark(&mark))
+	e.persistPnL()
+	e.logger.Infof("Inventory worth %s %s (%s since start), %s", mark.Inventory.StringFixed(6), e.token1, mark.Change.StringFixed(6), e.pnl.Report())
+}
+
+// ethPrice returns the price of ETH in token1, used to value gas and ETH balances. A zero price is returned
+// when it is unknown.
+func (e *Executor) ethPrice(price float64) decimal.Decimal {
+	switch {
+	case isEth(e.token1):
+		return decimal.NewFromInt(1)
+	case isEth(e.token0):
+		return decimal.NewFromFloat(price)
+	}
+
+	symbol := e.GetConfig().EthPriceSymbol
+	ethPrice, err := e.kucoinClient.GetPriceOf(symbol)
+	if err != nil {
+		e.logger.WithError(err).Warnf("Failed to get the ETH price from %s, gas is not valued", symbol)
+		return decimal.Zero
+	}
+	return decimal.NewFromFloat(ethPrice)
+}
+
+// GetBalances refreshes the balances of both venues and returns them, or nil if they could not be read
+func (e *Executor) GetBalances() pnl.Balances {
+	if e.paperTrading {
+		balances := pnl.Balances(e.paper.GetBalances())
+		e.setBalances(balances)
+		e.logger.Debugf("Paper balances: %v", balances)
+		return balances
+	}
+
+	ethBalance, err := e.uniswapClient.GetEthBalance()
+	if err != nil {
+		e.logger.WithError(err).Error("Failed to get ETH balance")
+		return nil
+	}
 
 	token0Uniswap, token1Uniswap, err := e.uniswapClient.GetBalances()
 	if err != nil {
 		e.logger.WithError(err).Error("Failed to get Uniswap balances")
-		return
+		return nil
 	}
 
-	e.balances["UNISWAP"+e.token0] = token0Uniswap.ToExact()
-	e.balances["UNISWAP"+e.token1] = token1Uniswap.ToExact()
-
 	e.logger.Debugf("Uniswap balances: %s %s, %s %s, %s %s", ethBalance.ToExact(), ethBalance.Currency.Symbol(), token0Uniswap.ToExact(), token0Uniswap.Currency.Symbol(), token1Uniswap.ToExact(), token1Uniswap.Currency.Symbol())
 
 	token0Kucoin, err := e.kucoinClient.BalanceOf(e.token0)
 	if err != nil {
 		e.logger.WithError(err).Error("Failed to get KuCoin balance of token0")
-		return
+		return nil
 	}
 
 	token1Kucoin, err := e.kucoinClient.BalanceOf(e.token1)
 	if err != nil {
 		e.logger.WithError(err).Error("Failed to get KuCoin balance of token1")
-		return
+		return nil
+	}
+
+	e.logger.Debugf("KuCoin balances: %.18f %s, %.18f %s", token0Kucoin, e.token0, token1Kucoin, e.token1)
+
+	balances := pnl.Balances{
+		"UNISWAP": {
+			"ETH":    toDecimal(ethBalance),
+			e.token0: toDecimal(token0Uniswap),
+			e.token1: toDecimal(token1Uniswap),
+		},
+		"KUCOIN": {
+			e.token0: decimal.NewFromFloat(token0Kucoin),
+			e.token1: decimal.NewFromFloat(token1Kucoin),
+		},
+	}
+	e.setBalances(balances)
+	return balances
+}
+
+// LastBalances returns the balances read last, without refreshing them
+func (e *Executor) LastBalances() pnl.Balances {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.balances
+}
+
+// SnapshotBalances reads the balances of both venues while no trade is in flight. The pending swaps mined
+// by then are settled first, so the ledger explains the balances read.
+func (e *Executor) SnapshotBalances() pnl.Balances {
+	e.tradeLock.Lock()
+	defer e.tradeLock.Unlock()
+	if !e.paperTrading {
+		e.settlePending(context.Background())
 	}
+	return e.GetBalances()
+}
 
-	e.balances["KUCOIN"+e.token0] = strconv.FormatFloat(token0Kucoin, 'f', -1, 64)
-	e.balances["KUCOIN"+e.token1] = strconv.FormatFloat(token1Kucoin, 'f', -1, 64)
+// setBalances stores the last known balances
+func (e *Executor) setBalances(balances pnl.Balances) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.balances = balances
 
-	e.logger.Debugf("KuCoin balances: %.18f ELON, %.18f USDT", token0Kucoin, token1Kucoin)
+	// On-chain balances are those of the account trading the market. The gauges are reset first so that a
+	// token no longer reported does not keep its last balance.
+	address := e.uniswapClient.Address().Hex()
+	metrics.ResetBalances(e.tradingPair)
+	for venue, tokens := range balances {
+		account := ""
+		if venue == "UNISWAP" {
+			account = address
+		}
+		for token, amount := range tokens {
+			metrics.SetBalance(e.tradingPair, venue, account, token, amount.InexactFloat64())
+		}
+	}
+	metrics.SetNonce(address, e.uniswapClient.GetNonce())
+}
+
+// tradeOptions builds the swap protection for the Uniswap leg. In hedge mode the minimum output is the
+// amount that keeps the whole arbitrage profitable once hedged on KuCoin at kucoinPrice.
+func tradeOptions(config Config, amountIn *coreentities.CurrencyAmount, kucoinPrice float64, buyOnUniswap bool) (uniswap.TradeOptions, error) {
+	opts := uniswap.TradeOptions{
+		SlippageMode: config.SlippageMode,
+		SlippageBps:  config.SlippageBps,
+		Deadline:     config.Deadline,
+	}
+	if config.SlippageMode != uniswap.SlippageHedge {
+		return opts, nil
+	}
+
+	amount, err := decimal.NewFromString(amountIn.ToExact())
+	if err != nil {
+		return opts, err
+	}
+	one := decimal.NewFromInt(1)
+	price := decimal.NewFromFloat(kucoinPrice)
+	fee := decimal.New(config.KucoinFeeBps, -4)
+	profit := one.Add(decimal.New(config.MinProfitBps, -4))
+
+	if buyOnUniswap {
+		// We pay amount token1 and must sell what we get on KuCoin for at least that much
+		opts.MinAmountOut = amount.Div(price.Mul(one.Sub(fee))).Mul(profit)
+	} else {
+		// We pay amount token0 and must get enough token1 to buy it back on KuCoin
+		opts.MinAmountOut = amount.Mul(price).Mul(one.Add(fee)).Mul(profit)
+	}
+
+	return opts, nil
 }
 
 // ExecuteArbitrage executes an arbitrage trade
 func (e *Executor) ExecuteArbitrage() {
-	e.logger.Info("Executing arbitrage trade")
-	e.GetBalances()
+	e.tradeLock.Lock()
+	defer e.tradeLock.Unlock()
 
-	kucoinPrice, err := e.kucoinClient.GetPrice()
-	if err != nil {
-		e.logger.WithError(err).Error("Failed to get KuCoin price")
+	if e.isDraining() {
+		e.logger.Info("Skipping arbitrage trade, shutting down")
 		return
 	}
-	uniswapPrice, err := e.uniswapClient.GetPrice()
+
+	e.logger.Info("Executing arbitrage trade")
+	tradeID, kucoinPrice, uniswapPrice, err := e.opportunity()
 	if err != nil {
-		e.logger.WithError(err).Error("Failed to get Uniswap price")
 		return
 	}
 
 	// Calculate the average price
 	avgPrice := (kucoinPrice + uniswapPrice) / 2
-
-	e.logger.Infof("KuCoin price: %.18f, Uniswap price: %.18f, Average price: %.18f", kucoinPrice, uniswapPrice, avgPrice)
+	logger := e.logger.WithField("trade_id", tradeID)
+	logger.Infof("Trade %s: KuCoin price: %.18f, Uniswap price: %.18f, Average price: %.18f", tradeID, kucoinPrice, uniswapPrice, avgPrice)
 
 	// Do we buy or sell?
-	if uniswapPrice < kucoinPrice {
+	a := &arbitrage{tradeID: tradeID, kucoinPrice: kucoinPrice, buyOnUniswap: uniswapPrice < kucoinPrice, config: e.GetConfig()}
+	if a.buyOnUniswap {
 		// Buy on Uniswap, Sell on KuCoin
 		buyAmount, err := e.uniswapClient.GetBuyAmount(avgPrice)
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to get buy amount")
+			logger.WithError(err).Error("Failed to get buy amount")
+			e.reject(tradeID, err)
 			return
 		}
 
-		e.logger.Infof("Buy %s %s on Uniswap and Sell them on Kucoin", buyAmount.ToExact(), buyAmount.Currency.Symbol())
-
-		err = e.uniswapClient.Trade(buyAmount, e.paperTrading)
+		// The KuCoin size is only known once the swap is done, use the expected output until then
+		expected, err := e.uniswapClient.Quote(buyAmount)
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to trade on Uniswap")
+			logger.WithError(err).Error("Failed to quote buy amount")
+			e.reject(tradeID, err)
 			return
 		}
 
-		err = e.kucoinClient.Trade("sell", buyAmount.Currency.Symbol(), buyAmount.ToFixed(2), kucoinPrice, e.paperTrading)
+		logger.Infof("Buy %s %s on Uniswap and Sell them on Kucoin", buyAmount.ToExact(), buyAmount.Currency.Symbol())
+		a.swapAmount, a.orderSide, a.orderAmount = buyAmount, "sell", expected
+	} else {
+		// Sell on Uniswap, Buy on KuCoin
+		sellAmount, err := e.uniswapClient.GetSellAmount(avgPrice)
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to trade on KuCoin")
+			logger.WithError(err).Error("Failed to get sell amount")
+			e.reject(tradeID, err)
 			return
 		}
 
+		logger.Infof("Sell %s %s on Uniswap and Buy them on Kucoin", sellAmount.ToExact(), sellAmount.Currency.Symbol())
+		a.swapAmount, a.orderSide, a.orderAmount = sellAmount, "buy", sellAmount
+	}
+
+	_ = e.execute(a, kucoinPrice, logger)
+}
+
+// ExecuteTrade executes an arbitrage of size token0 in the given direction, whatever the spread. It goes
+// through the risk checks and leg ordering of any other trade and returns its trade ID.
+func (e *Executor) ExecuteTrade(buyOnUniswap bool, size decimal.Decimal) (string, error) {
+	e.tradeLock.Lock()
+	defer e.tradeLock.Unlock()
+
+	if e.isDraining() {
+		return "", ErrDraining
+	}
+	if !size.IsPositive() {
+		return "", fmt.Errorf("Invalid trade size %s", size.String())
+	}
+
+	e.logger.Infof("Executing manual trade of %s %s", size.String(), e.token0)
+	tradeID, kucoinPrice, _, err := e.opportunity()
+	if err != nil {
+		return "", err
+	}
+	logger := e.logger.WithField("trade_id", tradeID)
+
+	token0, _ := e.uniswapClient.GetTokens()
+	amount := coreentities.FromRawAmount(token0, size.Shift(int32(token0.Decimals())).Floor().BigInt())
+
+	a := &arbitrage{tradeID: tradeID, kucoinPrice: kucoinPrice, buyOnUniswap: buyOnUniswap, config: e.GetConfig()}
+	if buyOnUniswap {
+		// Swap the token1 needed to buy size token0, then sell them on KuCoin
+		buyAmount, err := e.uniswapClient.QuoteInput(amount)
+		if err != nil {
+			err = fmt.Errorf("Failed to quote buy amount: %w", err)
+			e.reject(tradeID, err)
+			return tradeID, err
+		}
+
+		logger.Infof("Buy %s %s with %s %s on Uniswap and Sell them on Kucoin", amount.ToExact(), e.token0, buyAmount.ToExact(), buyAmount.Currency.Symbol())
+		a.swapAmount, a.orderSide, a.orderAmount = buyAmount, "sell", amount
 	} else {
-		// Sell on Uniswap, Buy on KuCoin
-		sellAmount, err := e.uniswapClient.GetSellAmount(avgPrice)
+		logger.Infof("Sell %s %s on Uniswap and Buy them on Kucoin", amount.ToExact(), e.token0)
+		a.swapAmount, a.orderSide, a.orderAmount = amount, "buy", amount
+	}
+
+	return tradeID, e.execute(a, kucoinPrice, logger)
+}
+
+// opportunity refreshes the balances and prices and records a new opportunity in the ledger
+func (e *Executor) opportunity() (string, float64, float64, error) {
+	e.GetBalances()
+
+	kucoinPrice, err := e.kucoinClient.GetPrice()
+	if err != nil {
+		e.logger.WithError(err).Error("Failed to get KuCoin price")
+		return "", 0, 0, fmt.Errorf("Failed to get KuCoin price: %w", err)
+	}
+	uniswapPrice, err := e.uniswapClient.GetPrice()
+	if err != nil {
+		e.logger.WithError(err).Error("Failed to get Uniswap price")
+		return "", 0, 0, fmt.Errorf("Failed to get Uniswap price: %w", err)
+	}
+
+	tradeID := ledger.NewTradeID()
+	e.record(e.ledger.RecordOpportunity(&ledger.Opportunity{
+		TradeID:      tradeID,
+		Time:         time.Now(),
+		Market:       e.tradingPair,
+		UniswapPrice: decimal.NewFromFloat(uniswapPrice),
+		KucoinPrice:  decimal.NewFromFloat(kucoinPrice),
+	}))
+
+	return tradeID, kucoinPrice, uniswapPrice, nil
+}
+
+// execute checks a sized arbitrage against the risk limits and executes its legs. It returns the risk
+// rejection or the errors of the failed legs.
+func (e *Executor) execute(a *arbitrage, kucoinPrice float64, logger *logrus.Entry) error {
+	tradeID := a.tradeID
+	intent := e.intent(a)
+	if err := e.risk.Check(intent); err != nil {
+		logger.WithError(err).Warn("Trade rejected by the risk manager")
+		e.reject(tradeID, err)
+		return err
+	}
+
+	e.record(e.ledger.RecordDecision(&ledger.Decision{TradeID: tradeID, Time: time.Now(), Action: ledger.DecisionExecute}))
+	metrics.AddTrade(e.tradingPair, ledger.DecisionExecute)
+	e.record(e.ledger.RecordIntent(&ledger.Intent{
+		TradeID:      tradeID,
+		Time:         time.Now(),
+		Market:       e.tradingPair,
+		BuyOnUniswap: a.buyOnUniswap,
+		LegOrder:     a.config.LegOrder,
+		SwapToken:    a.swapAmount.Currency.Symbol(),
+		SwapAmount:   toDecimal(a.swapAmount),
+		OrderSide:    a.orderSide,
+		OrderSize:    intent.Exposure[e.token0],
+		Notional:     intent.Notional,
+		Paper:        e.paperTrading,
+	}))
+
+	var legs *legResults
+	switch a.config.LegOrder {
+	case LegOrderCexFirst:
+		legs = e.executeCexFirst(a)
+	case LegOrderSimultaneous:
+		legs = e.executeSimultaneous(a)
+	default:
+		legs = e.executeDexFirst(a)
+	}
+
+	if legs.swapErr != nil {
+		logger.WithError(legs.swapErr).Error("Failed to trade on Uniswap")
+	}
+	if legs.orderErr != nil {
+		logger.WithError(legs.orderErr).Error("Failed to trade on KuCoin")
+	}
+	if (legs.swapErr == nil) != (legs.orderErr == nil) {
+		logger.Errorf("Arbitrage left unhedged in %s mode: only one leg was executed", a.config.LegOrder)
+	}
+
+	for _, leg := range []struct {
+		name string
+		err  error
+	}{{"swap", legs.swapErr}, {"order", legs.orderErr}} {
+		if errors.Is(leg.err, errLegSkipped) {
+			continue
+		}
+		e.risk.RecordLeg(leg.err == nil)
+		metrics.AddLeg(e.tradingPair, leg.name, metrics.LegAttempted)
+		if leg.err == nil {
+			metrics.AddLeg(e.tradingPair, leg.name, metrics.LegSucceeded)
+		} else {
+			metrics.AddLeg(e.tradingPair, leg.name, metrics.LegFailed)
+		}
+	}
+	metrics.AddGas(e.tradingPair, legs.swapGas.InexactFloat64())
+	e.risk.RecordTrade(e.settle(a, legs))
+
+	if e.paperTrading {
+		logger.Info(e.paper.Report(kucoinPrice))
+	}
+	logger.Info(e.pnl.Report())
+
+	if legs.swapErr == nil && legs.orderErr == nil {
+		logger.Debug("Trade executed successfully")
+		return nil
+	}
+	return errors.Join(legs.swapErr, legs.orderErr)
+}
+
+// intent describes an arbitrage to the risk manager. If only one leg goes through, the trade size is left
+// open in token0 and the notional in token1.
+func (e *Executor) intent(a *arbitrage) risk.Intent {
+	swapAmount := toDecimal(a.swapAmount)
+	size := toDecimal(a.orderAmount)
+
+	notional := swapAmount
+	if !a.buyOnUniswap {
+		notional = swapAmount.Mul(decimal.NewFromFloat(a.kucoinPrice))
+	}
+
+	return risk.Intent{
+		Notional: notional,
+		Exposure: map[string]decimal.Decimal{e.token0: size, e.token1: notional},
+	}
+}
+
+// settle books the legs of an arbitrage in the PnL engine and records its realized PnL
+func (e *Executor) settle(a *arbitrage, legs *legResults) risk.Trade {
+	price := decimal.NewFromFloat(a.kucoinPrice)
+	fills := pnl.Fills{Gas: legs.swapGas}
+
+	if legs.swapErr == nil {
+		swapIn, swapOut := toDecimal(legs.swapIn), toDecimal(legs.swapOut)
+		poolFee := swapIn.Mul(e.uniswapClient.GetFee())
+		if a.buyOnUniswap {
+			fills.Acquired, fills.Cost, fills.PoolFee = swapOut, swapIn, poolFee
+		} else {
+			fills.Disposed, fills.Proceeds, fills.PoolFee = swapIn, swapOut, poolFee.Mul(price)
+		}
+	}
+	if legs.orderErr == nil {
+		e.addOrderFills(&fills, a.orderSide, legs.order, price)
+	}
+
+	return e.book(a.tradeID, fills, a.kucoinPrice)
+}
+
+// addOrderFills adds the fills of a KuCoin order to fills. The fee is assumed to be paid in token1 unless it
+// is charged in token0, it is then converted at price.
+func (e *Executor) addOrderFills(fills *pnl.Fills, side string, order *kucoin.OrderResult, price decimal.Decimal) {
+	fee := order.Fee
+	if order.FeeCurrency == e.token0 {
+		fee = fee.Mul(price)
+	}
+	fills.KucoinFee = fills.KucoinFee.Add(fee)
+	if side == "sell" {
+		fills.Disposed, fills.Proceeds = fills.Disposed.Add(order.DealSize), fills.Proceeds.Add(order.DealFunds.Sub(fee))
+	} else {
+		fills.Acquired, fills.Cost = fills.Acquired.Add(order.DealSize), fills.Cost.Add(order.DealFunds.Add(fee))
+	}
+}
+
+// book settles fills in the PnL engine, records the realized PnL and returns the trade to account for in the
+// risk manager. kucoinPrice converts gas to token1.
+func (e *Executor) book(tradeID string, fills pnl.Fills, kucoinPrice float64) risk.Trade {
+	attribution := e.pnl.Settle(tradeID, fills, e.ethPrice(kucoinPrice))
+	e.record(e.ledger.RecordPnL(attribution))
+	e.persistPnL()
+
+	realized := attribution.Net.Add(attribution.Gas)
+	return risk.Trade{
+		RealizedPnL: attribution.Net,
+		Gas:         fills.Gas,
+		Inventory: map[string]decimal.Decimal{
+			e.token0: fills.Acquired.Sub(fills.Disposed),
+			e.token1: fills.Proceeds.Sub(fills.Cost).Sub(realized),
+		},
+	}
+}
+
+// executeDexFirst swaps on Uniswap, then hedges what was actually swapped on KuCoin
+func (e *Executor) executeDexFirst(a *arbitrage) *legResults {
+	legs := &legResults{}
+
+	legs.swapIn = a.swapAmount
+	legs.swapOut, legs.swapGas, legs.swapErr = e.swapLeg(context.Background(), a, a.swapAmount)
+	if legs.swapErr != nil {
+		legs.orderErr = errLegSkipped
+		return legs
+	}
+
+	orderAmount := a.orderAmount
+	if a.buyOnUniswap {
+		orderAmount = legs.swapOut
+	}
+	legs.order, legs.orderErr = e.order(context.Background(), a, orderAmount)
+	return legs
+}
+
+// executeCexFirst places the KuCoin order, then swaps on Uniswap only the share that was filled
+func (e *Executor) executeCexFirst(a *arbitrage) *legResults {
+	legs := &legResults{}
+
+	legs.order, legs.orderErr = e.order(context.Background(), a, a.orderAmount)
+	if legs.orderErr == nil && legs.order.DealSize.IsZero() {
+		legs.orderErr = fmt.Errorf("KuCoin order %s was not filled", legs.order.OrderID)
+	}
+	if legs.orderErr != nil {
+		legs.swapErr = errLegSkipped
+		return legs
+	}
+
+	swapAmount := scaleToFill(a.swapAmount, a.orderAmount, legs.order.DealSize)
+	legs.swapIn = swapAmount
+	legs.swapOut, legs.swapGas, legs.swapErr = e.swapLeg(context.Background(), a, swapAmount)
+	return legs
+}
+
+// executeSimultaneous fires both legs in parallel. Past the leg timeout, an order still open is cancelled
+// and a swap not mined yet is no longer waited for. Both legs are waited for, so what they actually executed
+// is booked before the trade lock is released.
+func (e *Executor) executeSimultaneous(a *arbitrage) *legResults {
+	legs := &legResults{swapIn: a.swapAmount}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.LegTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// A panicking leg is a failed leg, the other one is still waited for
+	go func() {
+		defer wg.Done()
+		legs.swapErr = supervisor.Recover(e.logger.WithField("leg", "swap"), func() error {
+			var err error
+			legs.swapOut, legs.swapGas, err = e.swapLeg(ctx, a, a.swapAmount)
+			return err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		legs.orderErr = supervisor.Recover(e.logger.WithField("leg", "order"), func() error {
+			var err error
+			legs.order, err = e.order(ctx, a, a.orderAmount)
+			return err
+		})
+	}()
+	wg.Wait()
+
+	return legs
+}
+
+// swapLeg executes the Uniswap leg of an arbitrage for amount, until ctx expires
+func (e *Executor) swapLeg(ctx context.Context, a *arbitrage, amount *coreentities.CurrencyAmount) (*coreentities.CurrencyAmount, decimal.Decimal, error) {
+	opts, err := tradeOptions(a.config, amount, a.kucoinPrice, a.buyOnUniswap)
+	if err != nil {
+		return nil, decimal.Zero, fmt.Errorf("Failed to build trade options: %w", err)
+	}
+	out, gas, err := e.swap(ctx, a.tradeID, amount, opts)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w after %s: %w", errLegTimeout, a.config.LegTimeout, err)
+	}
+	return out, gas, err
+}
+
+// scaleToFill scales amount down by the share of ordered that was filled
+func scaleToFill(amount, ordered *coreentities.CurrencyAmount, filled decimal.Decimal) *coreentities.CurrencyAmount {
+	size := toDecimal(ordered)
+	if size.IsZero() || filled.GreaterThanOrEqual(size) {
+		return amount
+	}
+
+	raw := decimal.NewFromBigInt(amount.Quotient(), 0).Mul(filled).Div(size).Floor().BigInt()
+	return coreentities.FromRawAmount(amount.Currency, raw)
+}
+
+// swap executes the Uniswap leg and returns the amount received and the gas spent in ETH. Live swaps are
+// followed until mined, a reverted swap is a failed leg and one not mined in time stays pending.
+func (e *Executor) swap(ctx context.Context, tradeID string, amount *coreentities.CurrencyAmount, opts uniswap.TradeOptions) (*coreentities.CurrencyAmount, decimal.Decimal, error) {
+	// Tokens are recorded with the symbols of the trading pair, the pool may use wrapped symbols
+	token0, token1 := e.uniswapClient.GetTokens()
+	tokenIn, tokenOut, addrIn, addrOut := e.token1, e.token0, token1.Address.Hex(), token0.Address.Hex()
+	if amount.Currency.Wrapped().Equal(token0) {
+		tokenIn, tokenOut, addrIn, addrOut = e.token0, e.token1, addrOut, addrIn
+	}
+
+	tx := &ledger.Tx{
+		TradeID:      tradeID,
+		Time:         time.Now(),
+		Market:       e.tradingPair,
+		From:         e.uniswapClient.Address().Hex(),
+		TokenIn:      tokenIn,
+		TokenInAddr:  addrIn,
+		AmountIn:     toDecimal(amount),
+		TokenOut:     tokenOut,
+		TokenOutAddr: addrOut,
+		Status:       ledger.TxStatusFailed,
+	}
+	defer func() { e.record(e.ledger.RecordTx(tx)) }()
+
+	if e.paperTrading {
+		output, fill, err := e.paper.Swap(amount, opts)
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to get sell amount")
-			return
+			tx.Error = err.Error()
+			return nil, decimal.Zero, err
 		}
+		tx.AmountOut = toDecimal(output)
+		tx.GasCost, tx.Status = fill.Gas, ledger.TxStatusPaper
+		return output, fill.Gas, nil
+	}
 
-		e.logger.Infof("Sell %s %s on Uniswap and Buy them on Kucoin", sellAmount.ToExact(), sellAmount.Currency.Symbol())
-		err = e.uniswapClient.Trade(sellAmount, e.paperTrading)
+	swap, err := e.uniswapClient.Trade(ctx, amount, opts, false)
+	if swap == nil || swap.TxHash == (common.Hash{}) {
+		tx.Error = err.Error()
+		return nil, decimal.Zero, err
+	}
+	if err != nil {
+		e.logger.WithFields(logrus.Fields{"trade_id": tradeID, "tx_hash": swap.TxHash.String()}).WithError(err).Warn("Swap sent with errors")
+	}
+	e.logger.WithFields(logrus.Fields{"trade_id": tradeID, "tx_hash": swap.TxHash.String()}).Infof("Uniswap swap %s simulated %s %s out (expected %s, minimum %s)", swap.TxHash.String(), swap.Simulation.AmountOut.ToExact(), swap.Simulation.AmountOut.Currency.Symbol(), swap.ExpectedOut.ToExact(), swap.MinAmountOut.ToExact())
+
+	// The simulated output stands until the swap is mined
+	tx.Hash = swap.TxHash.String()
+	tx.AmountOut = toDecimal(swap.Simulation.AmountOut)
+	tx.GasUsed, tx.GasPrice = swap.Simulation.GasUsed, decimal.NewFromBigInt(swap.GasPrice, 0)
+	tx.Status = ledger.TxStatusPending
+	e.record(e.ledger.RecordTx(tx))
+	e.setPending(tx, true)
+
+	// A swap not mined in time may still be, it stays pending and is resolved by the mark loop
+	receipt, err := e.uniswapClient.WaitForReceipt(ctx, swap.TxHash)
+	if receipt == nil {
+		tx.Error = err.Error()
+		return nil, decimal.Zero, err
+	}
+	defer e.setPending(tx, false)
+
+	output, gas, err := e.settleTx(tx, receipt)
+	if err != nil {
+		return nil, gas, err
+	}
+	return coreentities.FromRawAmount(swap.Simulation.AmountOut.Currency, output), gas, nil
+}
+
+// settleTx fills tx with the outcome of its mined receipt, and returns the raw amount received and the gas
+// spent in ETH. The amount received is read from the transfer of the pool, a reverted swap is an error.
+func (e *Executor) settleTx(tx *ledger.Tx, receipt *types.Receipt) (*big.Int, decimal.Decimal, error) {
+	gasPrice := receipt.EffectiveGasPrice
+	if gasPrice == nil {
+		gasPrice = tx.GasPrice.BigInt()
+	}
+	gas := decimal.NewFromBigInt(new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed)), -18)
+	tx.GasUsed, tx.GasPrice, tx.GasCost = receipt.GasUsed, decimal.NewFromBigInt(gasPrice, 0), gas
+	tx.BlockNumber = receipt.BlockNumber.Uint64()
+	tx.Settled = time.Now()
+	tx.Error = ""
+
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		err := fmt.Errorf("%w: %s", uniswap.ErrTxFailed, tx.Hash)
+		tx.Status, tx.Error = ledger.TxStatusFailed, err.Error()
+		return nil, gas, err
+	}
+
+	// The output token is the wrapped pool token, the pool pays WETH to the router when it unwraps ETH
+	token0, token1 := e.uniswapClient.GetTokens()
+	tokenOut := token0
+	if !strings.EqualFold(tx.TokenOutAddr, token0.Address.Hex()) {
+		tokenOut = token1
+	}
+	output, err := e.uniswapClient.SwapOutput(receipt, tokenOut.Address)
+	if err != nil {
+		// The swap is mined, the simulated output is kept
+		tx.Status, tx.Error = ledger.TxStatusSuccess, err.Error()
+		e.logger.WithField("tx_hash", tx.Hash).WithError(err).Warn("Failed to read the swap output, keeping the simulated output")
+		return tx.AmountOut.Shift(int32(tokenOut.Decimals())).BigInt(), gas, nil
+	}
+	tx.AmountOut = decimal.NewFromBigInt(output, -int32(tokenOut.Decimals()))
+	tx.Status = ledger.TxStatusSuccess
+	return output, gas, nil
+}
+
+// resolvePending settles the swaps left pending in the ledger once they are mined. It waits for the next
+// mark while a trade is in flight.
+func (e *Executor) resolvePending(ctx context.Context) {
+	if !e.tradeLock.TryLock() {
+		return
+	}
+	defer e.tradeLock.Unlock()
+	e.settlePending(ctx)
+}
+
+// settlePending settles the swaps of the market left pending in the ledger that are mined, the trade lock must
+// be held. A swap left pending by a trade was reported failed to the trade, so its outcome is only recorded and
+// reported.
+func (e *Executor) settlePending(ctx context.Context) {
+	txs, err := e.ledger.ListPendingTxs()
+	if err != nil {
+		e.logger.WithError(err).Error("Failed to list pending transactions")
+		return
+	}
+
+	from := e.uniswapClient.Address().Hex()
+	for _, tx := range txs {
+		// Swaps recorded without their market date from a single market per account, they are those of the market
+		if tx.From != from || (tx.Market != "" && tx.Market != e.tradingPair) {
+			continue
+		}
+		e.setPending(tx, true)
+
+		receipt, err := e.uniswapClient.Receipt(ctx, common.HexToHash(tx.Hash))
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to trade on Uniswap")
-			return
+			e.logger.WithField("tx_hash", tx.Hash).WithError(err).Warn("Failed to check a pending swap")
+			continue
+		}
+		if receipt == nil {
+			continue
 		}
 
-		err = e.kucoinClient.Trade("buy", sellAmount.Currency.Symbol(), sellAmount.ToFixed(2), kucoinPrice, e.paperTrading)
+		_, _, err = e.settleTx(tx, receipt)
+		e.record(e.ledger.RecordTx(tx))
+		e.setPending(tx, false)
+		logger := e.logger.WithFields(logrus.Fields{"trade_id": tx.TradeID, "tx_hash": tx.Hash})
 		if err != nil {
-			e.logger.WithError(err).Error("Failed to trade on KuCoin")
-			return
+			logger.WithError(err).Warn("Pending swap failed")
+			continue
+		}
+		logger.Warnf("Pending swap mined in block %d, %s %s in for %s %s out, the trade was not hedged for it", tx.BlockNumber, tx.AmountIn.String(), tx.TokenIn, tx.AmountOut.String(), tx.TokenOut)
+	}
+}
+
+// order executes the KuCoin hedge leg of an arbitrage for amount of token0 and returns its fills. An order
+// cancelled when ctx expires counts as executed if it was partially filled.
+func (e *Executor) order(ctx context.Context, a *arbitrage, amount *coreentities.CurrencyAmount) (*kucoin.OrderResult, error) {
+	result, err := e.placeOrder(ctx, a.tradeID, a.orderSide, amount.ToExact(), a.kucoinPrice, a.config.HedgeOrder)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w after %s: %w", errLegTimeout, a.config.LegTimeout, err)
+	}
+	if err != nil && (result == nil || result.DealSize.IsZero()) {
+		return nil, err
+	}
+
+	if result.DealSize.LessThan(result.Size) {
+		e.logger.WithFields(logrus.Fields{"trade_id": a.tradeID, "order_id": result.OrderID}).Warnf("KuCoin %s order %s partially filled: %s of %s %s", a.orderSide, result.OrderID, result.DealSize.String(), result.Size.String(), e.token0)
+	}
+
+	return result, nil
+}
+
+// Rebalance places a KuCoin order for size of token0 with the rebalance order options, using the current
+// KuCoin price as reference price. The order is checked against the risk limits but the inventory limit, a
+// rebalance is how the open inventory is brought back, and its fills are booked like those of a trade.
+func (e *Executor) Rebalance(side, size string) (*kucoin.OrderResult, error) {
+	e.tradeLock.Lock()
+	defer e.tradeLock.Unlock()
+
+	if e.isDraining() {
+		return nil, ErrDraining
+	}
+
+	orderSize, err := decimal.NewFromString(size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rebalance size %s", size)
+	}
+	price, err := e.kucoinClient.GetPrice()
+	if err != nil {
+		return nil, err
+	}
+
+	tradeID := ledger.NewTradeID()
+	logger := e.logger.WithField("trade_id", tradeID)
+	notional := orderSize.Mul(decimal.NewFromFloat(price))
+	if err := e.risk.Check(risk.Intent{Notional: notional}); err != nil {
+		logger.WithError(err).Warn("Rebalance rejected by the risk manager")
+		return nil, err
+	}
+
+	logger.Infof("Trade %s: rebalancing %s %s %s on KuCoin", tradeID, side, size, e.token0)
+	e.record(e.ledger.RecordIntent(&ledger.Intent{
+		TradeID:   tradeID,
+		Time:      time.Now(),
+		Market:    e.tradingPair,
+		OrderSide: side,
+		OrderSize: orderSize,
+		Notional:  notional,
+		Paper:     e.paperTrading,
+	}))
+	result, err := e.placeOrder(context.Background(), tradeID, side, size, price, e.GetConfig().RebalanceOrder)
+	if result != nil && result.DealSize.IsPositive() {
+		// A resting order is booked for what filled by now, the rest of it is left to the reconciliation
+		var fills pnl.Fills
+		e.addOrderFills(&fills, side, result, decimal.NewFromFloat(price))
+		e.risk.RecordTrade(e.book(tradeID, fills, price))
+		logger.Info(e.pnl.Report())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// placeOrder places a KuCoin order, or simulates it in paper trading mode, and records it in the ledger. An
+// order created is returned along with the error, it may have been filled.
+func (e *Executor) placeOrder(ctx context.Context, tradeID, side, size string, price float64, opts kucoin.OrderOptions) (*kucoin.OrderResult, error) {
+	var result *kucoin.OrderResult
+	var err error
+	if e.paperTrading {
+		orderSize, parseErr := decimal.NewFromString(size)
+		if parseErr != nil {
+			return nil, parseErr
 		}
+		result, err = e.paper.Order(side, orderSize, decimal.NewFromFloat(price), opts)
+	} else {
+		result, err = e.kucoinClient.Trade(ctx, side, size, price, opts)
+	}
+	if result == nil {
+		return nil, err
+	}
+
+	// An order created but not read back is still recorded, it may be filling
+	e.record(e.ledger.RecordOrder(&ledger.Order{
+		TradeID:     tradeID,
+		Time:        time.Now(),
+		OrderID:     result.OrderID,
+		ClientOid:   result.ClientOid,
+		Market:      e.tradingPair,
+		Side:        result.Side,
+		Type:        result.Type,
+		Price:       result.Price,
+		Size:        result.Size,
+		Funds:       result.Funds,
+		DealSize:    result.DealSize,
+		DealFunds:   result.DealFunds,
+		Fee:         result.Fee,
+		FeeCurrency: result.FeeCurrency,
+		IsActive:    result.IsActive,
+	}))
+	if err != nil {
+		return result, err
 	}
 
-	e.logger.Debug("Trade executed successfully")
+	e.logger.WithFields(logrus.Fields{"trade_id": tradeID, "order_id": result.OrderID}).Infof("KuCoin %s %s order %s: filled %s %s for %s %s, fee %s %s", result.Type, side, result.OrderID, result.DealSize.String(), e.token0, result.DealFunds.String(), e.token1, result.Fee.String(), result.FeeCurrency)
+	return result, nil
+}
+
+// persistPnL stores the state of the PnL engine in the ledger, so the residual position and the realized PnL
+// carry over a restart. Paper trading starts afresh on every run, its state is not stored.
+func (e *Executor) persistPnL() {
+	if e.paperTrading {
+		return
+	}
+	state := e.pnl.State()
+	e.record(e.ledger.RecordPnLState(e.tradingPair, &state))
+}
+
+// RestorePnL restores the state of the PnL engine of market persisted in the ledger by a previous run, and its
+// history from the marks of the last day. It must run before trading starts.
+func RestorePnL(pnlEngine *pnl.Engine, tradeLedger *ledger.Ledger, market string) error {
+	state, err := tradeLedger.PnLState(market)
+	if err != nil || state == nil {
+		return err
+	}
+	marks, err := tradeLedger.ListMarks(time.Now().Add(-24*time.Hour), time.Time{})
+	if err != nil {
+		return err
+	}
+
+	history := make([]pnl.Mark, 0, len(marks))
+	for _, mark := range marks {
+		if mark.Market == market {
+			history = append(history, *mark)
+		}
+	}
+	pnlEngine.Restore(*state, history)
+	return nil
+}
+
+// RestoreRisk restores the risk state of market persisted in the ledger by a previous run, and counts the live
+// trades of market executed since the start of the UTC day towards the daily limits. It must run before
+// trading starts.
+func RestoreRisk(riskManager *risk.Manager, tradeLedger *ledger.Ledger, market string) error {
+	state, err := tradeLedger.RiskState(market)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &risk.State{}
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	trades, err := tradeLedger.ListTrades(day, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	var executed []risk.Trade
+	for _, trade := range trades {
+		if trade.PnL == nil || (trade.Intent != nil && trade.Intent.Paper) || tradeMarket(trade) != market {
+			continue
+		}
+		gas := decimal.Zero
+		for _, tx := range trade.Txs {
+			gas = gas.Add(tx.GasCost)
+		}
+		executed = append(executed, risk.Trade{Time: trade.PnL.Time, RealizedPnL: trade.PnL.Net, Gas: gas})
+	}
+
+	riskManager.Restore(*state, executed)
+	return nil
+}
+
+// tradeMarket returns the market a trade was made on
+func tradeMarket(trade *ledger.Trade) string {
+	if trade.Intent != nil {
+		return trade.Intent.Market
+	}
+	if trade.Opportunity != nil {
+		return trade.Opportunity.Market
+	}
+	return ""
+}
+
+// reject records that an opportunity was not executed
+func (e *Executor) reject(tradeID string, reason error) {
+	metrics.AddTrade(e.tradingPair, ledger.DecisionReject)
+	e.record(e.ledger.RecordDecision(&ledger.Decision{TradeID: tradeID, Time: time.Now(), Action: ledger.DecisionReject, Reason: reason.Error()}))
+}
+
+// record logs ledger write failures, they never interrupt trading
+func (e *Executor) record(err error) {
+	if err != nil {
+		e.logger.WithError(err).Error("Failed to write to the ledger")
+	}
+}
+
+// isEth returns whether token is ETH or wrapped ETH
+func isEth(token string) bool {
+	return token == "ETH" || token == "WETH"
+}
+
+// toDecimal converts a currency amount to a decimal
+func toDecimal(amount *coreentities.CurrencyAmount) decimal.Decimal {
+	return decimal.NewFromBigInt(amount.Quotient(), -int32(amount.Currency.Decimals()))
+}
+
+// Drain stops accepting new trades and waits until the trade in flight, if any, has reached a terminal
+// state or ctx expires
+func (e *Executor) Drain(ctx context.Context) error {
+	e.lock.Lock()
+	e.draining = true
+	e.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.tradeLock.Lock()
+		defer e.tradeLock.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Trade still in flight: %w", ctx.Err())
+	}
+}
+
+// GetConfig returns the execution settings
+func (e *Executor) GetConfig() Config {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.config
+}
+
+// SetConfig changes the execution settings. It waits for the trade in flight, the settings apply from the
+// next trade. The mark interval applies from the next mark.
+func (e *Executor) SetConfig(config Config) {
+	e.tradeLock.Lock()
+	defer e.tradeLock.Unlock()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.config = config
+	// Paper orders are charged the fee the trades are sized with
+	if e.paperTrading {
+		e.paper.SetKucoinFee(config.KucoinFeeBps)
+	}
+	e.logger.Infof("Execution settings changed to %+v", config)
+}
+
+// PendingTxs returns the swaps sent and not mined yet
+func (e *Executor) PendingTxs() []ledger.Tx {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	txs := make([]ledger.Tx, 0, len(e.pending))
+	for _, tx := range e.pending {
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Time.Before(txs[j].Time) })
+	return txs
+}
+
+// setPending tracks tx while it is pending
+func (e *Executor) setPending(tx *ledger.Tx, pending bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if pending {
+		e.pending[tx.Hash] = *tx
+	} else {
+		delete(e.pending, tx.Hash)
+	}
 }
 
-// Close closes the Executor
-func (e *Executor) Close() {
-	e.logger.Debug("Closing service")
-	// Nothing to do here
+// isDraining returns whether the executor is shutting down
+func (e *Executor) isDraining() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.draining
 }
@@ -0,0 +1,243 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"rattrap/arbitrage-bot/internal/ledger"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Row kinds. Every swap and order is a row, the realized PnL of a trade is a row of its own so that
+// summing a column never counts a trade twice.
+const (
+	KindSwap  = "swap"
+	KindOrder = "order"
+	KindPnL   = "pnl"
+)
+
+// Row is an exported record. Amounts are exact decimal strings, empty when they do not apply. PnL is in
+// the quote token of the market.
+type Row struct {
+	TradeID         string `json:"trade_id"`
+	Time            string `json:"time"`
+	Kind            string `json:"kind"`
+	Venue           string `json:"venue"`
+	Market          string `json:"market"`
+	Paper           bool   `json:"paper"`
+	Reference       string `json:"reference"` // Transaction hash or order ID
+	Account         string `json:"account"`   // On-chain account of swaps
+	Status          string `json:"status"`
+	Side            string `json:"side"`
+	TokenIn         string `json:"token_in"`
+	TokenInAddress  string `json:"token_in_address"`
+	AmountIn        string `json:"amount_in"`
+	TokenOut        string `json:"token_out"`
+	TokenOutAddress string `json:"token_out_address"`
+	AmountOut       string `json:"amount_out"`
+	Price           string `json:"price"` // Limit price of orders
+	Fee             string `json:"fee"`
+	FeeCurrency     string `json:"fee_currency"`
+	GasUsed         string `json:"gas_used"`
+	GasPrice        string `json:"gas_price"` // In wei
+	GasCost         string `json:"gas_cost"`  // In ETH
+	BlockNumber     string `json:"block_number"`
+	PnLGross        string `json:"pnl_gross"`
+	PnLPoolFee      string `json:"pnl_pool_fee"`
+	PnLKucoinFee    string `json:"pnl_kucoin_fee"`
+	PnLGas          string `json:"pnl_gas"`
+	PnLNet          string `json:"pnl_net"`
+	Error           string `json:"error"`
+}
+
+// header is the CSV header, in the order of the Row fields
+var header = []string{
+	"trade_id", "time", "kind", "venue", "market", "paper", "reference", "account", "status", "side",
+	"token_in", "token_in_address", "amount_in", "token_out", "token_out_address", "amount_out",
+	"price", "fee", "fee_currency", "gas_used", "gas_price", "gas_cost", "block_number",
+	"pnl_gross", "pnl_pool_fee", "pnl_kucoin_fee", "pnl_gas", "pnl_net", "error",
+}
+
+// Rows flattens trades into rows, oldest first. addresses maps token symbols to their on-chain address,
+// it fills in the addresses of order legs.
+func Rows(trades []*ledger.Trade, addresses map[string]string) []Row {
+	var rows []Row
+	for _, trade := range trades {
+		market, paper := "", false
+		if trade.Intent != nil {
+			market, paper = trade.Intent.Market, trade.Intent.Paper
+		} else if trade.Opportunity != nil {
+			market = trade.Opportunity.Market
+		}
+
+		for _, tx := range trade.Txs {
+			token0, _ := tokensOf(market)
+			side := "sell"
+			if tx.TokenOut == token0 {
+				side = "buy"
+			}
+			rows = append(rows, Row{
+				TradeID:         trade.ID,
+				Time:            formatTime(tx.Time),
+				Kind:            KindSwap,
+				Venue:           "UNISWAP",
+				Market:          market,
+				Paper:           paper || tx.Status == ledger.TxStatusPaper,
+				Reference:       tx.Hash,
+				Account:         tx.From,
+				Status:          tx.Status,
+				Side:            side,
+				TokenIn:         tx.TokenIn,
+				TokenInAddress:  address(tx.TokenInAddr, tx.TokenIn, addresses),
+				AmountIn:        tx.AmountIn.String(),
+				TokenOut:        tx.TokenOut,
+				TokenOutAddress: address(tx.TokenOutAddr, tx.TokenOut, addresses),
+				AmountOut:       tx.AmountOut.String(),
+				GasUsed:         strconv.FormatUint(tx.GasUsed, 10),
+				GasPrice:        tx.GasPrice.String(),
+				GasCost:         tx.GasCost.String(),
+				BlockNumber:     formatBlock(tx.BlockNumber),
+				Error:           tx.Error,
+			})
+		}
+
+		for _, order := range trade.Orders {
+			orderMarket := order.Market
+			if orderMarket == "" {
+				orderMarket = market
+			}
+			token0, token1 := tokensOf(orderMarket)
+			row := Row{
+				TradeID:     trade.ID,
+				Time:        formatTime(order.Time),
+				Kind:        KindOrder,
+				Venue:       "KUCOIN",
+				Market:      orderMarket,
+				Paper:       paper || strings.HasPrefix(order.OrderID, "paper-"),
+				Reference:   order.OrderID,
+				Status:      orderStatus(order),
+				Side:        order.Side,
+				Price:       order.Price.String(),
+				Fee:         order.Fee.String(),
+				FeeCurrency: order.FeeCurrency,
+			}
+			if order.Side == "buy" {
+				row.TokenIn, row.AmountIn, row.TokenOut, row.AmountOut = token1, order.DealFunds.String(), token0, order.DealSize.String()
+			} else {
+				row.TokenIn, row.AmountIn, row.TokenOut, row.AmountOut = token0, order.DealSize.String(), token1, order.DealFunds.String()
+			}
+			row.TokenInAddress, row.TokenOutAddress = addresses[row.TokenIn], addresses[row.TokenOut]
+			rows = append(rows, row)
+		}
+
+		if p := trade.PnL; p != nil {
+			rows = append(rows, Row{
+				TradeID:      trade.ID,
+				Time:         formatTime(p.Time),
+				Kind:         KindPnL,
+				Market:       market,
+				Paper:        paper,
+				PnLGross:     p.Gross.String(),
+				PnLPoolFee:   p.PoolFee.String(),
+				PnLKucoinFee: p.KucoinFee.String(),
+				PnLGas:       p.Gas.String(),
+				PnLNet:       p.Net.String(),
+			})
+		}
+	}
+	return rows
+}
+
+// Write writes rows to w in the given format
+func Write(w io.Writer, format string, rows []Row) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, rows)
+	case FormatJSONL:
+		return writeJSONL(w, rows)
+	default:
+		return fmt.Errorf("Unsupported export format %q", format)
+	}
+}
+
+// writeCSV writes rows as CSV with a header line
+func writeCSV(w io.Writer, rows []Row) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			r.TradeID, r.Time, r.Kind, r.Venue, r.Market, strconv.FormatBool(r.Paper), r.Reference, r.Account, r.Status, r.Side,
+			r.TokenIn, r.TokenInAddress, r.AmountIn, r.TokenOut, r.TokenOutAddress, r.AmountOut,
+			r.Price, r.Fee, r.FeeCurrency, r.GasUsed, r.GasPrice, r.GasCost, r.BlockNumber,
+			r.PnLGross, r.PnLPoolFee, r.PnLKucoinFee, r.PnLGas, r.PnLNet, r.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONL writes one JSON object per row
+func writeJSONL(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokensOf returns the base and quote tokens of a market, empty if the market is unknown
+func tokensOf(market string) (string, string) {
+	token0, token1, _ := strings.Cut(market, "-")
+	return token0, token1
+}
+
+// address returns the recorded address of a token, or the one known for its symbol
+func address(recorded, symbol string, addresses map[string]string) string {
+	if recorded != "" {
+		return recorded
+	}
+	return addresses[symbol]
+}
+
+// orderStatus describes the state of an order
+func orderStatus(order *ledger.Order) string {
+	switch {
+	case order.IsActive:
+		return "open"
+	case order.DealSize.IsZero():
+		return "cancelled"
+	case order.DealSize.LessThan(order.Size):
+		return "partially_filled"
+	default:
+		return "filled"
+	}
+}
+
+// formatTime formats a time as RFC 3339 in UTC, with nanoseconds
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatBlock formats a block number, empty if the transaction was not mined
+func formatBlock(block uint64) string {
+	if block == 0 {
+		return ""
+	}
+	return strconv.FormatUint(block, 10)
+}
@@ -0,0 +1,163 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckTimeout bounds each component check
+const CheckTimeout = 3 * time.Second
+
+// Component kinds. A liveness component failing means the process should be restarted, a readiness
+// component failing means it should be taken out of rotation. Info components are reported only.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+	Info      = "info"
+)
+
+// Component statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check returns nil when the component is healthy
+type Check func(ctx context.Context) error
+
+// Details returns extra fields reported along with a component status
+type Details func() map[string]interface{}
+
+// ComponentStatus is the result of a component check
+type ComponentStatus struct {
+	Status   string                 `json:"status"`
+	Kind     string                 `json:"kind"`
+	Error    string                 `json:"error,omitempty"`
+	Duration float64                `json:"duration_seconds"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the body of the health endpoints
+type Report struct {
+	Status     string                     `json:"status"`
+	Time       time.Time                  `json:"time"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type component struct {
+	name    string
+	kind    string
+	check   Check
+	details Details
+}
+
+// Checker runs the checks of the registered components
+type Checker struct {
+	lock       sync.Mutex
+	components []component
+}
+
+// NewChecker initializes a new Checker
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Register adds a component check of the given kind
+func (c *Checker) Register(name, kind string, check Check) {
+	c.RegisterDetailed(name, kind, check, nil)
+}
+
+// RegisterDetailed adds a component check of the given kind, reported with the fields returned by details
+func (c *Checker) RegisterDetailed(name, kind string, check Check, details Details) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.components = append(c.components, component{name: name, kind: kind, check: check, details: details})
+	sort.Slice(c.components, func(i, j int) bool { return c.components[i].name < c.components[j].name })
+}
+
+// Unregister removes the check of a component
+func (c *Checker) Unregister(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.components = slices.DeleteFunc(c.components, func(comp component) bool { return comp.name == name })
+}
+
+// Run checks every component concurrently. The report fails if a component of one of the given kinds
+// fails.
+func (c *Checker) Run(ctx context.Context, kinds ...string) Report {
+	c.lock.Lock()
+	components := append([]component(nil), c.components...)
+	c.lock.Unlock()
+
+	report := Report{Status: StatusOK, Time: time.Now(), Components: make(map[string]ComponentStatus, len(components))}
+	results := make([]ComponentStatus, len(components))
+
+	var wg sync.WaitGroup
+	for i, comp := range components {
+		wg.Add(1)
+		go func(i int, comp component) {
+			defer wg.Done()
+			results[i] = run(ctx, comp)
+		}(i, comp)
+	}
+	wg.Wait()
+
+	for i, comp := range components {
+		report.Components[comp.name] = results[i]
+		if results[i].Status == StatusOK {
+			continue
+		}
+		for _, kind := range kinds {
+			if comp.kind == kind {
+				report.Status = StatusFail
+			}
+		}
+	}
+
+	return report
+}
+
+// Handler serves the report of the checks, with a 503 status when one of the given kinds fails
+func (c *Checker) Handler(kinds ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context(), kinds...)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// run checks a component within CheckTimeout
+func run(ctx context.Context, comp component) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- comp.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	status := ComponentStatus{Status: StatusOK, Kind: comp.kind, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		status.Status, status.Error = StatusFail, err.Error()
+	}
+	if comp.details != nil {
+		status.Details = comp.details()
+	}
+	return status
+}
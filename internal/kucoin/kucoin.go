@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	kucoin "github.com/Kucoin/kucoin-go-sdk"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/metrics"
 	"rattrap/arbitrage-bot/internal/utils"
 )
 
@@ -19,10 +24,15 @@ type KucoinClient struct {
 	tradingPair string
 	token0      string
 	token1      string
+	lock        sync.RWMutex
+	rules       *SymbolRules
+	logger      *logrus.Entry
+	stopChan    chan struct{}
+	closeOnce   sync.Once
 }
 
 // NewKucoinClient initializes a new KuCoin API client
-func NewKucoinClient(tradingPair, apiKey, apiSecret, apiPassphrase string, context context.Context) (error, *KucoinClient) {
+func NewKucoinClient(tradingPair, apiKey, apiSecret, apiPassphrase string, logger *logging.Logger, context context.Context) (error, *KucoinClient) {
 	client := kucoin.NewApiService(
 		// kucoin.ApiBaseURIOption("https://api.kucoin.com"),
 		kucoin.ApiKeyOption(apiKey),
@@ -31,9 +41,40 @@ func NewKucoinClient(tradingPair, apiKey, apiSecret, apiPassphrase string, conte
 		kucoin.ApiKeyVersionOption(kucoin.ApiKeyVersionV2),
 	)
 
-	status, err := client.ServiceStatus(context)
+	if err := checkStatus(context, client); err != nil {
+		return err, nil
+	}
+
+	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
+
+	c := &KucoinClient{
+		client:      client,
+		context:     context,
+		tradingPair: tradingPair,
+		token0:      token0,
+		token1:      token1,
+		logger:      logger.WithField("prefix", "kucoin").WithField("market", tradingPair),
+		stopChan:    make(chan struct{}),
+	}
+
+	if err := c.LoadSymbolRules(); err != nil {
+		return fmt.Errorf("Failed to load symbol rules: %s", err), nil
+	}
+	go c.refreshSymbolRules()
+
+	return nil, c
+}
+
+// Ping checks that the KuCoin API is reachable and open
+func (c *KucoinClient) Ping(ctx context.Context) error {
+	return checkStatus(ctx, c.client)
+}
+
+// checkStatus fails unless the KuCoin API service status is open
+func checkStatus(ctx context.Context, client *kucoin.ApiService) error {
+	status, err := client.ServiceStatus(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to the KuCoin API"), nil
+		return fmt.Errorf("Failed to connect to the KuCoin API: %w", err)
 	}
 
 	var s struct {
@@ -41,27 +82,19 @@ func NewKucoinClient(tradingPair, apiKey, apiSecret, apiPassphrase string, conte
 	}
 	err = json.Unmarshal(status.RawData, &s)
 	if err != nil {
-		return fmt.Errorf("Failed to parse KuCoin API status"), nil
+		return fmt.Errorf("Failed to parse KuCoin API status: %w", err)
 	}
 
 	if s.Status != "open" {
-		return fmt.Errorf("KuCoin API is not open: %s", s.Status), nil
+		return fmt.Errorf("KuCoin API is not open: %s", s.Status)
 	}
 
-	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
-
-	return nil, &KucoinClient{
-		client:      client,
-		context:     context,
-		tradingPair: tradingPair,
-		token0:      token0,
-		token1:      token1,
-	}
+	return nil
 }
 
 // BalanceOf returns the balance of a currency
 func (c *KucoinClient) BalanceOf(currency string) (float64, error) {
-	account, err := c.client.Accounts(c.context, "", "")
+	account, err := c.call("accounts", func() (*kucoin.ApiResponse, error) { return c.client.Accounts(c.context, "", "") })
 	if err != nil {
 		return 0, fmt.Errorf("Failed to get account list: %s", err)
 	}
@@ -86,48 +119,81 @@ func (c *KucoinClient) BalanceOf(currency string) (float64, error) {
 
 // GetPrice returns the current price of a trading pair
 func (c *KucoinClient) GetPrice() (float64, error) {
-	ticker, err := c.client.TickerLevel1(c.context, c.tradingPair)
+	return c.GetPriceOf(c.tradingPair)
+}
+
+// GetPriceOf returns the current price of any symbol
+func (c *KucoinClient) GetPriceOf(symbol string) (float64, error) {
+	ticker, err := c.call("ticker", func() (*kucoin.ApiResponse, error) { return c.client.TickerLevel1(c.context, symbol) })
 	if err != nil {
-		return 0, fmt.Errorf("Failed to get ticker for %s: %s", c.tradingPair, err)
+		return 0, fmt.Errorf("Failed to get ticker for %s: %s", symbol, err)
 	}
 
 	t := &kucoin.TickerLevel1Model{}
 	if err := ticker.ReadData(t); err != nil {
-		return 0, fmt.Errorf("Failed to read ticker data for %s: %s", c.tradingPair, err)
+		return 0, fmt.Errorf("Failed to read ticker data for %s: %s", symbol, err)
 	}
 
 	price, err := strconv.ParseFloat(t.Price, 64)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to parse price for %s: %s", c.tradingPair, err)
+		return 0, fmt.Errorf("Failed to parse price for %s: %s", symbol, err)
 	}
 
 	return price, nil
 }
 
-// Trade executes a trade
-func (c *KucoinClient) Trade(side, symbol, size string, priceLimit float64, paper bool) error {
-	priceLimitStr := fmt.Sprintf("%.18f", priceLimit)
-	orderModel := &kucoin.CreateOrderModel{
-		ClientOid:   kucoin.IntToString(time.Now().UnixNano()),
-		Symbol:      c.tradingPair,
-		Side:        side,
-		Type:        "limit",
-		Price:       priceLimitStr,
-		Size:        size,
-		TimeInForce: "GTC",
-	}
-	var order *kucoin.ApiResponse
-	var err error
-	if paper {
-		order, err = c.client.CreateOrderTest(c.context, orderModel)
-	} else {
-		order, err = c.client.CreateOrder(c.context, orderModel)
-	}
-
-	fmt.Printf("order=%+v\n", order)
-	return err
+// GetOrderBook returns the top 100 levels of the order book of the trading pair
+func (c *KucoinClient) GetOrderBook() (*kucoin.PartOrderBookModel, error) {
+	book, err := c.call("orderbook", func() (*kucoin.ApiResponse, error) {
+		return c.client.AggregatedPartOrderBook(c.context, c.tradingPair, 100)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get order book for %s: %s", c.tradingPair, err)
+	}
+
+	b := &kucoin.PartOrderBookModel{}
+	if err := book.ReadData(b); err != nil {
+		return nil, fmt.Errorf("Failed to read order book for %s: %s", c.tradingPair, err)
+	}
+
+	return b, nil
+}
+
+// GetBestPrices returns the best bid and ask of the trading pair
+func (c *KucoinClient) GetBestPrices() (decimal.Decimal, decimal.Decimal, error) {
+	ticker, err := c.call("ticker", func() (*kucoin.ApiResponse, error) { return c.client.TickerLevel1(c.context, c.tradingPair) })
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Failed to get ticker for %s: %s", c.tradingPair, err)
+	}
+
+	t := &kucoin.TickerLevel1Model{}
+	if err := ticker.ReadData(t); err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Failed to read ticker data for %s: %s", c.tradingPair, err)
+	}
+
+	bid, err := decimal.NewFromString(t.BestBid)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Failed to parse best bid for %s: %s", c.tradingPair, err)
+	}
+	ask, err := decimal.NewFromString(t.BestAsk)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("Failed to parse best ask for %s: %s", c.tradingPair, err)
+	}
+
+	return bid, ask, nil
+}
+
+// call sends a request to the KuCoin API and records its latency and outcome under endpoint
+func (c *KucoinClient) call(endpoint string, request func() (*kucoin.ApiResponse, error)) (*kucoin.ApiResponse, error) {
+	start := time.Now()
+	response, err := request()
+	metrics.ObserveKucoin(endpoint, start, err != nil || !response.HttpSuccessful() || !response.ApiSuccessful())
+	return response, err
 }
 
-// Close closes the KuCoin client
+// Close closes the KuCoin client. Closing again does nothing.
 func (c *KucoinClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stopChan)
+	})
 }
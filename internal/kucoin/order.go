@@ -0,0 +1,320 @@
+package kucoin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kucoin "github.com/Kucoin/kucoin-go-sdk"
+	"github.com/shopspring/decimal"
+)
+
+// Order types
+const (
+	OrderTypeLimit  = "limit"
+	OrderTypeMarket = "market"
+)
+
+// Time in force of limit orders
+const (
+	TimeInForceGTC = "GTC" // Good till cancelled
+	TimeInForceIOC = "IOC" // Immediate or cancel
+	TimeInForceFOK = "FOK" // Fill or kill
+)
+
+// orderPollAttempts and orderPollInterval bound how long we wait for an immediate order to settle
+const (
+	orderPollAttempts  = 10
+	orderPollInterval  = 200 * time.Millisecond
+	orderCancelTimeout = 10 * time.Second
+)
+
+// ErrOrderCancelled is returned when an immediate order still open is cancelled because ctx expired
+var ErrOrderCancelled = fmt.Errorf("order cancelled before it settled")
+
+// ErrOutsidePriceBand is returned when a market order would fill beyond the price band
+var ErrOutsidePriceBand = fmt.Errorf("order book too thin within the price band")
+
+// OrderOptions controls how an order is placed
+type OrderOptions struct {
+	Type         string // OrderTypeLimit or OrderTypeMarket
+	TimeInForce  string // Time in force of limit orders
+	PostOnly     bool   // Only add liquidity, the order is rejected if it would take
+	PriceBandBps int64  // How far from the reference price an order may fill, in basis points
+}
+
+// OrderResult describes a placed order and its fills
+type OrderResult struct {
+	OrderID     string          `json:"order_id"`
+	ClientOid   string          `json:"client_oid,omitempty"`
+	Side        string          `json:"side"`
+	Type        string          `json:"type"`
+	Price       decimal.Decimal `json:"price"`      // Limit price, zero for market orders
+	Size        decimal.Decimal `json:"size"`       // Requested size, zero for market orders by funds
+	Funds       decimal.Decimal `json:"funds"`      // Requested funds of market orders by funds
+	DealSize    decimal.Decimal `json:"deal_size"`  // Filled size
+	DealFunds   decimal.Decimal `json:"deal_funds"` // Filled funds
+	Fee         decimal.Decimal `json:"fee"`
+	FeeCurrency string          `json:"fee_currency"`
+	IsActive    bool            `json:"is_active"` // Whether the order is still open on the book
+}
+
+// maxOpenOrders bounds the open orders listed, KuCoin pages are at most 500 orders
+const maxOpenOrders = 500
+
+// LimitPrice returns the limit price of an order given a reference price. Taker orders may trade up to
+// the price band away from the reference, post-only orders rest the price band behind it.
+func LimitPrice(side string, reference decimal.Decimal, opts OrderOptions) decimal.Decimal {
+	band := decimal.New(opts.PriceBandBps, -4)
+	one := decimal.NewFromInt(1)
+	if (side == "buy") != opts.PostOnly {
+		return reference.Mul(one.Add(band))
+	}
+	return reference.Mul(one.Sub(band))
+}
+
+// Trade places an order for size of token0. Taker orders use price as reference price, post-only orders
+// rest behind the best bid or ask. Size and price are rounded to the symbol increments and checked against
+// its minimums. Immediate orders (market, IOC, FOK) are followed until they settle, or cancelled if ctx
+// expires first: the result then holds the fills up to the cancel, along with ErrOrderCancelled.
+func (c *KucoinClient) Trade(ctx context.Context, side, size string, price float64, opts OrderOptions) (*OrderResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rules := c.GetSymbolRules()
+
+	orderSize, err := decimal.NewFromString(size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid order size %s: %s", size, err)
+	}
+	orderSize = rules.RoundSize(orderSize)
+
+	reference := decimal.NewFromFloat(price)
+	if opts.PostOnly {
+		bid, ask, err := c.GetBestPrices()
+		if err != nil {
+			return nil, err
+		}
+		reference = bid
+		if side == "sell" {
+			reference = ask
+		}
+	}
+	limitPrice := rules.RoundPrice(side, LimitPrice(side, reference, opts))
+
+	if err := rules.Validate(orderSize, limitPrice); err != nil {
+		return nil, err
+	}
+
+	orderModel := &kucoin.CreateOrderModel{
+		ClientOid: kucoin.IntToString(time.Now().UnixNano()),
+		Symbol:    c.tradingPair,
+		Side:      side,
+		Type:      opts.Type,
+	}
+	result := &OrderResult{ClientOid: orderModel.ClientOid, Side: side, Type: opts.Type}
+
+	switch opts.Type {
+	case OrderTypeMarket:
+		// A market order by size has no price cap, it is only sent if the book within the price band can absorb
+		// it. Buying by size rather than funds keeps the bought size equal to the hedged size.
+		if err := c.checkDepth(side, orderSize, limitPrice); err != nil {
+			return nil, err
+		}
+		orderModel.Size = orderSize.String()
+		result.Size = orderSize
+	case OrderTypeLimit, "":
+		orderModel.Type = OrderTypeLimit
+		orderModel.Price = limitPrice.String()
+		orderModel.Size = orderSize.String()
+		orderModel.TimeInForce = opts.TimeInForce
+		orderModel.PostOnly = opts.PostOnly
+		result.Type = OrderTypeLimit
+		result.Price = limitPrice
+		result.Size = orderSize
+	default:
+		return nil, fmt.Errorf("Unknown order type %s", opts.Type)
+	}
+
+	response, err := c.call("create_order", func() (*kucoin.ApiResponse, error) { return c.client.CreateOrder(c.context, orderModel) })
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create order: %s", err)
+	}
+
+	created := &kucoin.CreateOrderResultModel{}
+	if err := response.ReadData(created); err != nil {
+		return nil, fmt.Errorf("Failed to create order: %s", err)
+	}
+	result.OrderID = created.OrderId
+
+	c.logger.WithField("order_id", result.OrderID).Debugf("Created order %+v", orderModel)
+
+	immediate := opts.Type == OrderTypeMarket || opts.TimeInForce == TimeInForceIOC || opts.TimeInForce == TimeInForceFOK
+	for attempt := 0; attempt < orderPollAttempts; attempt++ {
+		if err := c.readOrder(result); err != nil {
+			return result, err
+		}
+		if !immediate || !result.IsActive {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return result, c.cancelImmediate(result, ctx.Err())
+		case <-time.After(orderPollInterval):
+		}
+	}
+
+	return result, nil
+}
+
+// cancelImmediate cancels an immediate order that did not settle in time and reads its final fills
+func (c *KucoinClient) cancelImmediate(result *OrderResult, cause error) error {
+	ctx, cancel := context.WithTimeout(c.context, orderCancelTimeout)
+	defer cancel()
+
+	if err := c.CancelOrder(ctx, result.OrderID); err != nil {
+		return err
+	}
+	if err := c.readOrder(result); err != nil {
+		return err
+	}
+	c.logger.WithField("order_id", result.OrderID).Warnf("Cancelled order %s after filling %s: %s", result.OrderID, result.DealSize.String(), cause)
+	return fmt.Errorf("%w: %s", ErrOrderCancelled, cause)
+}
+
+// checkDepth fails unless the order book within limit can absorb a market order for size
+func (c *KucoinClient) checkDepth(side string, size, limit decimal.Decimal) error {
+	book, err := c.GetOrderBook()
+	if err != nil {
+		return err
+	}
+	return CheckDepth(book, side, size, limit)
+}
+
+// CheckDepth fails with ErrOutsidePriceBand unless the asks at limit or below (for a buy), or the bids at
+// limit or above (for a sell), add up to size
+func CheckDepth(book *kucoin.PartOrderBookModel, side string, size, limit decimal.Decimal) error {
+	levels, beyond := book.Asks, decimal.Decimal.GreaterThan
+	if side == "sell" {
+		levels, beyond = book.Bids, decimal.Decimal.LessThan
+	}
+
+	depth := decimal.Zero
+	for _, level := range levels {
+		if len(level) < 2 {
+			continue
+		}
+		price, err := decimal.NewFromString(level[0])
+		if err != nil {
+			return fmt.Errorf("Failed to parse order book price %s: %s", level[0], err)
+		}
+		if beyond(price, limit) {
+			break
+		}
+		levelSize, err := decimal.NewFromString(level[1])
+		if err != nil {
+			return fmt.Errorf("Failed to parse order book size %s: %s", level[1], err)
+		}
+		if depth = depth.Add(levelSize); depth.GreaterThanOrEqual(size) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s available within %s, %sing %s", ErrOutsidePriceBand, depth.String(), limit.String(), side, size.String())
+}
+
+// CancelOpenOrders cancels every open order of the market and returns their IDs
+func (c *KucoinClient) CancelOpenOrders(ctx context.Context) ([]string, error) {
+	response, err := c.call("cancel_orders", func() (*kucoin.ApiResponse, error) {
+		return c.client.CancelOrders(ctx, map[string]string{"symbol": c.tradingPair})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to cancel orders: %s", err)
+	}
+
+	cancelled := &kucoin.CancelOrderResultModel{}
+	if err := response.ReadData(cancelled); err != nil {
+		return nil, fmt.Errorf("Failed to cancel orders: %s", err)
+	}
+	return cancelled.CancelledOrderIds, nil
+}
+
+// OpenOrders returns the open orders of the market, up to the 500 most recent
+func (c *KucoinClient) OpenOrders(ctx context.Context) ([]*OrderResult, error) {
+	response, err := c.call("orders", func() (*kucoin.ApiResponse, error) {
+		return c.client.Orders(ctx, map[string]string{"symbol": c.tradingPair, "status": "active"}, &kucoin.PaginationParam{CurrentPage: 1, PageSize: maxOpenOrders})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list orders: %s", err)
+	}
+
+	page := &kucoin.PaginationModel{}
+	if err := response.ReadData(page); err != nil {
+		return nil, fmt.Errorf("Failed to list orders: %s", err)
+	}
+	orders := kucoin.OrdersModel{}
+	if err := page.ReadItems(&orders); err != nil {
+		return nil, fmt.Errorf("Failed to list orders: %s", err)
+	}
+
+	results := make([]*OrderResult, 0, len(orders))
+	for _, order := range orders {
+		result := &OrderResult{OrderID: order.Id, ClientOid: order.ClientOid, Side: order.Side, Type: order.Type}
+		if err := readFills(result, order, map[*decimal.Decimal]string{
+			&result.Price: order.Price,
+			&result.Size:  order.Size,
+			&result.Funds: order.Funds,
+		}); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CancelOrder cancels an order of the account
+func (c *KucoinClient) CancelOrder(ctx context.Context, orderID string) error {
+	response, err := c.call("cancel_order", func() (*kucoin.ApiResponse, error) { return c.client.CancelOrder(ctx, orderID) })
+	if err != nil {
+		return fmt.Errorf("Failed to cancel order %s: %s", orderID, err)
+	}
+	if err := response.ReadData(&kucoin.CancelOrderResultModel{}); err != nil {
+		return fmt.Errorf("Failed to cancel order %s: %s", orderID, err)
+	}
+	return nil
+}
+
+// readOrder updates result with the current state of the order
+func (c *KucoinClient) readOrder(result *OrderResult) error {
+	response, err := c.call("order", func() (*kucoin.ApiResponse, error) { return c.client.Order(c.context, result.OrderID) })
+	if err != nil {
+		return fmt.Errorf("Failed to get order %s: %s", result.OrderID, err)
+	}
+
+	order := &kucoin.OrderModel{}
+	if err := response.ReadData(order); err != nil {
+		return fmt.Errorf("Failed to read order %s: %s", result.OrderID, err)
+	}
+
+	return readFills(result, order, map[*decimal.Decimal]string{})
+}
+
+// readFills updates result with the fills of order, and the other fields given
+func readFills(result *OrderResult, order *kucoin.OrderModel, fields map[*decimal.Decimal]string) error {
+	fields[&result.DealSize] = order.DealSize
+	fields[&result.DealFunds] = order.DealFunds
+	fields[&result.Fee] = order.Fee
+	for field, value := range fields {
+		if value == "" {
+			continue
+		}
+		d, err := decimal.NewFromString(value)
+		if err != nil {
+			return fmt.Errorf("Failed to parse order %s: %s", result.OrderID, err)
+		}
+		*field = d
+	}
+	result.FeeCurrency = order.FeeCurrency
+	result.IsActive = order.IsActive
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package kucoin
+
+import (
+	"errors"
+	"testing"
+
+	kucoin "github.com/Kucoin/kucoin-go-sdk"
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckDepth(t *testing.T) {
+	book := &kucoin.PartOrderBookModel{
+		Bids: [][]string{{"0.99", "10"}, {"0.98", "10"}, {"0.90", "100"}},
+		Asks: [][]string{{"1.01", "10"}, {"1.02", "10"}, {"1.10", "100"}},
+	}
+	tests := []struct {
+		side  string
+		size  string
+		limit string
+		ok    bool
+	}{
+		{"buy", "20", "1.02", true},
+		{"buy", "21", "1.02", false},
+		{"buy", "5", "1.00", false},
+		{"buy", "120", "1.10", true},
+		{"sell", "20", "0.98", true},
+		{"sell", "21", "0.98", false},
+		{"sell", "5", "1.00", false},
+		{"sell", "120", "0.90", true},
+	}
+	for _, tt := range tests {
+		err := CheckDepth(book, tt.side, decimal.RequireFromString(tt.size), decimal.RequireFromString(tt.limit))
+		if tt.ok && err != nil {
+			t.Errorf("CheckDepth(%s %s within %s) = %v, want nil", tt.side, tt.size, tt.limit, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrOutsidePriceBand) {
+			t.Errorf("CheckDepth(%s %s within %s) = %v, want %v", tt.side, tt.size, tt.limit, err, ErrOutsidePriceBand)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package kucoin
+
+import (
+	"fmt"
+	"time"
+
+	kucoin "github.com/Kucoin/kucoin-go-sdk"
+	"github.com/shopspring/decimal"
+)
+
+// SymbolRulesRefreshInterval is how often the symbol rules are reloaded
+const SymbolRulesRefreshInterval = time.Hour
+
+// ErrOrderTooSmall is returned when an order is below the symbol minimums
+var ErrOrderTooSmall = fmt.Errorf("order below the symbol minimum")
+
+// SymbolRules holds the trading rules of a symbol
+type SymbolRules struct {
+	Symbol         string
+	BaseIncrement  decimal.Decimal // Size must be a multiple of it
+	QuoteIncrement decimal.Decimal // Funds must be a multiple of it
+	PriceIncrement decimal.Decimal // Price must be a multiple of it
+	BaseMinSize    decimal.Decimal // Minimum order size
+	QuoteMinSize   decimal.Decimal // Minimum order funds for market orders
+	MinFunds       decimal.Decimal // Minimum order value (size * price)
+	EnableTrading  bool
+}
+
+// RoundSize rounds a size down to the base increment, so we never trade more than we have
+func (r *SymbolRules) RoundSize(size decimal.Decimal) decimal.Decimal {
+	return roundDown(size, r.BaseIncrement)
+}
+
+// RoundPrice rounds a limit price to the price increment, never in the taker's disfavor:
+// down when buying and up when selling
+func (r *SymbolRules) RoundPrice(side string, price decimal.Decimal) decimal.Decimal {
+	if r.PriceIncrement.IsZero() {
+		return price
+	}
+	if side == "sell" {
+		return price.Div(r.PriceIncrement).Ceil().Mul(r.PriceIncrement)
+	}
+	return roundDown(price, r.PriceIncrement)
+}
+
+// Validate checks an already rounded order against the symbol minimums
+func (r *SymbolRules) Validate(size, price decimal.Decimal) error {
+	if !r.EnableTrading {
+		return fmt.Errorf("Trading is disabled for %s", r.Symbol)
+	}
+	if size.LessThan(r.BaseMinSize) || size.IsZero() {
+		return fmt.Errorf("%w: size %s, minimum %s", ErrOrderTooSmall, size.String(), r.BaseMinSize.String())
+	}
+	if funds := size.Mul(price); funds.LessThan(r.MinFunds) {
+		return fmt.Errorf("%w: funds %s, minimum %s", ErrOrderTooSmall, funds.String(), r.MinFunds.String())
+	}
+	return nil
+}
+
+// LoadSymbolRules fetches the trading rules of the trading pair
+func (c *KucoinClient) LoadSymbolRules() error {
+	response, err := c.call("symbols", func() (*kucoin.ApiResponse, error) { return c.client.SymbolsV2(c.context, "") })
+	if err != nil {
+		return fmt.Errorf("Failed to get symbols: %s", err)
+	}
+
+	symbols := kucoin.SymbolsModelV2{}
+	if err := response.ReadData(&symbols); err != nil {
+		return fmt.Errorf("Failed to read symbols: %s", err)
+	}
+
+	for _, s := range symbols {
+		if s.Symbol != c.tradingPair {
+			continue
+		}
+
+		rules := &SymbolRules{Symbol: s.Symbol, EnableTrading: s.EnableTrading}
+		for field, value := range map[*decimal.Decimal]string{
+			&rules.BaseIncrement:  s.BaseIncrement,
+			&rules.QuoteIncrement: s.QuoteIncrement,
+			&rules.PriceIncrement: s.PriceIncrement,
+			&rules.BaseMinSize:    s.BaseMinSize,
+			&rules.QuoteMinSize:   s.QuoteMinSize,
+			&rules.MinFunds:       s.MinFunds,
+		} {
+			if value == "" {
+				continue
+			}
+			d, err := decimal.NewFromString(value)
+			if err != nil {
+				return fmt.Errorf("Failed to parse symbol rules of %s: %s", c.tradingPair, err)
+			}
+			*field = d
+		}
+
+		c.lock.Lock()
+		c.rules = rules
+		c.lock.Unlock()
+		return nil
+	}
+
+	return fmt.Errorf("Symbol %s not found", c.tradingPair)
+}
+
+// GetSymbolRules returns the trading rules of the trading pair
+func (c *KucoinClient) GetSymbolRules() *SymbolRules {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.rules
+}
+
+// refreshSymbolRules reloads the symbol rules until the client is closed
+func (c *KucoinClient) refreshSymbolRules() {
+	ticker := time.NewTicker(SymbolRulesRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+			if err := c.LoadSymbolRules(); err != nil {
+				c.logger.WithError(err).Error("Failed to refresh symbol rules")
+			}
+		}
+	}
+}
+
+// roundDown rounds value down to a multiple of increment
+func roundDown(value, increment decimal.Decimal) decimal.Decimal {
+	if increment.IsZero() {
+		return value
+	}
+	return value.Div(increment).Floor().Mul(increment)
+}
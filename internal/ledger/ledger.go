@@ -0,0 +1,632 @@
+package ledger
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/risk"
+
+	"github.com/shopspring/decimal"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the ledger. Records are keyed by trade ID, which starts with the creation time so keys
+// sort chronologically. Transactions and orders are keyed by trade ID, a slash and their hash or order ID.
+// Marks and snapshots are keyed by their zero padded time in nanoseconds, a slash and their market. The risk
+// and PnL states are kept in the meta bucket, one per market.
+var (
+	bucketMeta          = []byte("meta")
+	bucketOpportunities = []byte("opportunities")
+	bucketDecisions     = []byte("decisions")
+	bucketIntents       = []byte("intents")
+	bucketTxs           = []byte("txs")
+	bucketOrders        = []byte("orders")
+	bucketPnL           = []byte("pnl")
+	bucketMarks         = []byte("marks")
+	bucketSnapshots     = []byte("snapshots")
+
+	keySchemaVersion  = []byte("schema_version")
+	keyTokenAddresses = []byte("token_addresses")
+	keyRiskState      = []byte("risk_state")
+	keyPnLState       = []byte("pnl_state")
+)
+
+// openTimeout bounds how long we wait for the database file lock
+const openTimeout = 5 * time.Second
+
+// ErrNotFound is returned when a trade is not in the ledger
+var ErrNotFound = fmt.Errorf("trade not found")
+
+// ErrLocked is returned when the ledger is held open by another process, e.g. the running bot
+var ErrLocked = fmt.Errorf("ledger locked by another process")
+
+// Decisions taken on an opportunity
+const (
+	DecisionExecute = "execute"
+	DecisionReject  = "reject"
+)
+
+// Transaction statuses
+const (
+	TxStatusSuccess = "success"
+	TxStatusFailed  = "failed"
+	TxStatusPending = "pending"
+	TxStatusPaper   = "paper"
+)
+
+// Opportunity is a price discrepancy handed to the executor
+type Opportunity struct {
+	TradeID      string          `json:"trade_id"`
+	Time         time.Time       `json:"time"`
+	Market       string          `json:"market"`
+	UniswapPrice decimal.Decimal `json:"uniswap_price"`
+	KucoinPrice  decimal.Decimal `json:"kucoin_price"`
+}
+
+// Decision records whether an opportunity was executed and why
+type Decision struct {
+	TradeID string    `json:"trade_id"`
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// Intent is the trade the executor set out to do
+type Intent struct {
+	TradeID      string          `json:"trade_id"`
+	Time         time.Time       `json:"time"`
+	Market       string          `json:"market"`
+	BuyOnUniswap bool            `json:"buy_on_uniswap"`
+	LegOrder     string          `json:"leg_order"`
+	SwapToken    string          `json:"swap_token"`
+	SwapAmount   decimal.Decimal `json:"swap_amount"`
+	OrderSide    string          `json:"order_side"`
+	OrderSize    decimal.Decimal `json:"order_size"`
+	Notional     decimal.Decimal `json:"notional"`
+	Paper        bool            `json:"paper"`
+}
+
+// Tx is a Uniswap swap, sent on-chain or simulated in paper mode
+type Tx struct {
+	TradeID      string          `json:"trade_id"`
+	Time         time.Time       `json:"time"`
+	Hash         string          `json:"hash,omitempty"`
+	Market       string          `json:"market,omitempty"`
+	From         string          `json:"from,omitempty"` // Account the swap was sent from
+	TokenIn      string          `json:"token_in"`
+	TokenInAddr  string          `json:"token_in_address,omitempty"`
+	AmountIn     decimal.Decimal `json:"amount_in"`
+	TokenOut     string          `json:"token_out"`
+	TokenOutAddr string          `json:"token_out_address,omitempty"`
+	AmountOut    decimal.Decimal `json:"amount_out"`
+	GasUsed      uint64          `json:"gas_used"`
+	GasPrice     decimal.Decimal `json:"gas_price"` // In wei
+	GasCost      decimal.Decimal `json:"gas_cost"`  // In ETH
+	BlockNumber  uint64          `json:"block_number,omitempty"`
+	Status       string          `json:"status"`
+	Error        string          `json:"error,omitempty"`
+	Settled      time.Time       `json:"settled,omitempty"` // When the mined swap was recorded, zero until then
+}
+
+// FillTime returns when the swap moved the balances as far as the ledger knows: when it was recorded mined,
+// or when it was sent
+func (t *Tx) FillTime() time.Time {
+	if !t.Settled.IsZero() {
+		return t.Settled
+	}
+	return t.Time
+}
+
+// Order is a KuCoin order, placed or simulated in paper mode
+type Order struct {
+	TradeID     string          `json:"trade_id"`
+	Time        time.Time       `json:"time"`
+	OrderID     string          `json:"order_id"`
+	ClientOid   string          `json:"client_oid,omitempty"`
+	Market      string          `json:"market,omitempty"`
+	Side        string          `json:"side"`
+	Type        string          `json:"type"`
+	Price       decimal.Decimal `json:"price"`
+	Size        decimal.Decimal `json:"size"`
+	Funds       decimal.Decimal `json:"funds"`
+	DealSize    decimal.Decimal `json:"deal_size"`
+	DealFunds   decimal.Decimal `json:"deal_funds"`
+	Fee         decimal.Decimal `json:"fee"`
+	FeeCurrency string          `json:"fee_currency"`
+	IsActive    bool            `json:"is_active"`
+}
+
+// Snapshot holds the balances of both venues at a point in time
+type Snapshot struct {
+	Time     time.Time    `json:"time"`
+	Market   string       `json:"market,omitempty"`
+	Balances pnl.Balances `json:"balances"`
+	Paper    bool         `json:"paper,omitempty"` // Taken from the simulated balances of paper trading
+}
+
+// Trade groups every record sharing a trade ID
+type Trade struct {
+	ID          string
+	Opportunity *Opportunity
+	Decision    *Decision
+	Intent      *Intent
+	Txs         []*Tx
+	Orders      []*Order
+	PnL         *pnl.Attribution
+}
+
+// Ledger is the persistent trade ledger
+type Ledger struct {
+	db *bolt.DB
+}
+
+// Open opens the ledger at path, creating it if needed, and migrates it to the latest schema
+func Open(path string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("Failed to create ledger directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open ledger %s: %w", path, err)
+	}
+
+	l := &Ledger{db: db}
+	if err := l.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// OpenReadOnly opens an existing ledger without migrating it, e.g. for exports. It fails with ErrLocked
+// while the bot holds the ledger open, a backup of the running ledger can be opened instead.
+func OpenReadOnly(path string) (*Ledger, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("Failed to open ledger %s: %w", path, err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("Failed to open ledger %s: %w", path, ErrLocked)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open ledger %s: %w", path, err)
+	}
+
+	l := &Ledger{db: db}
+	if err := l.checkVersion(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Backup writes a consistent copy of the ledger database to w. It is taken in a read transaction, so the
+// ledger can be backed up while in use.
+func (l *Ledger) Backup(w io.Writer) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// NewTradeID returns a new trade ID. IDs start with the zero padded creation time in nanoseconds
+// so they sort chronologically.
+func NewTradeID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%020d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+// RecordOpportunity stores an opportunity
+func (l *Ledger) RecordOpportunity(o *Opportunity) error {
+	return l.put(bucketOpportunities, o.TradeID, o)
+}
+
+// RecordDecision stores a decision
+func (l *Ledger) RecordDecision(d *Decision) error {
+	return l.put(bucketDecisions, d.TradeID, d)
+}
+
+// RecordIntent stores an intent
+func (l *Ledger) RecordIntent(i *Intent) error {
+	return l.put(bucketIntents, i.TradeID, i)
+}
+
+// RecordTx stores a transaction, replacing any previous record of the same hash
+func (l *Ledger) RecordTx(t *Tx) error {
+	key := t.Hash
+	if key == "" {
+		key = fmt.Sprintf("%020d", t.Time.UnixNano())
+	}
+	return l.put(bucketTxs, t.TradeID+"/"+key, t)
+}
+
+// ListPendingTxs returns the transactions sent and not known to be mined yet
+func (l *Ledger) ListPendingTxs() ([]*Tx, error) {
+	var txs []*Tx
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTxs).ForEach(func(k, v []byte) error {
+			t := &Tx{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return fmt.Errorf("Failed to decode tx %s: %w", k, err)
+			}
+			if t.Status == TxStatusPending {
+				txs = append(txs, t)
+			}
+			return nil
+		})
+	})
+	return txs, err
+}
+
+// ListFills returns the transactions and orders filled in [from, to), by fill time. A swap mined after its
+// trade is listed when it was recorded mined, not when its trade was created. A zero bound is open.
+func (l *Ledger) ListFills(from, to time.Time) ([]*Tx, []*Order, error) {
+	in := func(t time.Time) bool {
+		return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+	}
+
+	var txs []*Tx
+	var orders []*Order
+	err := l.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketTxs).ForEach(func(k, v []byte) error {
+			t := &Tx{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return fmt.Errorf("Failed to decode tx %s: %w", k, err)
+			}
+			if in(t.FillTime()) {
+				txs = append(txs, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketOrders).ForEach(func(k, v []byte) error {
+			o := &Order{}
+			if err := json.Unmarshal(v, o); err != nil {
+				return fmt.Errorf("Failed to decode order %s: %w", k, err)
+			}
+			if in(o.Time) {
+				orders = append(orders, o)
+			}
+			return nil
+		})
+	})
+	return txs, orders, err
+}
+
+// RecordOrder stores an order, replacing any previous record of the same order ID
+func (l *Ledger) RecordOrder(o *Order) error {
+	return l.put(bucketOrders, o.TradeID+"/"+o.OrderID, o)
+}
+
+// RecordPnL stores the realized PnL of a trade
+func (l *Ledger) RecordPnL(a *pnl.Attribution) error {
+	return l.put(bucketPnL, a.TradeID, a)
+}
+
+// RecordMark stores an inventory mark
+func (l *Ledger) RecordMark(m *pnl.Mark) error {
+	return l.put(bucketMarks, timeKey(m.Time)+"/"+m.Market, m)
+}
+
+// ListMarks returns the inventory marks taken in [from, to), oldest first. A zero bound is open.
+func (l *Ledger) ListMarks(from, to time.Time) ([]*pnl.Mark, error) {
+	var marks []*pnl.Mark
+	err := l.scan(bucketMarks, from, to, func(k, v []byte) error {
+		m := &pnl.Mark{}
+		if err := json.Unmarshal(v, m); err != nil {
+			return fmt.Errorf("Failed to decode mark %s: %w", k, err)
+		}
+		marks = append(marks, m)
+		return nil
+	})
+	return marks, err
+}
+
+// RecordSnapshot stores a balance snapshot
+func (l *Ledger) RecordSnapshot(s *Snapshot) error {
+	return l.put(bucketSnapshots, timeKey(s.Time)+"/"+s.Market, s)
+}
+
+// ListSnapshots returns the balance snapshots taken in [from, to), oldest first. A zero bound is open.
+func (l *Ledger) ListSnapshots(from, to time.Time) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	err := l.scan(bucketSnapshots, from, to, func(k, v []byte) error {
+		s := &Snapshot{}
+		if err := json.Unmarshal(v, s); err != nil {
+			return fmt.Errorf("Failed to decode snapshot %s: %w", k, err)
+		}
+		snapshots = append(snapshots, s)
+		return nil
+	})
+	return snapshots, err
+}
+
+// LastSnapshot returns the latest balance snapshot of market, or nil if there is none
+func (l *Ledger) LastSnapshot(market string) (*Snapshot, error) {
+	var snapshot *Snapshot
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketSnapshots).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			s := &Snapshot{}
+			if err := json.Unmarshal(v, s); err != nil {
+				return fmt.Errorf("Failed to decode snapshot %s: %w", k, err)
+			}
+			if s.Market == market {
+				snapshot = s
+				return nil
+			}
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+// RecordTokenAddresses adds the on-chain addresses of token symbols, e.g. of the pool tokens
+func (l *Ledger) RecordTokenAddresses(addresses map[string]string) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		known := make(map[string]string)
+		if v := meta.Get(keyTokenAddresses); v != nil {
+			if err := json.Unmarshal(v, &known); err != nil {
+				return fmt.Errorf("Failed to decode token addresses: %w", err)
+			}
+		}
+		for symbol, address := range addresses {
+			known[symbol] = address
+		}
+		data, err := json.Marshal(known)
+		if err != nil {
+			return err
+		}
+		return meta.Put(keyTokenAddresses, data)
+	})
+}
+
+// TokenAddresses maps token symbols to their on-chain addresses, as recorded for the pool tokens and seen
+// in swaps
+func (l *Ledger) TokenAddresses() (map[string]string, error) {
+	addresses := make(map[string]string)
+	err := l.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketMeta).Get(keyTokenAddresses); v != nil {
+			if err := json.Unmarshal(v, &addresses); err != nil {
+				return fmt.Errorf("Failed to decode token addresses: %w", err)
+			}
+		}
+		return tx.Bucket(bucketTxs).ForEach(func(k, v []byte) error {
+			t := &Tx{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return fmt.Errorf("Failed to decode transaction %s: %w", k, err)
+			}
+			if t.TokenInAddr != "" {
+				addresses[t.TokenIn] = t.TokenInAddr
+			}
+			if t.TokenOutAddr != "" {
+				addresses[t.TokenOut] = t.TokenOutAddr
+			}
+			return nil
+		})
+	})
+	return addresses, err
+}
+
+// RecordRiskState stores the risk state of market, replacing the previous one
+func (l *Ledger) RecordRiskState(market string, state *risk.State) error {
+	return l.put(bucketMeta, string(keyRiskState)+"/"+market, state)
+}
+
+// RiskState returns the last risk state of market stored, or nil if there is none
+func (l *Ledger) RiskState(market string) (*risk.State, error) {
+	var state *risk.State
+	err := l.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketMeta).Get([]byte(string(keyRiskState) + "/" + market))
+		if v == nil {
+			return nil
+		}
+		state = &risk.State{}
+		if err := json.Unmarshal(v, state); err != nil {
+			return fmt.Errorf("Failed to decode risk state: %w", err)
+		}
+		return nil
+	})
+	return state, err
+}
+
+// RecordPnLState stores the state of the PnL engine of market, replacing the previous one
+func (l *Ledger) RecordPnLState(market string, state *pnl.State) error {
+	return l.put(bucketMeta, string(keyPnLState)+"/"+market, state)
+}
+
+// PnLState returns the last state of the PnL engine of market stored, or nil if there is none
+func (l *Ledger) PnLState(market string) (*pnl.State, error) {
+	var state *pnl.State
+	err := l.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketMeta).Get([]byte(string(keyPnLState) + "/" + market))
+		if v == nil {
+			return nil
+		}
+		state = &pnl.State{}
+		if err := json.Unmarshal(v, state); err != nil {
+			return fmt.Errorf("Failed to decode PnL state: %w", err)
+		}
+		return nil
+	})
+	return state, err
+}
+
+// GetTrade returns every record of a trade
+func (l *Ledger) GetTrade(id string) (*Trade, error) {
+	var trade *Trade
+	err := l.db.View(func(tx *bolt.Tx) error {
+		t, err := readTrade(tx, id)
+		trade = t
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if trade.Opportunity == nil && trade.Intent == nil && len(trade.Txs) == 0 && len(trade.Orders) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return trade, nil
+}
+
+// ListTrades returns the trades created in [from, to), oldest first. A zero bound is open.
+func (l *Ledger) ListTrades(from, to time.Time) ([]*Trade, error) {
+	ids, err := l.ListTradeIDs(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	trades := make([]*Trade, 0, len(ids))
+	err = l.db.View(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			trade, err := readTrade(tx, id)
+			if err != nil {
+				return err
+			}
+			trades = append(trades, trade)
+		}
+		return nil
+	})
+	return trades, err
+}
+
+// ListTradeIDs returns the IDs of the trades created in [from, to), oldest first. A zero bound is open.
+func (l *Ledger) ListTradeIDs(from, to time.Time) ([]string, error) {
+	seen := make(map[string]bool)
+	var ids []string
+
+	err := l.db.View(func(tx *bolt.Tx) error {
+		// Rejected opportunities have no intent and trades without opportunity (e.g. rebalances) exist
+		for _, bucket := range [][]byte{bucketOpportunities, bucketIntents, bucketTxs, bucketOrders} {
+			c := tx.Bucket(bucket).Cursor()
+			k, _ := c.First()
+			if !from.IsZero() {
+				k, _ = c.Seek([]byte(timeKey(from)))
+			}
+			for ; k != nil; k, _ = c.Next() {
+				id := tradeIDOf(string(k))
+				if !to.IsZero() && id >= timeKey(to) {
+					break
+				}
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Close closes the ledger
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+// put stores value as JSON under key
+func (l *Ledger) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Failed to encode ledger record: %w", err)
+	}
+	return l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// scan calls fn for every record of a time keyed bucket in [from, to). A zero bound is open.
+func (l *Ledger) scan(bucket []byte, from, to time.Time, fn func(k, v []byte) error) error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		k, v := c.First()
+		if !from.IsZero() {
+			k, v = c.Seek([]byte(timeKey(from)))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !to.IsZero() && string(k) >= timeKey(to) {
+				break
+			}
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readTrade reads every record of a trade within a transaction
+func readTrade(tx *bolt.Tx, id string) (*Trade, error) {
+	trade := &Trade{ID: id}
+
+	for bucket, value := range map[string]interface{}{
+		string(bucketOpportunities): &trade.Opportunity,
+		string(bucketDecisions):     &trade.Decision,
+		string(bucketIntents):       &trade.Intent,
+		string(bucketPnL):           &trade.PnL,
+	} {
+		data := tx.Bucket([]byte(bucket)).Get([]byte(id))
+		if data == nil {
+			continue
+		}
+		if err := json.Unmarshal(data, value); err != nil {
+			return nil, fmt.Errorf("Failed to decode %s of trade %s: %w", bucket, id, err)
+		}
+	}
+
+	prefix := []byte(id + "/")
+	c := tx.Bucket(bucketTxs).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		t := &Tx{}
+		if err := json.Unmarshal(v, t); err != nil {
+			return nil, fmt.Errorf("Failed to decode tx of trade %s: %w", id, err)
+		}
+		trade.Txs = append(trade.Txs, t)
+	}
+
+	c = tx.Bucket(bucketOrders).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		o := &Order{}
+		if err := json.Unmarshal(v, o); err != nil {
+			return nil, fmt.Errorf("Failed to decode order of trade %s: %w", id, err)
+		}
+		trade.Orders = append(trade.Orders, o)
+	}
+
+	return trade, nil
+}
+
+// timeKey returns the trade ID prefix of t
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// tradeIDOf returns the trade ID part of a record key
+func tradeIDOf(key string) string {
+	id, _, _ := strings.Cut(key, "/")
+	return id
+}
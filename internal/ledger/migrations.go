@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// migrations upgrade the ledger schema, migrations[i] moves it from version i to i+1. Never edit a
+// released migration, append a new one instead.
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: initial buckets
+	func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketOpportunities, bucketDecisions, bucketIntents, bucketTxs, bucketOrders} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: realized PnL per trade and inventory marks
+	func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketPnL, bucketMarks} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 3: balance snapshots
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSnapshots)
+		return err
+	},
+}
+
+// SchemaVersion is the latest ledger schema version
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// checkVersion fails unless the ledger is at the latest schema version
+func (l *Ledger) checkVersion() error {
+	return l.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta == nil {
+			return fmt.Errorf("Ledger is not initialized")
+		}
+		if v := string(meta.Get(keySchemaVersion)); v != strconv.Itoa(len(migrations)) {
+			return fmt.Errorf("Ledger schema version %s does not match supported version %d, start the bot once to migrate it", v, len(migrations))
+		}
+		return nil
+	})
+}
+
+// migrate applies the pending migrations in a single transaction
+func (l *Ledger) migrate() error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if v := meta.Get(keySchemaVersion); v != nil {
+			version, err = strconv.Atoi(string(v))
+			if err != nil {
+				return fmt.Errorf("Invalid ledger schema version %q", v)
+			}
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("Ledger schema version %d is newer than supported version %d", version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("Ledger migration to version %d failed: %w", version+1, err)
+			}
+		}
+
+		return meta.Put(keySchemaVersion, []byte(strconv.Itoa(version)))
+	})
+}
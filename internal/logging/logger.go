@@ -1,49 +1,169 @@
 package logging
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures the logger
+type Options struct {
+	Level           string            // Default log level
+	Format          string            // FormatText or FormatJSON
+	File            string            // Log file, written next to stdout. Empty to log to stdout only.
+	MaxSizeMB       int               // Size after which the log file is rotated
+	MaxAgeDays      int               // Days rotated files are kept, 0 to keep them all
+	MaxBackups      int               // Rotated files kept, 0 to keep them all
+	RotateInterval  time.Duration     // Interval after which the log file is rotated, 0 to disable
+	ComponentLevels map[string]string // Log level per component (logger prefix), e.g. executor=info
+}
+
 type Logger struct {
-	logger *logrus.Logger
+	logger     *logrus.Logger
+	components map[string]*logrus.Logger
+	file       *lumberjack.Logger
+	stopChan   chan struct{}
+	closeOnce  sync.Once
 }
 
 // MakeLogger creates a new logger with the specified log level
 func MakeLogger(logLevel string) *Logger {
-	logger := logrus.New()
+	logger, err := NewLogger(Options{Level: logLevel, Format: FormatText})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return logger
+}
 
-	// Set the log level
-	level, err := logrus.ParseLevel(logLevel)
+// NewLogger creates a new logger from options
+func NewLogger(opts Options) (*Logger, error) {
+	level, err := logrus.ParseLevel(opts.Level)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
-	logger.SetLevel(level)
 
-	// Set the log format
-	logger.SetFormatter(&prefixed.TextFormatter{
-		ForceColors:      true,
-		DisableTimestamp: false,
-		FullTimestamp:    true,
+	l := &Logger{
+		logger:     logrus.New(),
+		components: make(map[string]*logrus.Logger),
+		stopChan:   make(chan struct{}),
+	}
+
+	// Set the log format, colors are only used when logging to the terminal alone
+	switch opts.Format {
+	case FormatJSON:
+		l.logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	case FormatText, "":
+		l.logger.SetFormatter(&prefixed.TextFormatter{
+			ForceColors:      opts.File == "",
+			DisableColors:    opts.File != "",
+			DisableTimestamp: false,
+			FullTimestamp:    true,
+		})
+	default:
+		return nil, fmt.Errorf("Unsupported log format %q", opts.Format)
+	}
+
+	// Set output to stdout, and to a rotated file if configured
+	var out io.Writer = os.Stdout
+	if opts.File != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.File), 0o700); err != nil {
+			return nil, fmt.Errorf("Failed to create log directory: %w", err)
+		}
+		l.file = &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSizeMB,
+			MaxAge:     opts.MaxAgeDays,
+			MaxBackups: opts.MaxBackups,
+		}
+		out = io.MultiWriter(os.Stdout, l.file)
+		if opts.RotateInterval > 0 {
+			go l.rotate(opts.RotateInterval)
+		}
+	}
+	// Component loggers share the output, writes are serialized across all of them
+	l.logger.SetOutput(&syncWriter{out: out})
+	l.logger.SetLevel(level)
+
+	for component, componentLevel := range opts.ComponentLevels {
+		level, err := logrus.ParseLevel(componentLevel)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid log level of %s: %w", component, err)
+		}
+		l.components[component] = &logrus.Logger{
+			Out:          l.logger.Out,
+			Formatter:    l.logger.Formatter,
+			Hooks:        l.logger.Hooks,
+			Level:        level,
+			ExitFunc:     os.Exit,
+			ReportCaller: false,
+		}
+	}
+
+	return l, nil
+}
+
+// Component returns the entry of a component, at its own level if it has one
+func (l *Logger) Component(name string) *logrus.Entry {
+	if logger, ok := l.components[name]; ok {
+		return logger.WithField("prefix", name)
+	}
+	return l.logger.WithField("prefix", name)
+}
+
+// Close stops the rotation and closes the log file. Closing again does nothing.
+func (l *Logger) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.stopChan)
+		if l.file != nil {
+			err = l.file.Close()
+		}
 	})
+	return err
+}
 
-	// Set output to stdout or a file
-	logger.SetOutput(os.Stdout)
-	// Uncomment the next line to log to a file instead
-	// file, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	// if err == nil {
-	//     Log.SetOutput(file)
-	// } else {
-	//     Log.Info("Failed to log to file, using default stderr")
-	// }
-
-	return &Logger{
-		logger: logger,
+// rotate rotates the log file every interval
+func (l *Logger) rotate(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopChan:
+			return
+		case <-ticker.C:
+			if err := l.file.Rotate(); err != nil {
+				l.logger.WithError(err).Error("Failed to rotate the log file")
+			}
+		}
 	}
 }
 
+// syncWriter serializes writes to out
+type syncWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.out.Write(p)
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(args ...interface{}) {
 	l.logger.Debug(args...)
@@ -94,8 +214,11 @@ func (l *Logger) Fatalf(format string, args ...interface{}) {
 	l.logger.Fatalf(format, args...)
 }
 
-// WithField adds a field to the logger
+// WithField adds a field to the logger. A prefix field selects the logger of that component.
 func (l *Logger) WithField(key string, value interface{}) *logrus.Entry {
+	if name, ok := value.(string); ok && key == "prefix" {
+		return l.Component(name)
+	}
 	return l.logger.WithField(key, value)
 }
 
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"net/http"
+	"rattrap/arbitrage-bot/internal/risk"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the bot
+const namespace = "arbitrage"
+
+// Leg results
+const (
+	LegAttempted = "attempted"
+	LegSucceeded = "succeeded"
+	LegFailed    = "failed"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	price = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "price",
+		Help:      "Last price of token0 in token1, per market and venue.",
+	}, []string{"market", "venue"})
+
+	spread = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spread_bps",
+		Help:      "KuCoin price minus Uniswap price, in basis points of the Uniswap price, per market.",
+	}, []string{"market"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "Latency of Ethereum JSON-RPC requests, per method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Failed Ethereum JSON-RPC requests, per method.",
+	}, []string{"method"})
+
+	kucoinDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kucoin_request_duration_seconds",
+		Help:      "Latency of KuCoin API requests, per endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	kucoinErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kucoin_errors_total",
+		Help:      "Failed KuCoin API requests, per endpoint.",
+	}, []string{"endpoint"})
+
+	tickDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tick_duration_seconds",
+		Help:      "Time taken to load the pool snapshot and both prices on each tick.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+
+	trades = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trades_total",
+		Help:      "Arbitrage opportunities handed to the executor, per market and decision (execute, reject).",
+	}, []string{"market", "decision"})
+
+	legs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "legs_total",
+		Help:      "Trade legs attempted, succeeded and failed, per market and leg (swap, order).",
+	}, []string{"market", "leg", "result"})
+
+	gasSpent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gas_spent_eth_total",
+		Help:      "Gas spent on swaps, in ETH, per market.",
+	}, []string{"market"})
+
+	balance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "balance",
+		Help:      "Last known balance, per market, venue, on-chain account and token.",
+	}, []string{"market", "venue", "account", "token"})
+
+	nonce = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "account_nonce",
+		Help:      "Next nonce of the transactions sent, per on-chain account.",
+	}, []string{"account"})
+
+	serviceUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_up",
+		Help:      "Whether a supervised service is running, per service.",
+	}, []string{"service"})
+
+	serviceRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_restarts_total",
+		Help:      "Restarts of a supervised service after an error or a panic, per service.",
+	}, []string{"service"})
+
+	quotes = &quoteCollector{
+		desc:  prometheus.NewDesc(namespace+"_quote_age_seconds", "Time since the last price quote, per market and venue.", []string{"market", "venue"}, nil),
+		times: make(map[quoteKey]time.Time),
+	}
+
+	risks = &riskCollector{status: make(map[string]func() risk.Status)}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		price, spread, rpcDuration, rpcErrors, kucoinDuration, kucoinErrors, tickDuration,
+		trades, legs, gasSpent, balance, nonce, serviceUp, serviceRestarts, quotes, risks,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// SetPrice records the price of a market on a venue
+func SetPrice(market, venue string, p float64) {
+	price.WithLabelValues(market, venue).Set(p)
+}
+
+// SetSpread records the spread between the prices of a market on both venues
+func SetSpread(market string, uniswapPrice, kucoinPrice float64) {
+	if uniswapPrice != 0 {
+		spread.WithLabelValues(market).Set((kucoinPrice - uniswapPrice) / uniswapPrice * 10000)
+	}
+}
+
+// SetQuoteTime records when the price of a market on a venue was last quoted
+func SetQuoteTime(market, venue string, t time.Time) {
+	quotes.lock.Lock()
+	defer quotes.lock.Unlock()
+	quotes.times[quoteKey{market: market, venue: venue}] = t
+}
+
+// ObserveRPC records the latency and outcome of an Ethereum JSON-RPC request
+func ObserveRPC(method string, start time.Time, failed bool) {
+	rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if failed {
+		rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveKucoin records the latency and outcome of a KuCoin API request
+func ObserveKucoin(endpoint string, start time.Time, failed bool) {
+	kucoinDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if failed {
+		kucoinErrors.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// ObserveTick records the time taken to load a tick
+func ObserveTick(start time.Time) {
+	tickDuration.Observe(time.Since(start).Seconds())
+}
+
+// AddTrade counts an opportunity of a market and the decision taken on it
+func AddTrade(market, decision string) {
+	trades.WithLabelValues(market, decision).Inc()
+}
+
+// AddLeg counts a leg result of a market
+func AddLeg(market, leg, result string) {
+	legs.WithLabelValues(market, leg, result).Inc()
+}
+
+// AddGas adds gas spent by a market, in ETH
+func AddGas(market string, eth float64) {
+	if eth > 0 {
+		gasSpent.WithLabelValues(market).Add(eth)
+	}
+}
+
+// ResetBalances forgets the recorded balances of a market, e.g. of tokens no longer held
+func ResetBalances(market string) {
+	balance.DeletePartialMatch(prometheus.Labels{"market": market})
+}
+
+// SetBalance records the balance of a token of a market on a venue. account is the on-chain account holding
+// it, empty for exchange balances.
+func SetBalance(market, venue, account, token string, amount float64) {
+	balance.WithLabelValues(market, venue, account, token).Set(amount)
+}
+
+// SetNonce records the next nonce of an on-chain account
+func SetNonce(account string, next uint64) {
+	nonce.WithLabelValues(account).Set(float64(next))
+}
+
+// SetServiceUp records whether a supervised service is running
+func SetServiceUp(service string, up bool) {
+	value := 0.0
+	if up {
+		value = 1
+	}
+	serviceUp.WithLabelValues(service).Set(value)
+}
+
+// AddServiceRestart counts a restart of a supervised service
+func AddServiceRestart(service string) {
+	serviceRestarts.WithLabelValues(service).Inc()
+}
+
+// RegisterRisk exposes the risk state of a market, read from status on every scrape
+func RegisterRisk(market string, status func() risk.Status) {
+	risks.lock.Lock()
+	defer risks.lock.Unlock()
+	risks.status[market] = status
+}
+
+// RemoveMarket forgets the metrics of a market that is no longer traded
+func RemoveMarket(market string) {
+	labels := prometheus.Labels{"market": market}
+	for _, vec := range []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{price, spread, trades, legs, gasSpent, balance} {
+		vec.DeletePartialMatch(labels)
+	}
+
+	quotes.lock.Lock()
+	for key := range quotes.times {
+		if key.market == market {
+			delete(quotes.times, key)
+		}
+	}
+	quotes.lock.Unlock()
+
+	risks.lock.Lock()
+	delete(risks.status, market)
+	risks.lock.Unlock()
+}
+
+// RemoveService forgets the metrics of a supervised service that was removed
+func RemoveService(service string) {
+	serviceUp.DeleteLabelValues(service)
+	serviceRestarts.DeleteLabelValues(service)
+}
+
+// quoteKey identifies the quotes of a market on a venue
+type quoteKey struct {
+	market string
+	venue  string
+}
+
+// quoteCollector reports the age of the last quote of each market and venue at scrape time
+type quoteCollector struct {
+	desc  *prometheus.Desc
+	lock  sync.Mutex
+	times map[quoteKey]time.Time
+}
+
+func (c *quoteCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *quoteCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, t := range c.times {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(t).Seconds(), key.market, key.venue)
+	}
+}
+
+// riskCollector reports the risk state of every market at scrape time
+type riskCollector struct {
+	lock   sync.Mutex
+	status map[string]func() risk.Status // By market
+}
+
+var (
+	riskHalted              = prometheus.NewDesc(namespace+"_risk_halted", "Whether the kill switch is engaged, per market.", []string{"market"}, nil)
+	riskTradesLastHour      = prometheus.NewDesc(namespace+"_risk_trades_last_hour", "Trades over the last hour, per market.", []string{"market"}, nil)
+	riskDailyRealizedPnL    = prometheus.NewDesc(namespace+"_risk_daily_realized_pnl", "Realized PnL of the UTC day, in the quote token, per market.", []string{"market"}, nil)
+	riskDailyGas            = prometheus.NewDesc(namespace+"_risk_daily_gas_eth", "Gas spent over the UTC day, in ETH, per market.", []string{"market"}, nil)
+	riskInventory           = prometheus.NewDesc(namespace+"_risk_inventory", "Open inventory, per market and token.", []string{"market", "token"}, nil)
+	riskConsecutiveFailures = prometheus.NewDesc(namespace+"_risk_consecutive_failures", "Failed legs in a row, per market.", []string{"market"}, nil)
+)
+
+func (c *riskCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{riskHalted, riskTradesLastHour, riskDailyRealizedPnL, riskDailyGas, riskInventory, riskConsecutiveFailures} {
+		ch <- d
+	}
+}
+
+func (c *riskCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for market, status := range c.status {
+		s := status()
+		halted := 0.0
+		if s.Halted {
+			halted = 1
+		}
+		ch <- prometheus.MustNewConstMetric(riskHalted, prometheus.GaugeValue, halted, market)
+		ch <- prometheus.MustNewConstMetric(riskTradesLastHour, prometheus.GaugeValue, float64(s.TradesLastHour), market)
+		ch <- prometheus.MustNewConstMetric(riskDailyRealizedPnL, prometheus.GaugeValue, s.DailyRealizedPnL.InexactFloat64(), market)
+		ch <- prometheus.MustNewConstMetric(riskDailyGas, prometheus.GaugeValue, s.DailyGas.InexactFloat64(), market)
+		for token, amount := range s.Inventory {
+			ch <- prometheus.MustNewConstMetric(riskInventory, prometheus.GaugeValue, amount.InexactFloat64(), market, token)
+		}
+		ch <- prometheus.MustNewConstMetric(riskConsecutiveFailures, prometheus.GaugeValue, float64(s.ConsecutiveFailures), market)
+	}
+}
@@ -0,0 +1,357 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/supervisor"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Level is the severity of a notification
+type Level int
+
+// Notification levels
+const (
+	LevelInfo Level = iota
+	LevelWarning
+	LevelCritical
+)
+
+// SendTimeout bounds the delivery of a notification
+const SendTimeout = 10 * time.Second
+
+const (
+	queueSize        = 100 // Notifications waiting for delivery per notifier
+	maxDigestEntries = 50  // Notifications kept in a digest, the oldest are dropped
+)
+
+var levelNames = map[Level]string{
+	LevelInfo:     "info",
+	LevelWarning:  "warning",
+	LevelCritical: "critical",
+}
+
+// String returns the name of the level
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses a level name
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown notification level %q", s)
+}
+
+// Message is a notification
+type Message struct {
+	Level Level
+	Key   string // Identifies repeats of the same notification
+	Text  string
+	Time  time.Time
+}
+
+// String formats the message with its level, info messages are not prefixed
+func (m Message) String() string {
+	if m.Level == LevelInfo {
+		return m.Text
+	}
+	return fmt.Sprintf("[%s] %s", strings.ToUpper(m.Level.String()), m.Text)
+}
+
+// Notifier delivers notifications to an external channel
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Options holds the routing rules of the Dispatcher
+type Options struct {
+	ImmediateLevel Level         // Notifications from this level are sent immediately, lower ones go to the digest
+	DigestInterval time.Duration // How often the digest of the other notifications is sent
+	DedupWindow    time.Duration // Repeats of an immediate notification within the window are suppressed
+	RateLimit      int           // Immediate notifications per minute and notifier, critical ones excepted, 0 for no limit
+}
+
+// route is a notifier and its delivery state
+type route struct {
+	notifier Notifier
+	minLevel Level
+	queue    chan Message
+	sent     []time.Time // Immediate notifications sent over the last minute
+	digest   []Message
+	dropped  int // Digest entries dropped since the last digest
+}
+
+// Dispatcher routes notifications to the notifiers. Delivery is asynchronous and failures are only
+// logged, a notification outage never blocks the caller.
+type Dispatcher struct {
+	options    Options
+	logger     *logrus.Entry
+	lock       sync.Mutex
+	routes     []*route
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+	closed     bool
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewDispatcher initializes a new Dispatcher
+func NewDispatcher(options Options, logger *logging.Logger) *Dispatcher {
+	return &Dispatcher{
+		options:    options,
+		logger:     logger.WithField("prefix", "notify"),
+		lastSent:   make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Add routes the notifications from minLevel to notifier. A notifier with the same name is replaced, its
+// digest is carried over and the notifications already queued for it are still delivered.
+func (d *Dispatcher) Add(notifier Notifier, minLevel Level) {
+	r := &route{notifier: notifier, minLevel: minLevel, queue: make(chan Message, queueSize)}
+
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	if old := d.remove(notifier.Name()); old != nil {
+		r.digest, r.dropped = old.digest, old.dropped
+	}
+	d.routes = append(d.routes, r)
+	d.lock.Unlock()
+
+	d.logger.Infof("Sending %s notifications and above to %s", minLevel, notifier.Name())
+	d.wg.Add(1)
+	go d.deliver(r)
+}
+
+// Remove stops routing notifications to the notifier named name. The notifications already queued for it
+// are still delivered, its digest is dropped.
+func (d *Dispatcher) Remove(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+	if d.remove(name) != nil {
+		d.logger.Infof("Stopped sending notifications to %s", name)
+	}
+}
+
+// remove takes the route of the notifier named name out and closes its queue, the lock must be held
+func (d *Dispatcher) remove(name string) *route {
+	for i, r := range d.routes {
+		if r.notifier.Name() == name {
+			d.routes = append(d.routes[:i:i], d.routes[i+1:]...)
+			close(r.queue)
+			return r
+		}
+	}
+	return nil
+}
+
+// SetOptions changes the routing rules. A new digest interval applies once the current digest is sent.
+func (d *Dispatcher) SetOptions(options Options) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.options = options
+	d.logger.Infof("Notification options changed to %+v", options)
+}
+
+// Run sends the digest every DigestInterval until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		d.lock.Lock()
+		timer := time.NewTimer(d.options.DigestInterval)
+		d.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			d.flush()
+		}
+	}
+}
+
+// Notify sends a notification. key identifies repeats of the same notification, the text is used if empty:
+// immediate repeats are suppressed within the dedup window and digest entries are replaced by their latest
+// version.
+func (d *Dispatcher) Notify(level Level, key, text string) {
+	msg := Message{Level: level, Key: key, Text: text, Time: time.Now()}
+	if msg.Key == "" {
+		msg.Key = text
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return
+	}
+
+	if level < d.options.ImmediateLevel {
+		for _, r := range d.routes {
+			if level >= r.minLevel {
+				r.addToDigest(msg)
+			}
+		}
+		return
+	}
+
+	if last, ok := d.lastSent[msg.Key]; ok && msg.Time.Sub(last) < d.options.DedupWindow {
+		d.suppressed[msg.Key]++
+		return
+	}
+	if n := d.suppressed[msg.Key]; n > 0 {
+		msg.Text += fmt.Sprintf("\n(repeated %d more times)", n)
+		delete(d.suppressed, msg.Key)
+	}
+	d.lastSent[msg.Key] = msg.Time
+
+	for _, r := range d.routes {
+		if level < r.minLevel {
+			continue
+		}
+		// Over the rate limit, non critical notifications wait for the digest
+		if level < LevelCritical && !r.allow(msg.Time, d.options.RateLimit) {
+			r.addToDigest(msg)
+			continue
+		}
+		d.enqueue(r, msg)
+	}
+}
+
+// Close sends the pending digests and waits for the notifications in flight. Closing again does nothing.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(d.close)
+}
+
+// close flushes the digests and waits for the notifications in flight
+func (d *Dispatcher) close() {
+	d.flush()
+
+	d.lock.Lock()
+	d.closed = true
+	for _, r := range d.routes {
+		close(r.queue)
+	}
+	d.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(SendTimeout):
+		d.logger.Warn("Gave up waiting for the notifications in flight")
+	}
+}
+
+// flush sends the digest of every notifier and forgets the notifications out of the dedup window
+func (d *Dispatcher) flush() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	for key, last := range d.lastSent {
+		if now.Sub(last) >= d.options.DedupWindow && d.suppressed[key] == 0 {
+			delete(d.lastSent, key)
+		}
+	}
+
+	for _, r := range d.routes {
+		if len(r.digest) == 0 {
+			continue
+		}
+
+		lines := make([]string, 0, len(r.digest)+2)
+		lines = append(lines, fmt.Sprintf("Digest of %d notifications since %s", len(r.digest)+r.dropped, r.digest[0].Time.UTC().Format(time.RFC3339)))
+		level := LevelInfo
+		for _, msg := range r.digest {
+			lines = append(lines, fmt.Sprintf("%s %s", msg.Time.UTC().Format("15:04:05"), msg.String()))
+			level = max(level, msg.Level)
+		}
+		if r.dropped > 0 {
+			lines = append(lines, fmt.Sprintf("... and %d older notifications", r.dropped))
+		}
+
+		d.enqueue(r, Message{Level: level, Key: "digest", Text: strings.Join(lines, "\n"), Time: now})
+		r.digest, r.dropped = nil, 0
+	}
+}
+
+// enqueue hands msg to the delivery goroutine of r, the lock must be held
+func (d *Dispatcher) enqueue(r *route, msg Message) {
+	select {
+	case r.queue <- msg:
+	default:
+		d.logger.Warnf("Dropped a %s notification to %s, the queue is full", msg.Level, r.notifier.Name())
+	}
+}
+
+// deliver sends the notifications queued for r until its queue is closed
+func (d *Dispatcher) deliver(r *route) {
+	defer d.wg.Done()
+	for msg := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), SendTimeout)
+		err := supervisor.Recover(d.logger.WithField("notifier", r.notifier.Name()), func() error {
+			return r.notifier.Notify(ctx, msg)
+		})
+		if err != nil {
+			d.logger.WithError(err).Errorf("Failed to send a %s notification to %s", msg.Level, r.notifier.Name())
+		}
+		cancel()
+	}
+}
+
+// allow returns whether an immediate notification may be sent under limit per minute, and counts it
+func (r *route) allow(now time.Time, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(r.sent) && r.sent[i].Before(cutoff) {
+		i++
+	}
+	r.sent = r.sent[i:]
+
+	if len(r.sent) >= limit {
+		return false
+	}
+	r.sent = append(r.sent, now)
+	return true
+}
+
+// addToDigest adds msg to the digest, replacing the previous notification with the same key
+func (r *route) addToDigest(msg Message) {
+	for i, m := range r.digest {
+		if m.Key == msg.Key {
+			r.digest = append(r.digest[:i], r.digest[i+1:]...)
+			break
+		}
+	}
+	r.digest = append(r.digest, msg)
+	if len(r.digest) > maxDigestEntries {
+		r.digest = r.digest[1:]
+		r.dropped++
+	}
+}
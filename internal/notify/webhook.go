@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the timestamp and the webhook body, as sha256=<hex>
+const SignatureHeader = "X-Signature-256"
+
+// TimestampHeader carries the time the webhook was signed, in Unix seconds. Receivers reject old
+// timestamps, so that a captured request cannot be replayed.
+const TimestampHeader = "X-Signature-Timestamp"
+
+// maxAttempts bounds the posts of a notification rate limited by the endpoint
+const maxAttempts = 3
+
+// discordMaxContent is the maximum length of a Discord message
+const discordMaxContent = 2000
+
+// WebhookPayload is the body posted by the WebhookNotifier
+type WebhookPayload struct {
+	Level string    `json:"level"`
+	Key   string    `json:"key"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+}
+
+// WebhookNotifier posts notifications as JSON to an arbitrary URL, signed with a shared secret
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookNotifier initializes a new WebhookNotifier. The body is not signed if secret is empty.
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, secret: []byte(secret), client: &http.Client{}}
+}
+
+// Name implements Notifier
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(WebhookPayload{Level: msg.Level.String(), Key: msg.Key, Text: msg.Text, Time: msg.Time.UTC()})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	if len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers[TimestampHeader] = timestamp
+		headers[SignatureHeader] = Sign(n.secret, timestamp, body)
+	}
+	return post(ctx, n.client, n.url, body, headers)
+}
+
+// Sign returns the signature header value of body sent at timestamp: the HMAC of "<timestamp>.<body>"
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlackNotifier initializes a new SlackNotifier
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{url: url, client: &http.Client{}}
+}
+
+// Name implements Notifier
+func (n *SlackNotifier) Name() string {
+	return "slack"
+}
+
+// Notify implements Notifier
+func (n *SlackNotifier) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{"text": msg.String()})
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.client, n.url, body, nil)
+}
+
+// DiscordNotifier posts notifications to a Discord webhook
+type DiscordNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewDiscordNotifier initializes a new DiscordNotifier
+func NewDiscordNotifier(url string) *DiscordNotifier {
+	return &DiscordNotifier{url: url, client: &http.Client{}}
+}
+
+// Name implements Notifier
+func (n *DiscordNotifier) Name() string {
+	return "discord"
+}
+
+// Notify implements Notifier
+func (n *DiscordNotifier) Notify(ctx context.Context, msg Message) error {
+	content := []rune(msg.String())
+	if len(content) > discordMaxContent {
+		content = append(content[:discordMaxContent-1], '…')
+	}
+
+	body, err := json.Marshal(map[string]string{"content": string(content)})
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.client, n.url, body, nil)
+}
+
+// post sends a JSON body to url and fails on any non 2xx status. A rate limited post is sent again after
+// the delay given by the endpoint, as long as ctx allows.
+func post(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) error {
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := postOnce(ctx, client, endpoint, body, headers)
+		if err == nil || retryAfter < 0 || attempt == maxAttempts {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(retryAfter).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// postOnce sends a JSON body to url. It returns how long to wait before sending again when rate limited,
+// a negative delay otherwise.
+func postOnce(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		// The URL may embed a token, keep it out of the error
+		return -1, fmt.Errorf("Invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Errors of the HTTP client quote the URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return -1, fmt.Errorf("Failed to post the notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("Webhook responded %s: %s", resp.Status, bytes.TrimSpace(detail))
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return retryAfter(resp.Header.Get("Retry-After")), err
+		}
+		return -1, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return -1, nil
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date. A missing or invalid header
+// means retrying right away.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// request is a request received by the test server
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// newServer starts a server answering with the given statuses in turn, the last one repeated, and
+// recording the requests received
+func newServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, <-chan request) {
+	t.Helper()
+	requests := make(chan request, 10)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header.Clone(), body: body}
+
+		status := statuses[min(int(calls.Add(1))-1, len(statuses)-1)]
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(http.StatusText(status)))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// testMessage is the notification sent in the tests
+var testMessage = Message{Level: LevelWarning, Key: "reload", Text: "Rejected the configuration reload", Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+func TestWebhookPayload(t *testing.T) {
+	server, requests := newServer(t, nil, http.StatusOK)
+
+	if err := NewWebhookNotifier(server.URL, "").Notify(context.Background(), testMessage); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	r := <-requests
+	if got := r.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if r.header.Get(SignatureHeader) != "" || r.header.Get(TimestampHeader) != "" {
+		t.Errorf("unsigned webhook carries signature headers: %v", r.header)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("invalid body %s: %v", r.body, err)
+	}
+	want := WebhookPayload{Level: "warning", Key: "reload", Text: testMessage.Text, Time: testMessage.Time}
+	if payload != want {
+		t.Errorf("payload = %+v, want %+v", payload, want)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server, requests := newServer(t, nil, http.StatusNoContent)
+	secret := "shared secret"
+
+	before := time.Now().Unix()
+	if err := NewWebhookNotifier(server.URL, secret).Notify(context.Background(), testMessage); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	r := <-requests
+	timestamp := r.header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sent < before || sent > time.Now().Unix() {
+		t.Fatalf("%s = %q, want the time of the request", TimestampHeader, timestamp)
+	}
+	if got, want := r.header.Get(SignatureHeader), Sign([]byte(secret), timestamp, r.body); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+
+	// The timestamp is signed, a replay with a new timestamp does not match
+	if Sign([]byte(secret), strconv.FormatInt(sent+60, 10), r.body) == r.header.Get(SignatureHeader) {
+		t.Error("signature does not cover the timestamp")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 of "1714564800.{}" with key "secret"
+	want := "sha256=6772f83f980eaa45c478fbaeb2e3661d945e7ba97f73c65ac97333a82742e16f"
+	if got := Sign([]byte("secret"), "1714564800", []byte("{}")); got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	server, requests := newServer(t, nil, http.StatusOK)
+
+	if err := NewSlackNotifier(server.URL).Notify(context.Background(), testMessage); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal((<-requests).body, &payload); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if want := map[string]string{"text": "[WARNING] Rejected the configuration reload"}; len(payload) != 1 || payload["text"] != want["text"] {
+		t.Errorf("payload = %v, want %v", payload, want)
+	}
+}
+
+func TestDiscordPayload(t *testing.T) {
+	server, requests := newServer(t, nil, http.StatusNoContent)
+	notifier := NewDiscordNotifier(server.URL)
+
+	if err := notifier.Notify(context.Background(), testMessage); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal((<-requests).body, &payload); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if len(payload) != 1 || payload["content"] != "[WARNING] Rejected the configuration reload" {
+		t.Errorf("payload = %v, want the message as content", payload)
+	}
+
+	// Long messages are truncated to the Discord limit
+	long := Message{Level: LevelInfo, Text: strings.Repeat("é", discordMaxContent+10)}
+	if err := notifier.Notify(context.Background(), long); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if err := json.Unmarshal((<-requests).body, &payload); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	content := []rune(payload["content"])
+	if len(content) != discordMaxContent || content[len(content)-1] != '…' {
+		t.Errorf("content has %d runes ending with %q, want %d ending with …", len(content), content[len(content)-1], discordMaxContent)
+	}
+}
+
+func TestPostErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			server, requests := newServer(t, nil, status)
+
+			err := NewSlackNotifier(server.URL).Notify(context.Background(), testMessage)
+			if err == nil {
+				t.Fatal("Notify succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), strconv.Itoa(status)) || !strings.Contains(err.Error(), http.StatusText(status)) {
+				t.Errorf("error %q does not report the status and the response", err)
+			}
+			if len(requests) != 1 {
+				t.Errorf("%d requests sent, want 1", len(requests))
+			}
+		})
+	}
+}
+
+func TestPostHidesURL(t *testing.T) {
+	// Nothing listens on the port, the error must not quote the URL and its token
+	err := NewSlackNotifier("http://127.0.0.1:1/services/T000/B000/token").Notify(context.Background(), testMessage)
+	if err == nil {
+		t.Fatal("Notify succeeded, want an error")
+	}
+	if strings.Contains(err.Error(), "token") {
+		t.Errorf("error %q quotes the URL", err)
+	}
+}
+
+func TestPostRetryAfter(t *testing.T) {
+	server, requests := newServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests, http.StatusOK)
+
+	start := time.Now()
+	if err := NewWebhookNotifier(server.URL, "").Notify(context.Background(), testMessage); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the Retry-After delay of 1s", elapsed)
+	}
+	if len(requests) != 2 {
+		t.Errorf("%d requests sent, want 2", len(requests))
+	}
+}
+
+func TestPostRetryAfterBeyondDeadline(t *testing.T) {
+	server, requests := newServer(t, http.Header{"Retry-After": {"60"}}, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := NewWebhookNotifier(server.URL, "").Notify(ctx, testMessage)
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("Notify = %v, want the 429 error", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("%d requests sent, want 1 since the delay is past the deadline", len(requests))
+	}
+}
+
+func TestPostRateLimitedAttempts(t *testing.T) {
+	server, requests := newServer(t, nil, http.StatusTooManyRequests)
+
+	if err := NewWebhookNotifier(server.URL, "").Notify(context.Background(), testMessage); err == nil {
+		t.Fatal("Notify succeeded, want an error")
+	}
+	if len(requests) != maxAttempts {
+		t.Errorf("%d requests sent, want %d", len(requests), maxAttempts)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"invalid", 0},
+		{"3", 3 * time.Second},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.value); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := retryAfter(future); got <= 0 || got > time.Minute {
+		t.Errorf("retryAfter(%q) = %s, want up to 1m", future, got)
+	}
+}
@@ -0,0 +1,369 @@
+package paper
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"rattrap/arbitrage-bot/internal/kucoin"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/uniswap"
+	"rattrap/arbitrage-bot/internal/utils"
+	"sort"
+	"strings"
+	"sync"
+
+	coreentities "github.com/daoleno/uniswap-sdk-core/entities"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Venues holding virtual balances
+const (
+	VenueUniswap = "UNISWAP"
+	VenueKucoin  = "KUCOIN"
+)
+
+// DefaultSwapGas is the gas charged for a simulated swap
+const DefaultSwapGas = 150000
+
+// ErrInsufficientBalance is returned when a virtual balance cannot cover a trade
+var ErrInsufficientBalance = fmt.Errorf("insufficient virtual balance")
+
+// Balances maps a venue to its token balances
+type Balances map[string]map[string]decimal.Decimal
+
+// Fill is the outcome of a simulated trade
+type Fill struct {
+	Venue     string          // Venue the trade was simulated on
+	TokenIn   string          // Token paid
+	AmountIn  decimal.Decimal // Amount paid, fee included
+	TokenOut  string          // Token received
+	AmountOut decimal.Decimal // Amount received, fee deducted
+	Fee       decimal.Decimal // Fee paid, in TokenOut for swaps and in the quote token for orders
+	Gas       decimal.Decimal // Gas paid in ETH
+}
+
+// Engine simulates trades on Uniswap and KuCoin against virtual balances
+type Engine struct {
+	uniswapClient *uniswap.UniswapClient
+	kucoinClient  *kucoin.KucoinClient
+	logger        *logrus.Entry
+	token0        string
+	token1        string
+	kucoinFee     decimal.Decimal
+	gasReserve    decimal.Decimal // Virtual ETH never traded away on Uniswap
+	swapGas       uint64
+	simulate      bool
+	lock          sync.Mutex
+	initial       Balances
+	balances      Balances
+	gasSpent      decimal.Decimal
+	trades        int
+	orders        int
+}
+
+// NewEngine initializes a new paper trading Engine with the given starting balances
+func NewEngine(tradingPair string, balances Balances, kucoinFeeBps int64, simulate bool, uniswapClient *uniswap.UniswapClient, kucoinClient *kucoin.KucoinClient, logger *logging.Logger) *Engine {
+	prefixedLogger := logger.WithField("prefix", "paper").WithField("market", tradingPair)
+	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
+
+	initial := make(Balances)
+	current := make(Balances)
+	for _, venue := range []string{VenueUniswap, VenueKucoin} {
+		initial[venue] = make(map[string]decimal.Decimal)
+		current[venue] = make(map[string]decimal.Decimal)
+		for token, amount := range balances[venue] {
+			initial[venue][token] = amount
+			current[venue][token] = amount
+		}
+	}
+
+	return &Engine{
+		uniswapClient: uniswapClient,
+		kucoinClient:  kucoinClient,
+		logger:        prefixedLogger,
+		token0:        token0,
+		token1:        token1,
+		kucoinFee:     decimal.New(kucoinFeeBps, -4),
+		gasReserve:    uniswapClient.GasReserve(),
+		swapGas:       DefaultSwapGas,
+		simulate:      simulate,
+		initial:       initial,
+		balances:      current,
+		gasSpent:      decimal.Zero,
+	}
+}
+
+// Swap simulates an exact input swap on Uniswap against the local pool model. When simulate is enabled
+// the swap is also run with eth_call (with state overrides) and its exact output and gas are used instead.
+// Like the router, the swap fails when its output is below the minimum output required by opts.
+func (e *Engine) Swap(amount *coreentities.CurrencyAmount, opts uniswap.TradeOptions) (*coreentities.CurrencyAmount, *Fill, error) {
+	output, minAmountOut, err := e.uniswapClient.MinimumOutput(amount, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to quote swap: %w", err)
+	}
+
+	gasUsed := e.swapGas
+	if e.simulate {
+		swap, err := e.uniswapClient.Trade(context.Background(), amount, opts, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		output = swap.Simulation.AmountOut
+		gasUsed = swap.Simulation.GasUsed
+	}
+	if output.LessThan(minAmountOut.Fraction) {
+		return nil, nil, fmt.Errorf("%w: output %s, minimum %s %s", uniswap.ErrUnprofitable, output.ToExact(), minAmountOut.ToExact(), output.Currency.Symbol())
+	}
+
+	gasPrice, err := e.uniswapClient.SuggestGasPrice()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get gas price: %s", err)
+	}
+	gas := decimal.NewFromBigInt(new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasUsed)), -18)
+
+	token0, _ := e.uniswapClient.GetTokens()
+	tokenIn, tokenOut := e.token1, e.token0
+	if amount.Currency.Wrapped().Equal(token0) {
+		tokenIn, tokenOut = e.token0, e.token1
+	}
+
+	fill := &Fill{
+		Venue:     VenueUniswap,
+		TokenIn:   tokenIn,
+		AmountIn:  toDecimal(amount),
+		TokenOut:  tokenOut,
+		AmountOut: toDecimal(output),
+		Fee:       decimal.Zero, // The pool fee is already deducted by the pool model
+		Gas:       gas,
+	}
+
+	if err := e.apply(fill); err != nil {
+		return nil, nil, err
+	}
+
+	return output, fill, nil
+}
+
+// Order simulates an order on KuCoin by walking the live order book, with the symbol rules and the
+// order options applied the same way as live orders. Taker orders use price as reference price. Resting
+// orders are not simulated: a GTC remainder or a post-only order stays unfilled and is reported active.
+func (e *Engine) Order(side string, size decimal.Decimal, price decimal.Decimal, opts kucoin.OrderOptions) (*kucoin.OrderResult, error) {
+	rules := e.kucoinClient.GetSymbolRules()
+	size = rules.RoundSize(size)
+	if size.IsZero() {
+		return nil, rules.Validate(size, decimal.Zero)
+	}
+
+	book, err := e.kucoinClient.GetOrderBook()
+	if err != nil {
+		return nil, err
+	}
+
+	levels := book.Asks
+	if side == "sell" {
+		levels = book.Bids
+	}
+
+	if opts.PostOnly {
+		price, err = bestPrice(book.Bids)
+		if side == "sell" {
+			price, err = bestPrice(book.Asks)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	limitPrice := rules.RoundPrice(side, kucoin.LimitPrice(side, price, opts))
+	if err := rules.Validate(size, limitPrice); err != nil {
+		return nil, err
+	}
+
+	e.lock.Lock()
+	e.orders++
+	result := &kucoin.OrderResult{OrderID: fmt.Sprintf("paper-%d", e.orders), Side: side, Type: opts.Type}
+	e.lock.Unlock()
+
+	market := opts.Type == kucoin.OrderTypeMarket
+	result.Size = size
+	if market {
+		// Like live market orders, only filled if the book within the price band can absorb them
+		if err := kucoin.CheckDepth(book, side, size, limitPrice); err != nil {
+			return nil, err
+		}
+	} else {
+		result.Type = kucoin.OrderTypeLimit
+		result.Price = limitPrice
+	}
+
+	remaining := size
+	funds := decimal.Zero
+	for _, level := range levels {
+		if remaining.IsZero() || opts.PostOnly {
+			break
+		}
+		if len(level) < 2 {
+			continue
+		}
+		levelPrice, err := decimal.NewFromString(level[0])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse order book price: %s", err)
+		}
+		available, err := decimal.NewFromString(level[1])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse order book size: %s", err)
+		}
+		if !market && ((side == "buy" && levelPrice.GreaterThan(limitPrice)) || (side == "sell" && levelPrice.LessThan(limitPrice))) {
+			break
+		}
+		filled := decimal.Min(remaining, available)
+		funds = funds.Add(filled.Mul(levelPrice))
+		remaining = remaining.Sub(filled)
+	}
+
+	if remaining.IsPositive() {
+		switch {
+		case opts.TimeInForce == kucoin.TimeInForceFOK && !market:
+			return nil, fmt.Errorf("FOK order for %s %s cannot be filled within %s", size.String(), e.token0, limitPrice.String())
+		case !market && opts.TimeInForce != kucoin.TimeInForceIOC:
+			result.IsActive = true
+		}
+	}
+
+	filled := size.Sub(remaining)
+	if filled.IsZero() {
+		return result, nil
+	}
+
+	e.lock.Lock()
+	fee := funds.Mul(e.kucoinFee)
+	e.lock.Unlock()
+	fill := &Fill{Venue: VenueKucoin, Fee: fee, Gas: decimal.Zero}
+	if side == "sell" {
+		fill.TokenIn, fill.AmountIn = e.token0, filled
+		fill.TokenOut, fill.AmountOut = e.token1, funds.Sub(fee)
+	} else {
+		fill.TokenIn, fill.AmountIn = e.token1, funds.Add(fee)
+		fill.TokenOut, fill.AmountOut = e.token0, filled
+	}
+
+	if err := e.apply(fill); err != nil {
+		return nil, err
+	}
+
+	result.DealSize = filled
+	result.DealFunds = funds
+	result.Fee = fee
+	result.FeeCurrency = e.token1
+
+	return result, nil
+}
+
+// apply moves the virtual balances according to fill
+func (e *Engine) apply(fill *Fill) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	balances := e.balances[fill.Venue]
+	if balances[fill.TokenIn].LessThan(fill.AmountIn) {
+		return fmt.Errorf("%w: %s %s needed on %s, have %s", ErrInsufficientBalance, fill.AmountIn.String(), fill.TokenIn, fill.Venue, balances[fill.TokenIn].String())
+	}
+	if balances["ETH"].LessThan(fill.Gas) {
+		return fmt.Errorf("%w: %s ETH needed for gas on %s, have %s", ErrInsufficientBalance, fill.Gas.String(), fill.Venue, balances["ETH"].String())
+	}
+	// Like the wallet, a swap paying with ETH must leave the gas reserve untouched
+	if fill.Venue == VenueUniswap && fill.TokenIn == "ETH" {
+		if spendable := balances["ETH"].Sub(fill.Gas).Sub(e.gasReserve); spendable.LessThan(fill.AmountIn) {
+			return fmt.Errorf("%w: %s ETH needed on %s above the %s ETH gas reserve, have %s", ErrInsufficientBalance, fill.AmountIn.String(), fill.Venue, e.gasReserve.String(), balances["ETH"].String())
+		}
+	}
+
+	balances[fill.TokenIn] = balances[fill.TokenIn].Sub(fill.AmountIn)
+	balances[fill.TokenOut] = balances[fill.TokenOut].Add(fill.AmountOut)
+	if fill.Gas.IsPositive() {
+		balances["ETH"] = balances["ETH"].Sub(fill.Gas)
+		e.gasSpent = e.gasSpent.Add(fill.Gas)
+	}
+	e.trades++
+
+	e.logger.Infof("Paper %s fill: paid %s %s, received %s %s, fee %s, gas %s ETH", fill.Venue, fill.AmountIn.String(), fill.TokenIn, fill.AmountOut.String(), fill.TokenOut, fill.Fee.String(), fill.Gas.String())
+	return nil
+}
+
+// SetKucoinFee changes the KuCoin fee charged on the next paper orders
+func (e *Engine) SetKucoinFee(feeBps int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.kucoinFee = decimal.New(feeBps, -4)
+}
+
+// GetBalances returns a copy of the virtual balances
+func (e *Engine) GetBalances() Balances {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	balances := make(Balances)
+	for venue, tokens := range e.balances {
+		balances[venue] = make(map[string]decimal.Decimal)
+		for token, amount := range tokens {
+			balances[venue][token] = amount
+		}
+	}
+	return balances
+}
+
+// PnL returns the simulated PnL in token1, valuing token0 at price. Holding the starting balances is the
+// benchmark, so price moves do not show up as PnL. Gas is paid in ETH and reported separately.
+func (e *Engine) PnL(price float64) (decimal.Decimal, decimal.Decimal) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	p := decimal.NewFromFloat(price)
+	pnl := decimal.Zero
+	for venue, tokens := range e.balances {
+		pnl = pnl.Add(tokens[e.token0].Sub(e.initial[venue][e.token0]).Mul(p))
+		pnl = pnl.Add(tokens[e.token1].Sub(e.initial[venue][e.token1]))
+	}
+	return pnl, e.gasSpent
+}
+
+// Report returns a human readable summary of the simulated PnL and balances
+func (e *Engine) Report(price float64) string {
+	pnl, gas := e.PnL(price)
+	balances := e.GetBalances()
+
+	var parts []string
+	for _, venue := range []string{VenueUniswap, VenueKucoin} {
+		tokens := make([]string, 0, len(balances[venue]))
+		for token := range balances[venue] {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+		for _, token := range tokens {
+			parts = append(parts, fmt.Sprintf("%s %s %s", venue, balances[venue][token].String(), token))
+		}
+	}
+
+	e.lock.Lock()
+	trades := e.trades
+	e.lock.Unlock()
+
+	return fmt.Sprintf("Paper PnL: %s %s, gas spent: %s ETH, fills: %d, balances: %s", pnl.StringFixed(6), e.token1, gas.String(), trades, strings.Join(parts, ", "))
+}
+
+// bestPrice returns the price of the first level of an order book side
+func bestPrice(levels [][]string) (decimal.Decimal, error) {
+	if len(levels) == 0 || len(levels[0]) < 2 {
+		return decimal.Zero, fmt.Errorf("Empty order book")
+	}
+	price, err := decimal.NewFromString(levels[0][0])
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("Failed to parse order book price: %s", err)
+	}
+	return price, nil
+}
+
+// toDecimal converts a currency amount to a decimal
+func toDecimal(amount *coreentities.CurrencyAmount) decimal.Decimal {
+	return decimal.NewFromBigInt(amount.Quotient(), -int32(amount.Currency.Decimals()))
+}
@@ -0,0 +1,266 @@
+package pnl
+
+import (
+	"fmt"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/utils"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxHistory is the number of marks kept in memory
+const MaxHistory = 1440
+
+// Balances maps a venue to its token balances
+type Balances map[string]map[string]decimal.Decimal
+
+// Fills sums up the legs of an arbitrage. Amounts paid include fees and amounts received are net of fees.
+type Fills struct {
+	Acquired  decimal.Decimal // token0 bought
+	Cost      decimal.Decimal // token1 paid for it
+	Disposed  decimal.Decimal // token0 sold
+	Proceeds  decimal.Decimal // token1 received for it
+	PoolFee   decimal.Decimal // Uniswap pool fee, in token1
+	KucoinFee decimal.Decimal // KuCoin fee, in token1
+	Gas       decimal.Decimal // Gas spent, in ETH
+}
+
+// Attribution is the realized PnL of an arbitrage, in token1
+type Attribution struct {
+	TradeID   string          `json:"trade_id"`
+	Time      time.Time       `json:"time"`
+	Gross     decimal.Decimal `json:"gross"`      // Before any fee or gas
+	PoolFee   decimal.Decimal `json:"pool_fee"`   // Uniswap pool fee
+	KucoinFee decimal.Decimal `json:"kucoin_fee"` // KuCoin fee
+	Gas       decimal.Decimal `json:"gas"`        // Gas, converted at the ETH price of the trade
+	Net       decimal.Decimal `json:"net"`        // Gross minus fees and gas
+	Residual  decimal.Decimal `json:"residual"`   // token0 left open by the arbitrage
+}
+
+// Position is the residual token0 inventory left by unhedged legs, and its cost basis in token1. A short
+// position has a negative size and a negative basis (the proceeds of the sale).
+type Position struct {
+	Size  decimal.Decimal `json:"size"`
+	Basis decimal.Decimal `json:"basis"`
+}
+
+// Mark values the inventory at a point in time, in token1
+type Mark struct {
+	Time       time.Time       `json:"time"`
+	Market     string          `json:"market,omitempty"`
+	Price      decimal.Decimal `json:"price"`      // token0 price
+	EthPrice   decimal.Decimal `json:"eth_price"`  // ETH price
+	Inventory  decimal.Decimal `json:"inventory"`  // Value of every balance on both venues
+	Position   Position        `json:"position"`   // Residual position
+	Unrealized decimal.Decimal `json:"unrealized"` // PnL of the residual position at Price
+	Realized   decimal.Decimal `json:"realized"`   // Cumulative realized PnL, net of fees and gas
+	Total      decimal.Decimal `json:"total"`      // Realized plus unrealized
+	Change     decimal.Decimal `json:"change"`     // Change of the inventory value since the first mark
+}
+
+// State is the state of the Engine persisted across restarts
+type State struct {
+	Position   Position        `json:"position"`
+	Realized   decimal.Decimal `json:"realized"`
+	PoolFees   decimal.Decimal `json:"pool_fees"`
+	KucoinFees decimal.Decimal `json:"kucoin_fees"`
+	Gas        decimal.Decimal `json:"gas"`
+	Trades     int             `json:"trades"`
+	First      *Mark           `json:"first,omitempty"` // First mark, the inventory change is measured from it
+}
+
+// Engine attributes realized PnL per arbitrage and tracks unrealized PnL on the residual inventory
+type Engine struct {
+	logger     *logrus.Entry
+	market     string
+	token0     string
+	token1     string
+	lock       sync.Mutex
+	position   Position
+	realized   decimal.Decimal
+	poolFees   decimal.Decimal
+	kucoinFees decimal.Decimal
+	gas        decimal.Decimal
+	trades     int
+	first      *Mark
+	history    []Mark
+}
+
+// NewEngine initializes a new PnL Engine
+func NewEngine(tradingPair string, logger *logging.Logger) *Engine {
+	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
+	return &Engine{
+		logger: logger.WithField("prefix", "pnl").WithField("market", tradingPair),
+		market: tradingPair,
+		token0: token0,
+		token1: token1,
+	}
+}
+
+// Settle books the fills of an arbitrage against the residual position at average cost and returns its
+// realized PnL. ethPrice converts gas to token1.
+func (e *Engine) Settle(tradeID string, fills Fills, ethPrice decimal.Decimal) *Attribution {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	realized := decimal.Zero
+	if fills.Acquired.IsPositive() {
+		realized = realized.Add(e.position.add(fills.Acquired, fills.Cost))
+	}
+	if fills.Disposed.IsPositive() {
+		realized = realized.Add(e.position.add(fills.Disposed.Neg(), fills.Proceeds.Neg()))
+	}
+
+	gas := fills.Gas.Mul(ethPrice)
+	attribution := &Attribution{
+		TradeID:   tradeID,
+		Time:      time.Now(),
+		Gross:     realized.Add(fills.PoolFee).Add(fills.KucoinFee),
+		PoolFee:   fills.PoolFee,
+		KucoinFee: fills.KucoinFee,
+		Gas:       gas,
+		Net:       realized.Sub(gas),
+		Residual:  fills.Acquired.Sub(fills.Disposed),
+	}
+
+	e.realized = e.realized.Add(attribution.Net)
+	e.poolFees = e.poolFees.Add(fills.PoolFee)
+	e.kucoinFees = e.kucoinFees.Add(fills.KucoinFee)
+	e.gas = e.gas.Add(gas)
+	e.trades++
+
+	e.logger.Infof("Trade %s realized %s %s (gross %s, pool fee %s, KuCoin fee %s, gas %s), residual %s %s", tradeID, attribution.Net.StringFixed(6), e.token1, attribution.Gross.StringFixed(6), fills.PoolFee.StringFixed(6), fills.KucoinFee.StringFixed(6), gas.StringFixed(6), attribution.Residual.String(), e.token0)
+	return attribution
+}
+
+// Mark values the balances of both venues and the residual position at price, and appends the mark to
+// the history
+func (e *Engine) Mark(price, ethPrice decimal.Decimal, balances Balances) Mark {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	inventory := decimal.Zero
+	for _, tokens := range balances {
+		for token, amount := range tokens {
+			switch token {
+			case e.token0:
+				inventory = inventory.Add(amount.Mul(price))
+			case e.token1:
+				inventory = inventory.Add(amount)
+			case "ETH", "WETH":
+				inventory = inventory.Add(amount.Mul(ethPrice))
+			}
+		}
+	}
+
+	unrealized := e.position.Size.Mul(price).Sub(e.position.Basis)
+	mark := Mark{
+		Time:       time.Now(),
+		Market:     e.market,
+		Price:      price,
+		EthPrice:   ethPrice,
+		Inventory:  inventory,
+		Position:   e.position,
+		Unrealized: unrealized,
+		Realized:   e.realized,
+		Total:      e.realized.Add(unrealized),
+	}
+	if e.first == nil {
+		e.first = &mark
+	}
+	mark.Change = inventory.Sub(e.first.Inventory)
+
+	e.history = append(e.history, mark)
+	if len(e.history) > MaxHistory {
+		e.history = e.history[len(e.history)-MaxHistory:]
+	}
+
+	return mark
+}
+
+// State returns the state to persist
+func (e *Engine) State() State {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return State{
+		Position:   e.position,
+		Realized:   e.realized,
+		PoolFees:   e.poolFees,
+		KucoinFees: e.kucoinFees,
+		Gas:        e.gas,
+		Trades:     e.trades,
+		First:      e.first,
+	}
+}
+
+// Restore restores the state persisted by a previous run, and the history from its latest marks, oldest
+// first. It is called before trading starts.
+func (e *Engine) Restore(state State, history []Mark) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.position = state.Position
+	e.realized = state.Realized
+	e.poolFees = state.PoolFees
+	e.kucoinFees = state.KucoinFees
+	e.gas = state.Gas
+	e.trades = state.Trades
+	e.first = state.First
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+	e.history = append([]Mark(nil), history...)
+
+	e.logger.Infof("Restored the PnL: realized %s %s over %d trades, residual %s %s with basis %s %s", e.realized.StringFixed(6), e.token1, e.trades, e.position.Size.String(), e.token0, e.position.Basis.StringFixed(6), e.token1)
+}
+
+// GetHistory returns the marks kept in memory, oldest first
+func (e *Engine) GetHistory() []Mark {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Mark(nil), e.history...)
+}
+
+// GetPosition returns the residual position
+func (e *Engine) GetPosition() Position {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.position
+}
+
+// Report returns a human readable summary of the PnL as of the last mark
+func (e *Engine) Report() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	unrealized := decimal.Zero
+	if len(e.history) > 0 {
+		unrealized = e.history[len(e.history)-1].Unrealized
+	}
+
+	return fmt.Sprintf("PnL: realized %s %s over %d trades (pool fees %s, KuCoin fees %s, gas %s), unrealized %s %s on %s %s", e.realized.StringFixed(6), e.token1, e.trades, e.poolFees.StringFixed(6), e.kucoinFees.StringFixed(6), e.gas.StringFixed(6), unrealized.StringFixed(6), e.token1, e.position.Size.String(), e.token0)
+}
+
+// add books size token0 for basis token1 at average cost and returns the PnL realized by closing part of
+// the position
+func (p *Position) add(size, basis decimal.Decimal) decimal.Decimal {
+	if p.Size.IsZero() || p.Size.Sign() == size.Sign() {
+		p.Size = p.Size.Add(size)
+		p.Basis = p.Basis.Add(basis)
+		return decimal.Zero
+	}
+
+	closing := decimal.Min(p.Size.Abs(), size.Abs())
+	closedBasis := p.Basis.Mul(closing).Div(p.Size.Abs())
+	incomingBasis := basis.Mul(closing).Div(size.Abs())
+
+	// Closing a long: received the incoming proceeds for the closed cost, and the reverse for a short
+	realized := closedBasis.Add(incomingBasis).Neg()
+
+	p.Size = p.Size.Add(size)
+	p.Basis = p.Basis.Sub(closedBasis).Add(basis.Sub(incomingBasis))
+	return realized
+}
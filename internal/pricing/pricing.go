@@ -3,8 +3,10 @@ package pricing
 import (
 	"rattrap/arbitrage-bot/internal/kucoin"
 	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/metrics"
 	"rattrap/arbitrage-bot/internal/uniswap"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,20 +16,23 @@ type PricingService struct {
 	uniswapClient *uniswap.UniswapClient
 	kucoinClient  *kucoin.KucoinClient
 	logger        *logrus.Entry
+	tradingPair   string
 	stopChan      chan struct{}
+	closeOnce     sync.Once
 	lock          sync.RWMutex
 	uniswapPrice  float64
 	kucoinPrice   float64
 }
 
 // NewPricingService initializes a new PricingService
-func NewPricingService(uniswapClient *uniswap.UniswapClient, kucoinClient *kucoin.KucoinClient, logger *logging.Logger) *PricingService {
-	prefixedLogger := logger.WithField("prefix", "pricing")
+func NewPricingService(tradingPair string, uniswapClient *uniswap.UniswapClient, kucoinClient *kucoin.KucoinClient, logger *logging.Logger) *PricingService {
+	prefixedLogger := logger.WithField("prefix", "pricing").WithField("market", tradingPair)
 
 	return &PricingService{
 		uniswapClient: uniswapClient,
 		kucoinClient:  kucoinClient,
 		logger:        prefixedLogger,
+		tradingPair:   tradingPair,
 		stopChan:      make(chan struct{}),
 		lock:          sync.RWMutex{},
 		kucoinPrice:   0,
@@ -41,22 +46,39 @@ func (ps *PricingService) FetchPrices() {
 	defer ps.lock.Unlock()
 
 	ps.logger.Debug("Fetching prices")
+	defer metrics.ObserveTick(time.Now())
 
-	// Fetch prices from Uniswap
-	uniswapPrice, err := ps.uniswapClient.GetPrice()
-	if err != nil {
-		ps.logger.WithError(err).Error("Failed to get Uniswap price")
+	// Refresh the pool snapshot and fetch prices from Uniswap
+	if err := ps.uniswapClient.Refresh(); err != nil {
+		ps.logger.WithError(err).Error("Failed to refresh Uniswap pool")
 	}
+	uniswapPrice, uniswapErr := ps.uniswapClient.GetPrice()
+	if uniswapErr != nil {
+		ps.logger.WithError(uniswapErr).Error("Failed to get Uniswap price")
+	} else {
+		metrics.SetPrice(ps.tradingPair, "UNISWAP", uniswapPrice)
+	}
+
+	_, snapshotTime := ps.uniswapClient.GetSnapshot()
+	metrics.SetQuoteTime(ps.tradingPair, "UNISWAP", snapshotTime)
 
 	// Fetch prices from KuCoin
-	kucoinPrice, err := ps.kucoinClient.GetPrice()
-	if err != nil {
-		ps.logger.WithError(err).Error("Failed to get KuCoin price")
+	kucoinPrice, kucoinErr := ps.kucoinClient.GetPrice()
+	if kucoinErr != nil {
+		ps.logger.WithError(kucoinErr).Error("Failed to get KuCoin price")
+	} else {
+		metrics.SetQuoteTime(ps.tradingPair, "KUCOIN", time.Now())
+		metrics.SetPrice(ps.tradingPair, "KUCOIN", kucoinPrice)
 	}
 
 	// Store the prices
 	ps.uniswapPrice = uniswapPrice
 	ps.kucoinPrice = kucoinPrice
+
+	// A failed fetch keeps the last published prices and spread
+	if uniswapErr == nil && kucoinErr == nil {
+		metrics.SetSpread(ps.tradingPair, uniswapPrice, kucoinPrice)
+	}
 }
 
 // Start starts the PricingService
@@ -85,8 +107,10 @@ func (ps *PricingService) GetPrices() (float64, float64) {
 	return ps.uniswapPrice, ps.kucoinPrice
 }
 
-// Close closes the PricingService
+// Close closes the PricingService. Closing again does nothing.
 func (ps *PricingService) Close() {
-	ps.logger.Debug("Closing service")
-	close(ps.stopChan)
+	ps.closeOnce.Do(func() {
+		ps.logger.Debug("Closing service")
+		close(ps.stopChan)
+	})
 }
@@ -0,0 +1,262 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/ledger"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
+	"rattrap/arbitrage-bot/internal/paper"
+	"rattrap/arbitrage-bot/internal/pnl"
+	"rattrap/arbitrage-bot/internal/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// BalanceSource reads the balances of both venues, nil if they could not be read
+type BalanceSource interface {
+	SnapshotBalances() pnl.Balances
+}
+
+// Drift is a balance that differs from what the ledger explains
+type Drift struct {
+	Venue    string
+	Token    string
+	Expected decimal.Decimal
+	Actual   decimal.Decimal
+	Drift    decimal.Decimal // Actual minus expected
+}
+
+// String formats the drift for alerts
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: expected %s, actual %s, drift %s", d.Venue, d.Token, d.Expected.String(), d.Actual.String(), d.Drift.String())
+}
+
+// Reconciler periodically snapshots the balances of both venues and checks them against the ledger
+type Reconciler struct {
+	logger       *logrus.Entry
+	source       BalanceSource
+	ledger       *ledger.Ledger
+	notifier     *notify.Dispatcher
+	market       string
+	account      string
+	lock         sync.Mutex
+	interval     time.Duration
+	toleranceBps int64
+	paper        bool
+	last         *ledger.Snapshot
+}
+
+// NewReconciler initializes a new Reconciler. account is the on-chain account trading the market, its swaps
+// move the Uniswap balances whatever the market they were made on.
+func NewReconciler(paperTrading bool, source BalanceSource, tradeLedger *ledger.Ledger, notifier *notify.Dispatcher, tradingPair, account string, interval time.Duration, toleranceBps int64, logger *logging.Logger) *Reconciler {
+	return &Reconciler{
+		logger:       logger.WithField("prefix", "reconcile").WithField("market", tradingPair),
+		source:       source,
+		ledger:       tradeLedger,
+		notifier:     notifier,
+		market:       tradingPair,
+		account:      account,
+		interval:     interval,
+		toleranceBps: toleranceBps,
+		paper:        paperTrading,
+	}
+}
+
+// Run snapshots the balances every interval until ctx is cancelled. In live mode the first snapshot is
+// reconciled against the last one stored by a previous run, paper balances start afresh on every run.
+func (r *Reconciler) Run(ctx context.Context) error {
+	if !r.paper && r.last == nil {
+		last, err := r.ledger.LastSnapshot(r.market)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to read the last balance snapshot")
+		} else if last != nil && !last.Paper {
+			r.last = last
+			r.logger.Infof("Reconciling from the balance snapshot of %s", last.Time.UTC().Format(time.RFC3339))
+		}
+	}
+	r.Reconcile()
+
+	for {
+		interval, _ := r.getOptions()
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			r.Reconcile()
+		}
+	}
+}
+
+// SetOptions changes the snapshot interval and the drift tolerance, they apply from the next snapshot
+func (r *Reconciler) SetOptions(interval time.Duration, toleranceBps int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.interval, r.toleranceBps = interval, toleranceBps
+	r.logger.Infof("Reconciling every %s with a tolerance of %d bps", interval, toleranceBps)
+}
+
+func (r *Reconciler) getOptions() (time.Duration, int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.interval, r.toleranceBps
+}
+
+// Reconcile takes a balance snapshot, stores it and compares it to the previous snapshot plus the fills
+// recorded since. The new snapshot becomes the baseline of the next run, so a drift is reported once.
+func (r *Reconciler) Reconcile() {
+	balances := r.source.SnapshotBalances()
+	if balances == nil {
+		r.logger.Warn("Skipping reconciliation, balances are unavailable")
+		return
+	}
+
+	snapshot := &ledger.Snapshot{Time: time.Now(), Market: r.market, Balances: balances, Paper: r.paper}
+	if err := r.ledger.RecordSnapshot(snapshot); err != nil {
+		r.logger.WithError(err).Error("Failed to record the balance snapshot")
+	}
+
+	last := r.last
+	r.last = snapshot
+	if last == nil {
+		r.logger.Info("Stored the first balance snapshot, reconciliation starts with the next one")
+		return
+	}
+
+	// Fills are windowed by fill time, a swap mined after its trade counts when it was recorded mined
+	txs, orders, err := r.ledger.ListFills(last.Time, snapshot.Time)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to list fills since the last snapshot")
+		return
+	}
+	txs, orders = r.filter(txs, orders)
+
+	_, toleranceBps := r.getOptions()
+	drifts := Compare(Expected(last.Balances, txs, orders), balances, toleranceBps)
+	if len(drifts) == 0 {
+		r.logger.Infof("Balances reconciled against %d swaps and %d orders", len(txs), len(orders))
+		return
+	}
+
+	lines := make([]string, 0, len(drifts))
+	for _, d := range drifts {
+		lines = append(lines, d.String())
+		r.logger.Errorf("Unexplained balance drift: %s", d.String())
+	}
+	r.notifier.Notify(notify.LevelWarning, "", "Unexplained "+r.market+" balance drift since "+last.Time.UTC().Format(time.RFC3339)+"\n"+strings.Join(lines, "\n"))
+}
+
+// filter keeps the fills that move the balances of the market. Paper markets trade their own simulated
+// balances. Live markets share the KuCoin account, and the on-chain account with the markets it trades.
+func (r *Reconciler) filter(txs []*ledger.Tx, orders []*ledger.Order) ([]*ledger.Tx, []*ledger.Order) {
+	var keptTxs []*ledger.Tx
+	for _, tx := range txs {
+		if (r.paper && tx.Market == r.market) || (!r.paper && tx.From == r.account) {
+			keptTxs = append(keptTxs, tx)
+		}
+	}
+	if !r.paper {
+		return keptTxs, orders
+	}
+
+	var keptOrders []*ledger.Order
+	for _, order := range orders {
+		if order.Market == r.market {
+			keptOrders = append(keptOrders, order)
+		}
+	}
+	return keptTxs, keptOrders
+}
+
+// Expected applies the fills of txs and orders to the start balances. Successful and paper swaps move tokens
+// and gas on Uniswap, pending swaps nothing yet, orders move their filled size of token0, funds of token1 and
+// fee on KuCoin. Only the tokens of start are moved, fills of other markets may touch tokens it does not hold.
+func Expected(start pnl.Balances, txs []*ledger.Tx, orders []*ledger.Order) pnl.Balances {
+	expected := make(pnl.Balances)
+	for venue, tokens := range start {
+		expected[venue] = make(map[string]decimal.Decimal)
+		for token, amount := range tokens {
+			expected[venue][token] = amount
+		}
+	}
+	add := func(venue, token string, amount decimal.Decimal) {
+		if balance, ok := expected[venue][token]; ok {
+			expected[venue][token] = balance.Add(amount)
+		}
+	}
+
+	for _, tx := range txs {
+		if tx.Status != ledger.TxStatusSuccess && tx.Status != ledger.TxStatusPaper {
+			// Reverted transactions still pay for gas
+			if tx.Status == ledger.TxStatusFailed && tx.Hash != "" {
+				add(paper.VenueUniswap, "ETH", tx.GasCost.Neg())
+			}
+			continue
+		}
+		add(paper.VenueUniswap, tx.TokenIn, tx.AmountIn.Neg())
+		add(paper.VenueUniswap, tx.TokenOut, tx.AmountOut)
+		add(paper.VenueUniswap, "ETH", tx.GasCost.Neg())
+	}
+
+	for _, order := range orders {
+		// Orders recorded before they carried their market cannot be attributed to tokens
+		if order.Market == "" {
+			continue
+		}
+		token0, token1 := utils.GetTokensFromTradingPair(order.Market)
+		if order.Side == "buy" {
+			add(paper.VenueKucoin, token0, order.DealSize)
+			add(paper.VenueKucoin, token1, order.DealFunds.Neg())
+		} else {
+			add(paper.VenueKucoin, token0, order.DealSize.Neg())
+			add(paper.VenueKucoin, token1, order.DealFunds)
+		}
+		if order.FeeCurrency != "" {
+			add(paper.VenueKucoin, order.FeeCurrency, order.Fee.Neg())
+		}
+	}
+
+	return expected
+}
+
+// Compare returns the balances of actual that differ from expected by more than toleranceBps of the
+// expected balance, sorted by venue and token
+func Compare(expected, actual pnl.Balances, toleranceBps int64) []Drift {
+	var drifts []Drift
+	check := func(venue, token string) {
+		e, a := expected[venue][token], actual[venue][token]
+		drift := a.Sub(e)
+		tolerance := e.Abs().Mul(decimal.New(toleranceBps, -4))
+		if drift.Abs().GreaterThan(tolerance) {
+			drifts = append(drifts, Drift{Venue: venue, Token: token, Expected: e, Actual: a, Drift: drift})
+		}
+	}
+
+	for venue, tokens := range actual {
+		for token := range tokens {
+			check(venue, token)
+		}
+	}
+	for venue, tokens := range expected {
+		for token := range tokens {
+			if _, ok := actual[venue][token]; !ok {
+				check(venue, token)
+			}
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].Venue != drifts[j].Venue {
+			return drifts[i].Venue < drifts[j].Venue
+		}
+		return drifts[i].Token < drifts[j].Token
+	})
+	return drifts
+}
@@ -0,0 +1,317 @@
+package risk
+
+import (
+	"fmt"
+	"rattrap/arbitrage-bot/internal/logging"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrHalted is returned while the kill switch is engaged
+	ErrHalted = fmt.Errorf("trading halted")
+	// ErrLimitExceeded is returned when a trade intent would break a risk limit
+	ErrLimitExceeded = fmt.Errorf("risk limit exceeded")
+)
+
+// Limits holds the hard risk limits. A zero limit is disabled.
+type Limits struct {
+	MaxNotional            decimal.Decimal            // Maximum notional of a trade, in the quote token
+	MaxTradesPerHour       int                        // Maximum trades over the last hour
+	MaxDailyLoss           decimal.Decimal            // Maximum realized loss per UTC day, in the quote token
+	MaxInventory           map[string]decimal.Decimal // Maximum open inventory per token
+	MaxDailyGas            decimal.Decimal            // Maximum gas spent per UTC day, in ETH
+	MaxConsecutiveFailures int                        // Failed legs in a row before trading is halted
+}
+
+// Intent is a trade about to be executed
+type Intent struct {
+	Notional decimal.Decimal            // Notional of the trade, in the quote token
+	Exposure map[string]decimal.Decimal // Inventory per token left open if only one leg goes through
+}
+
+// Trade is the outcome of an executed trade
+type Trade struct {
+	Time        time.Time                  // When the trade was executed, now if zero
+	RealizedPnL decimal.Decimal            // Realized PnL of the hedged part, in the quote token
+	Gas         decimal.Decimal            // Gas spent, in ETH
+	Inventory   map[string]decimal.Decimal // Change of open inventory per token
+}
+
+// Status is a snapshot of the risk state
+type Status struct {
+	Halted              bool
+	HaltReason          string
+	TradesLastHour      int
+	DailyRealizedPnL    decimal.Decimal
+	DailyGas            decimal.Decimal
+	Inventory           map[string]decimal.Decimal
+	ConsecutiveFailures int
+}
+
+// State is the part of the risk state persisted across restarts. The daily and hourly counters are rebuilt
+// from the trades executed instead.
+type State struct {
+	Halted              bool                       `json:"halted"`
+	HaltReason          string                     `json:"halt_reason,omitempty"`
+	ConsecutiveFailures int                        `json:"consecutive_failures"`
+	Inventory           map[string]decimal.Decimal `json:"inventory"`
+}
+
+// Manager enforces the risk limits before every trade intent
+type Manager struct {
+	limits              Limits
+	logger              *logrus.Entry
+	lock                sync.Mutex
+	day                 string
+	trades              []time.Time
+	dailyPnL            decimal.Decimal
+	dailyGas            decimal.Decimal
+	inventory           map[string]decimal.Decimal
+	consecutiveFailures int
+	halted              bool
+	haltReason          string
+	onHalt              func(reason string)
+	onChange            func(state State)
+}
+
+// NewManager initializes a new risk Manager
+func NewManager(tradingPair string, limits Limits, logger *logging.Logger) *Manager {
+	return &Manager{
+		limits:    limits,
+		logger:    logger.WithField("prefix", "risk").WithField("market", tradingPair),
+		inventory: make(map[string]decimal.Decimal),
+	}
+}
+
+// Check returns an error when intent must not be executed
+func (m *Manager) Check(intent Intent) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollover(time.Now())
+
+	if m.halted {
+		return fmt.Errorf("%w: %s", ErrHalted, m.haltReason)
+	}
+	if m.limits.MaxNotional.IsPositive() && intent.Notional.GreaterThan(m.limits.MaxNotional) {
+		return fmt.Errorf("%w: notional %s over %s", ErrLimitExceeded, intent.Notional.String(), m.limits.MaxNotional.String())
+	}
+	if m.limits.MaxTradesPerHour > 0 && len(m.trades) >= m.limits.MaxTradesPerHour {
+		return fmt.Errorf("%w: %d trades in the last hour", ErrLimitExceeded, len(m.trades))
+	}
+	if m.limits.MaxDailyLoss.IsPositive() && m.dailyPnL.Neg().GreaterThanOrEqual(m.limits.MaxDailyLoss) {
+		return fmt.Errorf("%w: daily realized loss %s reached %s", ErrLimitExceeded, m.dailyPnL.Neg().String(), m.limits.MaxDailyLoss.String())
+	}
+	if m.limits.MaxDailyGas.IsPositive() && m.dailyGas.GreaterThanOrEqual(m.limits.MaxDailyGas) {
+		return fmt.Errorf("%w: daily gas %s ETH reached %s ETH", ErrLimitExceeded, m.dailyGas.String(), m.limits.MaxDailyGas.String())
+	}
+	for token, max := range m.limits.MaxInventory {
+		if !max.IsPositive() {
+			continue
+		}
+		// Worst case the trade is left unhedged on top of the current inventory
+		open := m.inventory[token].Abs().Add(intent.Exposure[token].Abs())
+		if open.GreaterThan(max) {
+			return fmt.Errorf("%w: open %s inventory would reach %s, maximum %s", ErrLimitExceeded, token, open.String(), max.String())
+		}
+	}
+
+	return nil
+}
+
+// RecordTrade accounts for an executed trade
+func (m *Manager) RecordTrade(trade Trade) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollover(time.Now())
+
+	m.count(trade)
+	for token, delta := range trade.Inventory {
+		m.inventory[token] = m.inventory[token].Add(delta)
+	}
+	m.changed()
+}
+
+// Restore restores the state persisted by a previous run and counts the trades executed since the start of
+// the UTC day towards the hourly and daily limits. It is called before trading starts, the inventory of the
+// trades is already part of state.
+func (m *Manager) Restore(state State, trades []Trade) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	m.rollover(now)
+
+	m.halted, m.haltReason = state.Halted, state.HaltReason
+	m.consecutiveFailures = state.ConsecutiveFailures
+	m.inventory = make(map[string]decimal.Decimal, len(state.Inventory))
+	for token, amount := range state.Inventory {
+		m.inventory[token] = amount
+	}
+
+	sort.Slice(trades, func(i, j int) bool { return trades[i].Time.Before(trades[j].Time) })
+	for _, trade := range trades {
+		if trade.Time.UTC().Format("2006-01-02") == m.day {
+			m.count(trade)
+		}
+	}
+	m.rollover(now)
+
+	m.logger.Infof("Restored the risk state: daily realized PnL %s, daily gas %s ETH, %d trades in the last hour, inventory %v", m.dailyPnL.String(), m.dailyGas.String(), len(m.trades), m.inventory)
+	if m.halted {
+		m.logger.Errorf("Trading halted by a previous run: %s, waiting for an operator to resume", m.haltReason)
+	}
+}
+
+// RecordLeg accounts for the outcome of an executed leg and engages the kill switch after too many
+// consecutive failures
+func (m *Manager) RecordLeg(ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	defer m.changed()
+
+	if ok {
+		m.consecutiveFailures = 0
+		return
+	}
+
+	m.consecutiveFailures++
+	if m.limits.MaxConsecutiveFailures > 0 && m.consecutiveFailures >= m.limits.MaxConsecutiveFailures && !m.halted {
+		m.halt(fmt.Sprintf("%d consecutive failed legs", m.consecutiveFailures))
+	}
+}
+
+// OnHalt registers fn to be called whenever the kill switch is engaged. fn is called with the lock held and
+// must not call back into the Manager.
+func (m *Manager) OnHalt(fn func(reason string)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.onHalt = fn
+}
+
+// OnChange registers fn to be called with the state to persist whenever it changes. fn is called with the
+// lock held and must not call back into the Manager.
+func (m *Manager) OnChange(fn func(state State)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.onChange = fn
+}
+
+// Halt engages the kill switch
+func (m *Manager) Halt(reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.halt(reason)
+	m.changed()
+}
+
+// Resume releases the kill switch for reason, trading resumes with the next intent. It is refused while the
+// daily loss limit is still breached, trading would stop again on the next intent.
+func (m *Manager) Resume(reason string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollover(time.Now())
+
+	if !m.halted {
+		return nil
+	}
+	if m.limits.MaxDailyLoss.IsPositive() && m.dailyPnL.Neg().GreaterThanOrEqual(m.limits.MaxDailyLoss) {
+		return fmt.Errorf("%w: daily realized loss %s still at or over %s until the next UTC day", ErrLimitExceeded, m.dailyPnL.Neg().String(), m.limits.MaxDailyLoss.String())
+	}
+	m.logger.Warnf("Trading resumed by operator: %s, it was halted: %s", reason, m.haltReason)
+	m.halted = false
+	m.haltReason = ""
+	m.consecutiveFailures = 0
+	m.changed()
+	return nil
+}
+
+// SetLimits changes the risk limits, they apply from the next intent. The kill switch is left as it is.
+func (m *Manager) SetLimits(limits Limits) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.limits = limits
+	m.logger.Infof("Risk limits changed to %+v", limits)
+}
+
+// IsHalted returns whether the kill switch is engaged and why
+func (m *Manager) IsHalted() (bool, string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.halted, m.haltReason
+}
+
+// GetStatus returns a snapshot of the risk state
+func (m *Manager) GetStatus() Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollover(time.Now())
+
+	inventory := make(map[string]decimal.Decimal, len(m.inventory))
+	for token, amount := range m.inventory {
+		inventory[token] = amount
+	}
+
+	return Status{
+		Halted:              m.halted,
+		HaltReason:          m.haltReason,
+		TradesLastHour:      len(m.trades),
+		DailyRealizedPnL:    m.dailyPnL,
+		DailyGas:            m.dailyGas,
+		Inventory:           inventory,
+		ConsecutiveFailures: m.consecutiveFailures,
+	}
+}
+
+// halt engages the kill switch, the lock must be held
+func (m *Manager) halt(reason string) {
+	m.halted = true
+	m.haltReason = reason
+	m.logger.Errorf("Trading halted: %s, waiting for an operator to resume", reason)
+	if m.onHalt != nil {
+		m.onHalt(reason)
+	}
+}
+
+// count counts an executed trade towards the hourly and daily limits, the lock must be held
+func (m *Manager) count(trade Trade) {
+	at := trade.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	m.trades = append(m.trades, at)
+	m.dailyPnL = m.dailyPnL.Add(trade.RealizedPnL)
+	m.dailyGas = m.dailyGas.Add(trade.Gas)
+}
+
+// changed hands the state to persist to the OnChange callback, the lock must be held
+func (m *Manager) changed() {
+	if m.onChange == nil {
+		return
+	}
+	inventory := make(map[string]decimal.Decimal, len(m.inventory))
+	for token, amount := range m.inventory {
+		inventory[token] = amount
+	}
+	m.onChange(State{Halted: m.halted, HaltReason: m.haltReason, ConsecutiveFailures: m.consecutiveFailures, Inventory: inventory})
+}
+
+// rollover drops trades older than an hour and resets the daily counters on a new UTC day, the lock must be held
+func (m *Manager) rollover(now time.Time) {
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(m.trades) && m.trades[i].Before(cutoff) {
+		i++
+	}
+	m.trades = m.trades[i:]
+
+	day := now.UTC().Format("2006-01-02")
+	if day != m.day {
+		m.day = day
+		m.dailyPnL = decimal.Zero
+		m.dailyGas = decimal.Zero
+	}
+}
@@ -0,0 +1,97 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// redacted replaces the value of a secret in every output
+const redacted = "[REDACTED]"
+
+// ErrInsecurePermissions is returned when a file holding secrets is readable by other users
+var ErrInsecurePermissions = fmt.Errorf("secret file is world-readable")
+
+// Secret holds a sensitive value that redacts itself when formatted, logged or marshalled. Use Reveal
+// to read the value where it is actually needed.
+type Secret struct {
+	value string
+}
+
+// New wraps a sensitive value
+func New(value string) Secret {
+	return Secret{value: value}
+}
+
+// Reveal returns the sensitive value
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+// IsEmpty returns whether the secret is unset
+func (s Secret) IsEmpty() bool {
+	return s.value == ""
+}
+
+// String implements fmt.Stringer
+func (s Secret) String() string {
+	if s.value == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString implements fmt.GoStringer
+func (s Secret) GoString() string {
+	return fmt.Sprintf("secret.Secret(%q)", s.String())
+}
+
+// Format implements fmt.Formatter so that no verb prints the value
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		_, _ = f.Write([]byte(s.GoString()))
+		return
+	}
+	_, _ = f.Write([]byte(s.String()))
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// FromEnv reads a secret from the environment variable name, or from the file named by name_FILE
+// (e.g. a Docker or Kubernetes secret mount). Setting both is an error.
+func FromEnv(name string) (Secret, error) {
+	value, path := os.Getenv(name), os.Getenv(name+"_FILE")
+	if path == "" {
+		return New(value), nil
+	}
+	if value != "" {
+		return Secret{}, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+
+	if err := AuditFile(path); err != nil {
+		return Secret{}, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Secret{}, fmt.Errorf("Failed to read %s_FILE: %w", name, err)
+	}
+	return New(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// AuditFile fails if the file at path is readable by every user. A missing file passes the audit.
+func AuditFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		return fmt.Errorf("%w: %s has mode %s, run chmod o-rwx %s", ErrInsecurePermissions, path, info.Mode().Perm(), path)
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/health"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/metrics"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Restart backoff. The backoff doubles on every failure up to MaxBackoff, and is reset once a component
+// ran for StableAfter.
+const (
+	MinBackoff  = time.Second
+	MaxBackoff  = time.Minute
+	StableAfter = 5 * time.Minute
+)
+
+// Component states
+const (
+	StateWaiting  = "waiting"  // Waiting for its dependencies to start
+	StateStarting = "starting" // Start is failing and retried
+	StateRunning  = "running"
+	StateBackoff  = "backoff" // Run failed, waiting to be restarted
+	StateStopped  = "stopped"
+)
+
+// Component is a service run by the Supervisor. Every function is optional.
+type Component struct {
+	Name      string
+	DependsOn []string                        // Components started before this one, and stopped after it
+	Start     func(ctx context.Context) error // Initializes the component, retried with backoff until it succeeds
+	Run       func(ctx context.Context) error // Runs until ctx is cancelled, restarted with backoff if it returns or panics before
+	Stop      func()                          // Releases the component once Run returned
+}
+
+// Status is a snapshot of the state of a component
+type Status struct {
+	State     string
+	Since     time.Time
+	Restarts  int
+	LastError string
+}
+
+// service is a component and its supervision state
+type service struct {
+	Component
+	logger  *logrus.Entry
+	lock    sync.Mutex
+	status  Status
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started chan struct{} // Closed once Start succeeded
+	done    chan struct{} // Closed once the component stopped running
+}
+
+// Supervisor starts the components in dependency order, restarts them when they fail and stops them in
+// reverse order
+type Supervisor struct {
+	checker  *health.Checker
+	logger   *logrus.Entry
+	lock     sync.Mutex
+	services map[string]*service
+	pending  []*service // Added since the last Start
+	order    []*service // Launched, in start order
+}
+
+// NewSupervisor initializes a new Supervisor. The state of every component is reported by checker.
+func NewSupervisor(checker *health.Checker, logger *logging.Logger) *Supervisor {
+	return &Supervisor{
+		checker:  checker,
+		logger:   logger.WithField("prefix", "supervisor"),
+		services: make(map[string]*service),
+	}
+}
+
+// Add registers a component, started by the next call to Start
+func (s *Supervisor) Add(c Component) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &service{
+		Component: c,
+		logger:    s.logger.WithField("service", c.Name),
+		status:    Status{State: StateWaiting, Since: time.Now()},
+		ctx:       ctx,
+		cancel:    cancel,
+		started:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.services[c.Name] = svc
+	s.pending = append(s.pending, svc)
+
+	// A failing component takes the bot out of rotation, the Supervisor is in charge of restarting it
+	s.checker.RegisterDetailed("service_"+c.Name, health.Readiness, svc.check, svc.details)
+	metrics.SetServiceUp(c.Name, false)
+}
+
+// Start starts the components added since the last call, each once its dependencies have started. It
+// returns once they have all started, or fails if ctx is cancelled first. The components keep running
+// until Stop.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.lock.Lock()
+	order, err := s.sort(s.pending)
+	if err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	s.pending = nil
+	s.order = append(s.order, order...)
+
+	deps := make(map[string][]*service, len(order))
+	for _, svc := range order {
+		for _, name := range svc.DependsOn {
+			deps[svc.Name] = append(deps[svc.Name], s.services[name])
+		}
+	}
+	s.lock.Unlock()
+
+	for _, svc := range order {
+		go s.supervise(svc, deps[svc.Name])
+	}
+
+	for _, svc := range order {
+		select {
+		case <-svc.started:
+		case <-ctx.Done():
+			return fmt.Errorf("Interrupted while starting %s: %w", svc.Name, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Stop stops the components in reverse start order, waiting for each to return from Run. The Stop function
+// of a component is only called if it started. It fails if ctx expires first, the remaining components
+// are then cancelled without waiting.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.lock.Lock()
+	order := append([]*service(nil), s.order...)
+	s.lock.Unlock()
+
+	for i := len(order) - 1; i >= 0; i-- {
+		svc := order[i]
+		svc.cancel()
+
+		select {
+		case <-svc.done:
+		case <-ctx.Done():
+			for _, svc := range order[:i] {
+				svc.cancel()
+			}
+			return fmt.Errorf("Timed out stopping %s: %w", svc.Name, ctx.Err())
+		}
+
+		if svc.Stop != nil && svc.isStarted() {
+			if err := svc.call(func() error { svc.Stop(); return nil }); err != nil {
+				svc.logger.WithError(err).Error("Failed to stop service")
+			}
+		}
+		svc.logger.Debug("Service stopped")
+	}
+	return nil
+}
+
+// Remove stops the named components in reverse start order, like Stop, and forgets them. The components
+// depending on them must be removed with them. It fails if ctx expires first, the remaining components are
+// then cancelled without waiting, and forgotten all the same.
+func (s *Supervisor) Remove(ctx context.Context, names ...string) error {
+	isRemoved := func(svc *service) bool { return slices.Contains(names, svc.Name) }
+
+	// Components not launched yet have nothing to stop
+	s.lock.Lock()
+	var removed []*service
+	for _, svc := range s.order {
+		if isRemoved(svc) {
+			removed = append(removed, svc)
+		}
+	}
+	s.order = slices.DeleteFunc(s.order, isRemoved)
+	s.pending = slices.DeleteFunc(s.pending, isRemoved)
+	for _, name := range names {
+		delete(s.services, name)
+	}
+	s.lock.Unlock()
+
+	defer func() {
+		for _, name := range names {
+			s.checker.Unregister("service_" + name)
+			metrics.RemoveService(name)
+		}
+	}()
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		svc := removed[i]
+		svc.cancel()
+
+		select {
+		case <-svc.done:
+		case <-ctx.Done():
+			for _, svc := range removed[:i] {
+				svc.cancel()
+			}
+			return fmt.Errorf("Timed out removing %s: %w", svc.Name, ctx.Err())
+		}
+
+		if svc.Stop != nil && svc.isStarted() {
+			if err := svc.call(func() error { svc.Stop(); return nil }); err != nil {
+				svc.logger.WithError(err).Error("Failed to stop service")
+			}
+		}
+		svc.logger.Info("Service removed")
+	}
+	return nil
+}
+
+// Statuses returns the status of every component
+func (s *Supervisor) Statuses() map[string]Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make(map[string]Status, len(s.services))
+	for name, svc := range s.services {
+		statuses[name] = svc.getStatus()
+	}
+	return statuses
+}
+
+// sort orders services so that every one comes after its dependencies, the lock must be held
+func (s *Supervisor) sort(services []*service) ([]*service, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	order := make([]*service, 0, len(services))
+
+	var visit func(svc *service) error
+	visit = func(svc *service) error {
+		switch marks[svc.Name] {
+		case visiting:
+			return fmt.Errorf("Dependency cycle through %s", svc.Name)
+		case visited:
+			return nil
+		}
+		marks[svc.Name] = visiting
+		for _, name := range svc.DependsOn {
+			dep, ok := s.services[name]
+			if !ok {
+				return fmt.Errorf("Unknown dependency %s of %s", name, svc.Name)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[svc.Name] = visited
+
+		// Components launched by a previous Start are already in the order
+		for _, pending := range services {
+			if pending == svc {
+				order = append(order, svc)
+			}
+		}
+		return nil
+	}
+
+	for _, svc := range services {
+		if err := visit(svc); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// supervise starts svc once its dependencies have started, then runs it until it is stopped
+func (s *Supervisor) supervise(svc *service, deps []*service) {
+	defer close(svc.done)
+	defer metrics.SetServiceUp(svc.Name, false)
+	defer svc.setState(StateStopped)
+
+	for _, dep := range deps {
+		select {
+		case <-dep.started:
+		case <-svc.ctx.Done():
+			return
+		}
+	}
+
+	backoff := MinBackoff
+	if svc.Start != nil {
+		for {
+			err := svc.call(func() error { return svc.Start(svc.ctx) })
+			if err == nil {
+				break
+			}
+			if svc.ctx.Err() != nil {
+				return
+			}
+			svc.fail(StateStarting, err)
+			svc.logger.WithError(err).Errorf("Failed to start service, retrying in %s", backoff)
+			if !sleep(svc.ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, MaxBackoff)
+		}
+	}
+
+	svc.setState(StateRunning)
+	metrics.SetServiceUp(svc.Name, true)
+	svc.logger.Info("Service started")
+	close(svc.started)
+
+	if svc.Run == nil {
+		<-svc.ctx.Done()
+		return
+	}
+
+	backoff = MinBackoff
+	for {
+		runStart := time.Now()
+		err := svc.call(func() error { return svc.Run(svc.ctx) })
+		if svc.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("stopped unexpectedly")
+		}
+		if time.Since(runStart) >= StableAfter {
+			backoff = MinBackoff
+		}
+
+		restarts := svc.fail(StateBackoff, err)
+		metrics.SetServiceUp(svc.Name, false)
+		metrics.AddServiceRestart(svc.Name)
+		svc.logger.WithError(err).WithField("restarts", restarts).Errorf("Service failed, restarting in %s", backoff)
+		if !sleep(svc.ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, MaxBackoff)
+
+		svc.setState(StateRunning)
+		metrics.SetServiceUp(svc.Name, true)
+		svc.logger.WithField("restarts", restarts).Info("Service restarted")
+	}
+}
+
+// call runs fn, turning a panic into an error
+func (svc *service) call(fn func() error) error {
+	return Recover(svc.logger, fn)
+}
+
+// Recover runs fn, turning a panic into an error logged with its stack. Goroutines started outside of the
+// components, such as trade legs, run through it so that a panic fails their work instead of the bot.
+func Recover(logger *logrus.Entry, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Panicked: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// fail records a failure and returns the number of restarts, counting this one if the component was running
+func (svc *service) fail(state string, err error) int {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+
+	if state == StateBackoff {
+		svc.status.Restarts++
+	}
+	svc.status.State, svc.status.Since, svc.status.LastError = state, time.Now(), err.Error()
+	return svc.status.Restarts
+}
+
+// isStarted returns whether Start succeeded
+func (svc *service) isStarted() bool {
+	select {
+	case <-svc.started:
+		return true
+	default:
+		return false
+	}
+}
+
+func (svc *service) setState(state string) {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	svc.status.State, svc.status.Since = state, time.Now()
+}
+
+func (svc *service) getStatus() Status {
+	svc.lock.Lock()
+	defer svc.lock.Unlock()
+	return svc.status
+}
+
+// check implements health.Check, failing unless the component is running
+func (svc *service) check(ctx context.Context) error {
+	status := svc.getStatus()
+	if status.State == StateRunning {
+		return nil
+	}
+	if status.LastError != "" {
+		return fmt.Errorf("%s since %s: %s", status.State, status.Since.UTC().Format(time.RFC3339), status.LastError)
+	}
+	return fmt.Errorf("%s since %s", status.State, status.Since.UTC().Format(time.RFC3339))
+}
+
+// details implements health.Details
+func (svc *service) details() map[string]interface{} {
+	status := svc.getStatus()
+	details := map[string]interface{}{
+		"state":    status.State,
+		"since":    status.Since.UTC(),
+		"restarts": status.Restarts,
+	}
+	if status.LastError != "" {
+		details["last_error"] = status.LastError
+	}
+	return details
+}
+
+// sleep waits for d, or returns false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
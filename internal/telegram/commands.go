@@ -0,0 +1,212 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"rattrap/arbitrage-bot/internal/supervisor"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// ConfirmTimeout bounds how long a command waits for its /confirm
+const ConfirmTimeout = time.Minute
+
+// pollTimeout is the long polling timeout of getUpdates, in seconds
+const pollTimeout = 30
+
+// CommandHandler runs a command with its arguments and returns the reply
+type CommandHandler func(args []string) (string, error)
+
+// ConfirmedHandler validates the arguments of a command that must be confirmed. It returns a summary of
+// what will be done, and the action run once the operator confirms.
+type ConfirmedHandler func(args []string) (string, func() (string, error), error)
+
+type command struct {
+	usage   string
+	handler CommandHandler
+	confirm ConfirmedHandler
+}
+
+// pendingCommand is a command waiting for its confirmation
+type pendingCommand struct {
+	name    string
+	action  func() (string, error)
+	expires time.Time
+}
+
+// Handle registers a command, e.g. "status" for /status
+func (ts *TelegramService) Handle(name, usage string, handler CommandHandler) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.commands[name] = command{usage: usage, handler: handler}
+}
+
+// HandleConfirmed registers a command that only runs once the operator sends /confirm
+func (ts *TelegramService) HandleConfirmed(name, usage string, handler ConfirmedHandler) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.commands[name] = command{usage: usage, confirm: handler}
+}
+
+// SetAdmins sets the users allowed to send commands, there are none by default
+func (ts *TelegramService) SetAdmins(admins []int64) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.admins = make(map[int64]bool, len(admins))
+	for _, id := range admins {
+		ts.admins[id] = true
+	}
+}
+
+// RunCommands long polls the commands sent to the bot until ctx is cancelled. Only the users set with
+// SetAdmins may send commands. The offset moves past an update before its command runs, so a command that
+// crashes the loop is not received again once it is restarted.
+func (ts *TelegramService) RunCommands(ctx context.Context) error {
+	if !ts.isStarted {
+		return fmt.Errorf("Telegram is not connected")
+	}
+
+	config := tgbotapi.NewUpdate(ts.offset)
+	config.Timeout = pollTimeout
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		updates, err := ts.bot.GetUpdates(config)
+		if err != nil {
+			ts.logger.WithError(err).Warn("Failed to get Telegram updates, retrying in 3 seconds")
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(3 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			if update.UpdateID >= config.Offset {
+				config.Offset = update.UpdateID + 1
+				ts.offset = config.Offset
+			}
+			if update.Message != nil && update.Message.IsCommand() {
+				ts.dispatch(update.Message)
+			}
+		}
+	}
+}
+
+// dispatch runs the command of an authorized user
+func (ts *TelegramService) dispatch(msg *tgbotapi.Message) {
+	if msg.From == nil {
+		return
+	}
+	userID := int64(msg.From.ID)
+	name, args := msg.Command(), strings.Fields(msg.CommandArguments())
+	logger := ts.logger.WithField("user_id", userID)
+
+	ts.lock.Lock()
+	authorized := ts.admins[userID]
+	cmd, known := ts.commands[name]
+	ts.lock.Unlock()
+
+	if !authorized {
+		logger.Warnf("Ignored /%s from unauthorized user %s", name, msg.From.UserName)
+		return
+	}
+	logger.Infof("Received /%s %s from %s", name, strings.Join(args, " "), msg.From.UserName)
+
+	switch {
+	case name == "help":
+		ts.reply(msg, ts.help(), nil)
+	case name == "confirm":
+		ts.lock.Lock()
+		pending, ok := ts.pending[userID]
+		delete(ts.pending, userID)
+		ts.lock.Unlock()
+
+		if !ok || time.Now().After(pending.expires) {
+			ts.reply(msg, "Nothing to confirm", nil)
+			return
+		}
+		ts.reply(msg, fmt.Sprintf("/%s confirmed, running", pending.name), nil)
+		// Confirmed actions such as trades may take minutes, keep receiving commands meanwhile. A panic fails
+		// the action, not the bot.
+		go func() {
+			var result string
+			err := supervisor.Recover(ts.logger.WithField("command", pending.name), func() error {
+				var err error
+				result, err = pending.action()
+				return err
+			})
+			ts.reply(msg, result, err)
+		}()
+	case name == "cancel":
+		ts.lock.Lock()
+		_, ok := ts.pending[userID]
+		delete(ts.pending, userID)
+		ts.lock.Unlock()
+
+		if !ok {
+			ts.reply(msg, "Nothing to cancel", nil)
+			return
+		}
+		ts.reply(msg, "Cancelled", nil)
+	case !known:
+		ts.reply(msg, fmt.Sprintf("Unknown command /%s, see /help", name), nil)
+	case cmd.confirm != nil:
+		summary, action, err := cmd.confirm(args)
+		if err != nil {
+			ts.reply(msg, "", fmt.Errorf("%w, usage: %s", err, cmd.usage))
+			return
+		}
+
+		ts.lock.Lock()
+		ts.pending[userID] = pendingCommand{name: name, action: action, expires: time.Now().Add(ConfirmTimeout)}
+		ts.lock.Unlock()
+		ts.reply(msg, fmt.Sprintf("%s\nSend /confirm within %s to proceed, /cancel to abort", summary, ConfirmTimeout), nil)
+	default:
+		result, err := cmd.handler(args)
+		if err != nil {
+			err = fmt.Errorf("%w, usage: %s", err, cmd.usage)
+		}
+		ts.reply(msg, result, err)
+	}
+}
+
+// reply acknowledges a command in the configured chat, and in the chat it was sent from if different
+func (ts *TelegramService) reply(msg *tgbotapi.Message, result string, err error) {
+	text := fmt.Sprintf("%s: %s\n%s", msg.From.UserName, msg.Text, result)
+	if err != nil {
+		ts.logger.WithError(err).Warnf("Command %s failed", msg.Text)
+		text = fmt.Sprintf("%s: %s\nFailed: %s", msg.From.UserName, msg.Text, err.Error())
+	}
+
+	_ = ts.SendMessage(text)
+	if msg.Chat != nil && msg.Chat.ID != ts.chatID {
+		if _, err := ts.bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text)); err != nil {
+			ts.logger.WithError(err).Error("Failed to reply to Telegram command")
+		}
+	}
+}
+
+// help lists the registered commands
+func (ts *TelegramService) help() string {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	usages := make([]string, 0, len(ts.commands)+2)
+	for _, cmd := range ts.commands {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+	usages = append(usages, "/confirm", "/cancel")
+	return strings.Join(usages, "\n")
+}
@@ -1,10 +1,14 @@
 package telegram
 
 import (
-	"log"
+	"context"
+	"rattrap/arbitrage-bot/internal/logging"
+	"rattrap/arbitrage-bot/internal/notify"
 	"strings"
+	"sync"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/sirupsen/logrus"
 )
 
 // TelegramService is a struct to hold the bot API and the chat ID
@@ -12,10 +16,16 @@ type TelegramService struct {
 	isStarted bool
 	bot       *tgbotapi.BotAPI
 	chatID    int64
+	logger    *logrus.Entry
+	lock      sync.Mutex
+	commands  map[string]command
+	admins    map[int64]bool
+	pending   map[int64]pendingCommand // Commands waiting for confirmation, per user
+	offset    int                      // Next update to receive, kept across restarts of RunCommands
 }
 
 // NewTelegramService initializes a new TelegramService
-func NewTelegramService(token string, chatID int64) *TelegramService {
+func NewTelegramService(token string, chatID int64, logger *logging.Logger) *TelegramService {
 	isStarted := true
 	bot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
@@ -26,9 +36,18 @@ func NewTelegramService(token string, chatID int64) *TelegramService {
 		isStarted: isStarted,
 		bot:       bot,
 		chatID:    chatID,
+		logger:    logger.WithField("prefix", "telegram"),
+		commands:  make(map[string]command),
+		admins:    make(map[int64]bool),
+		pending:   make(map[int64]pendingCommand),
 	}
 }
 
+// IsStarted returns whether the bot connected to Telegram
+func (ts *TelegramService) IsStarted() bool {
+	return ts.isStarted
+}
+
 // SendMessage sends a message to the configured Telegram chat
 func (ts *TelegramService) SendMessage(message string) error {
 	if !ts.isStarted {
@@ -37,12 +56,22 @@ func (ts *TelegramService) SendMessage(message string) error {
 	msg := tgbotapi.NewMessage(ts.chatID, message)
 	_, err := ts.bot.Send(msg)
 	if err != nil {
-		log.Printf("Failed to send message to Telegram: %v", err)
+		ts.logger.WithError(err).Error("Failed to send message to Telegram")
 		return err
 	}
 	return nil
 }
 
+// Name implements notify.Notifier
+func (ts *TelegramService) Name() string {
+	return "telegram"
+}
+
+// Notify implements notify.Notifier, sending the notification to the configured Telegram chat
+func (ts *TelegramService) Notify(ctx context.Context, msg notify.Message) error {
+	return ts.SendMessage(msg.String())
+}
+
 // FormatMessage formats a message with the given arguments
 func FormatMessage(message string) string {
 	const replacement = "\n"
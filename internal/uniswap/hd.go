@@ -0,0 +1,82 @@
+package uniswap
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// DefaultHDPath is the first account of the default Ethereum derivation path
+const DefaultHDPath = "m/44'/60'/0'/0/0"
+
+// HDWallet holds the accounts derived from a BIP-39 mnemonic
+type HDWallet struct {
+	paths    []accounts.DerivationPath
+	accounts []*Wallet
+}
+
+// NewHDWallet derives one account per BIP-32 path from mnemonic. password is the optional BIP-39
+// passphrase.
+func NewHDWallet(mnemonic, password string, paths []string) (*HDWallet, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("At least one derivation path is required")
+	}
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, password)
+	if err != nil {
+		// The error may quote the mnemonic, keep it out of logs
+		return nil, fmt.Errorf("Invalid mnemonic")
+	}
+
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive the master key: %w", err)
+	}
+
+	w := &HDWallet{}
+	for _, p := range paths {
+		path, err := accounts.ParseDerivationPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid derivation path %s: %w", p, err)
+		}
+
+		key := master
+		for _, index := range path {
+			key, err = key.Derive(index)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to derive %s: %w", p, err)
+			}
+		}
+
+		privateKey, err := key.ECPrivKey()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive %s: %w", p, err)
+		}
+
+		w.paths = append(w.paths, path)
+		w.accounts = append(w.accounts, NewWallet(privateKey.ToECDSA()))
+	}
+
+	return w, nil
+}
+
+// Accounts returns the derived accounts, in the order of their paths
+func (w *HDWallet) Accounts() []*Wallet {
+	return append([]*Wallet(nil), w.accounts...)
+}
+
+// Account returns the account derived from the index-th path
+func (w *HDWallet) Account(index int) (*Wallet, error) {
+	if index < 0 || index >= len(w.accounts) {
+		return nil, fmt.Errorf("Account %d is not derived, %d paths are configured", index, len(w.accounts))
+	}
+	return w.accounts[index], nil
+}
+
+// Path returns the derivation path of the index-th account
+func (w *HDWallet) Path(index int) accounts.DerivationPath {
+	return w.paths[index]
+}
@@ -2,7 +2,6 @@ package uniswap
 
 import (
 	"context"
-	"fmt"
 	"math/big"
 	"sort"
 
@@ -12,6 +11,7 @@ import (
 	"github.com/daoleno/uniswapv3-sdk/constants"
 	"github.com/daoleno/uniswapv3-sdk/entities"
 	sdkutils "github.com/daoleno/uniswapv3-sdk/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -31,19 +31,21 @@ func PriceToSqrtPriceX96(price float64) *big.Int {
 	return sqrtPriceX96Int
 }
 
-// ConstructV3Pool constructs a Uniswap V3 pool from the given pool address.
-func ConstructV3Pool(client *ethclient.Client, poolAddress common.Address, tickLens *contracts.TickLensCaller, ctx context.Context) (*entities.Pool, error) {
+// ConstructV3Pool constructs a Uniswap V3 pool from the given pool address, as of blockNumber (nil for latest).
+func ConstructV3Pool(client *ethclient.Client, poolAddress common.Address, tickLens *contracts.TickLensCaller, blockNumber *big.Int, ctx context.Context) (*entities.Pool, error) {
 	contractPool, err := contracts.NewUniswapV3PoolCaller(poolAddress, client)
 	if err != nil {
 		return nil, err
 	}
 
-	token0Address, err := contractPool.Token0(nil)
+	opts := &bind.CallOpts{BlockNumber: blockNumber, Context: ctx}
+
+	token0Address, err := contractPool.Token0(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	token1Address, err := contractPool.Token1(nil)
+	token1Address, err := contractPool.Token1(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -58,23 +60,25 @@ func ConstructV3Pool(client *ethclient.Client, poolAddress common.Address, tickL
 		return nil, err
 	}
 
-	liquidity, err := contractPool.Liquidity(nil)
+	liquidity, err := contractPool.Liquidity(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	slot0, err := contractPool.Slot0(nil)
+	slot0, err := contractPool.Slot0(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	fee, err := contractPool.Fee(nil)
+	fee, err := contractPool.Fee(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	ticks, err := GetPoolTicks(client, fee, poolAddress, tickLens)
-	fmt.Printf("Pool %s has %d ticks\n", poolAddress.String(), len(ticks))
+	ticks, err := GetPoolTicks(client, fee, poolAddress, tickLens, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// create tick data provider
 	p, err := entities.NewTickListDataProvider(ticks, constants.TickSpacings[constants.FeeAmount(fee.Uint64())])
@@ -120,7 +124,7 @@ func GetTokenEntityFromAddress(client *ethclient.Client, tokenAddress common.Add
 }
 
 // GetPoolTicks get all ticks of a pool from TickLens smart-contract
-func GetPoolTicks(client *ethclient.Client, fee *big.Int, poolAddress common.Address, tickLens *contracts.TickLensCaller) ([]entities.Tick, error) {
+func GetPoolTicks(client *ethclient.Client, fee *big.Int, poolAddress common.Address, tickLens *contracts.TickLensCaller, opts *bind.CallOpts) ([]entities.Tick, error) {
 	tickSpace := getTickSpacing(float64(fee.Uint64()))
 
 	minWordIndex := sdkutils.MinTick / 256
@@ -136,7 +140,7 @@ func GetPoolTicks(client *ethclient.Client, fee *big.Int, poolAddress common.Add
 	var ticks []entities.Tick
 
 	for _, wordIndex := range wordIndexes {
-		populatedTicks, err := tickLens.GetPopulatedTicksInWord(nil, poolAddress, wordIndex)
+		populatedTicks, err := tickLens.GetPopulatedTicksInWord(opts, poolAddress, wordIndex)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,77 @@
+package uniswap
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// NonceManager tracks the next nonce of each account, so that transactions sent back to back do not
+// reuse a nonce the node has not seen as pending yet
+type NonceManager struct {
+	lock sync.Mutex
+	next map[common.Address]uint64
+	last map[common.Address]common.Hash // Last transaction sent, by account
+}
+
+// NewNonceManager initializes a new NonceManager
+func NewNonceManager() *NonceManager {
+	return &NonceManager{next: make(map[common.Address]uint64), last: make(map[common.Address]common.Hash)}
+}
+
+// Send builds the transaction of the next nonce of address and sends it. Reserving the nonce and sending
+// are a single step, so two transactions never get the same nonce, and the nonce only advances once the
+// node accepted the transaction.
+func (m *NonceManager) Send(ctx context.Context, client *ethclient.Client, address common.Address, build func(nonce uint64) (*types.Transaction, error)) (*types.Transaction, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	nonce, err := m.sync(ctx, client, address)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := build(nonce)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SendTransaction(ctx, tx); err != nil {
+		return tx, err
+	}
+	m.next[address], m.last[address] = nonce+1, tx.Hash()
+	return tx, nil
+}
+
+// sync returns the next nonce of address: the pending nonce of the node, or the nonce following the last
+// transaction sent while the node lags behind. Once the node no longer knows the last transaction sent,
+// e.g. it was dropped from the mempool, the pending nonce of the node is used again.
+func (m *NonceManager) sync(ctx context.Context, client *ethclient.Client, address common.Address) (uint64, error) {
+	pending, err := client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	if m.next[address] <= pending {
+		m.next[address] = pending
+		return pending, nil
+	}
+
+	_, _, err = client.TransactionByHash(ctx, m.last[address])
+	if err == ethereum.NotFound {
+		m.next[address] = pending
+		return pending, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return m.next[address], nil
+}
+
+// Get returns the next nonce tracked for address, 0 if no transaction was sent
+func (m *NonceManager) Get(address common.Address) uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.next[address]
+}
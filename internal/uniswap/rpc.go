@@ -0,0 +1,100 @@
+package uniswap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"rattrap/arbitrage-bot/internal/metrics"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// dialRPC connects to the Ethereum node. HTTP endpoints are instrumented per JSON-RPC method.
+func dialRPC(ctx context.Context, url string) (*ethclient.Client, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ethclient.DialContext(ctx, url)
+	}
+
+	client, err := rpc.DialOptions(ctx, url, rpc.WithHTTPClient(&http.Client{Transport: &rpcTransport{base: http.DefaultTransport}}))
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(client), nil
+}
+
+// rpcTransport records the latency and errors of JSON-RPC requests
+type rpcTransport struct {
+	base http.RoundTripper
+}
+
+// rpcMessage is the part of a JSON-RPC request or response we look at
+type rpcMessage struct {
+	Method string          `json:"method"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (t *rpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := "unknown"
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		method = rpcMethod(body)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		metrics.ObserveRPC(method, start, true)
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	metrics.ObserveRPC(method, start, err != nil || resp.StatusCode >= 400 || rpcFailed(body))
+	return resp, err
+}
+
+// rpcMethod returns the method of a JSON-RPC request, "batch" for batches
+func rpcMethod(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return "batch"
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Method == "" {
+		return "unknown"
+	}
+	return msg.Method
+}
+
+// rpcFailed returns whether a JSON-RPC response, or any response of a batch, is an error
+func rpcFailed(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var msgs []rpcMessage
+		if err := json.Unmarshal(body, &msgs); err != nil {
+			return true
+		}
+		for _, msg := range msgs {
+			if len(msg.Error) > 0 && string(msg.Error) != "null" {
+				return true
+			}
+		}
+		return false
+	}
+	var msg rpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return true
+	}
+	return len(msg.Error) > 0 && string(msg.Error) != "null"
+}
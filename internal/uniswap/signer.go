@@ -0,0 +1,81 @@
+package uniswap
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/external"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Signer signs the transactions of a single account
+type Signer interface {
+	// Address returns the account transactions are sent from
+	Address() common.Address
+	// SignTx returns the transaction signed for chainID
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// NewKeystoreSigner decrypts a go-ethereum JSON keystore file with passphrase
+func NewKeystoreSigner(path, passphrase string) (*Wallet, error) {
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read keystore %s: %w", path, err)
+	}
+
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt keystore %s: %w", path, err)
+	}
+
+	return NewWallet(key.PrivateKey), nil
+}
+
+// ExternalSigner signs with an external signer such as Clef, over its account_signTransaction JSON-RPC
+// API. The key never enters the bot.
+type ExternalSigner struct {
+	signer  *external.ExternalSigner
+	account accounts.Account
+}
+
+// NewExternalSigner connects to the external signer at url. Transactions are sent from address, or from
+// the first account of the signer if address is the zero address.
+func NewExternalSigner(url string, address common.Address) (*ExternalSigner, error) {
+	signer, err := external.NewExternalSigner(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to the external signer: %w", err)
+	}
+
+	list := signer.Accounts()
+	if len(list) == 0 {
+		return nil, fmt.Errorf("The external signer has no account")
+	}
+
+	account := list[0]
+	if address != (common.Address{}) {
+		account = accounts.Account{URL: list[0].URL, Address: address}
+		if !signer.Contains(account) {
+			return nil, fmt.Errorf("The external signer does not manage %s", address.Hex())
+		}
+	}
+
+	return &ExternalSigner{signer: signer, account: account}, nil
+}
+
+// Address returns the account of the signer
+func (s *ExternalSigner) Address() common.Address {
+	return s.account.Address
+}
+
+// SignTx asks the external signer to sign the transaction
+func (s *ExternalSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signed, err := s.signer.SignTx(s.account, tx, chainID)
+	if err != nil {
+		return nil, fmt.Errorf("External signer refused to sign: %w", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,205 @@
+package uniswap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	coreentities "github.com/daoleno/uniswap-sdk-core/entities"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+)
+
+// simulationTimeout bounds the eth_call and gas estimation of a simulation
+const simulationTimeout = 30 * time.Second
+
+// ErrSimulationDiverged is returned when the simulated output is too far from the local pool estimate
+var ErrSimulationDiverged = fmt.Errorf("simulated swap output diverges from the local estimate")
+
+// routerABI describes the router methods whose results are decoded after a simulation
+var routerABI, _ = abi.JSON(strings.NewReader(`[
+	{"name":"multicall","type":"function","inputs":[{"name":"data","type":"bytes[]"}],"outputs":[{"name":"results","type":"bytes[]"}]},
+	{"name":"exactInputSingle","type":"function","inputs":[{"name":"params","type":"tuple","components":[
+		{"name":"tokenIn","type":"address"},{"name":"tokenOut","type":"address"},{"name":"fee","type":"uint24"},
+		{"name":"recipient","type":"address"},{"name":"deadline","type":"uint256"},{"name":"amountIn","type":"uint256"},
+		{"name":"amountOutMinimum","type":"uint256"},{"name":"sqrtPriceLimitX96","type":"uint160"}]}],
+	"outputs":[{"name":"amountOut","type":"uint256"}]}
+]`))
+
+// StorageSlots is the storage layout of an ERC20 token, used to override balances and allowances
+type StorageSlots struct {
+	Balance   int64 // Slot of the balanceOf mapping
+	Allowance int64 // Slot of the allowance mapping
+}
+
+// SimulationConfig controls how swaps are simulated before being sent
+type SimulationConfig struct {
+	MaxDivergenceBps int64                           // Maximum divergence between simulated and estimated output
+	StateOverride    bool                            // Override wallet balances and allowances in paper mode
+	StorageSlots     map[common.Address]StorageSlots // Token storage layouts used for state overrides
+}
+
+// Simulation is the outcome of an eth_call of a swap
+type Simulation struct {
+	BlockNumber   *big.Int                     // Block the swap was simulated at
+	AmountOut     *coreentities.CurrencyAmount // Exact output returned by the router
+	Expected      *coreentities.CurrencyAmount // Output estimated by the local pool model
+	GasUsed       uint64                       // Gas estimated for the swap
+	DivergenceBps int64                        // Difference between AmountOut and Expected in basis points
+	Overridden    bool                         // Whether balances and allowances were overridden
+}
+
+// Simulate runs the router calldata with eth_call at block, the block of the pool snapshot the swap was built
+// from. With override set, the wallet is credited with enough ETH, input token balance and router allowance
+// for the call to go through.
+func (c *UniswapClient) Simulate(block *big.Int, router common.Address, value *big.Int, calldata []byte, amountIn, expected *coreentities.CurrencyAmount, override bool) (*Simulation, error) {
+	msg := ethereum.CallMsg{
+		From:  c.signer.Address(),
+		To:    &router,
+		Value: value,
+		Data:  calldata,
+	}
+
+	var overrides *map[common.Address]gethclient.OverrideAccount
+	if override {
+		o, err := c.stateOverrides(router, amountIn)
+		if err != nil {
+			return nil, err
+		}
+		overrides = &o
+	}
+
+	ctx, cancel := context.WithTimeout(c.context, simulationTimeout)
+	defer cancel()
+
+	result, err := gethclient.New(c.client.Client()).CallContract(ctx, msg, block, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("Swap simulation failed: %w", err)
+	}
+
+	amountOut, err := decodeAmountOut(calldata, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// Not every node accepts state overrides for eth_estimateGas, only send them when needed
+	args := []interface{}{toCallArg(msg), hexutil.EncodeBig(block)}
+	if overrides != nil {
+		args = append(args, overrides)
+	}
+	var gas hexutil.Uint64
+	err = c.client.Client().CallContext(ctx, &gas, "eth_estimateGas", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Swap gas estimation failed: %w", err)
+	}
+
+	return &Simulation{
+		BlockNumber:   block,
+		AmountOut:     coreentities.FromRawAmount(expected.Currency, amountOut),
+		Expected:      expected,
+		GasUsed:       uint64(gas),
+		DivergenceBps: divergenceBps(amountOut, expected.Quotient()),
+		Overridden:    override,
+	}, nil
+}
+
+// stateOverrides credits the wallet with ETH and, for token inputs, with balance and router allowance
+func (c *UniswapClient) stateOverrides(router common.Address, amountIn *coreentities.CurrencyAmount) (map[common.Address]gethclient.OverrideAccount, error) {
+	overrides := map[common.Address]gethclient.OverrideAccount{
+		c.signer.Address(): {Balance: new(big.Int).Add(amountIn.Quotient(), big.NewInt(1e18))},
+	}
+	if amountIn.Currency.IsNative() {
+		return overrides, nil
+	}
+
+	token := amountIn.Currency.Wrapped().Address
+	slots, ok := c.simulation.StorageSlots[token]
+	if !ok {
+		return nil, fmt.Errorf("No storage slots configured for token %s", token.String())
+	}
+
+	amount := common.BigToHash(coreentities.MaxUint256)
+	overrides[token] = gethclient.OverrideAccount{
+		StateDiff: map[common.Hash]common.Hash{
+			mappingSlot(c.signer.Address(), big.NewInt(slots.Balance)):                              amount,
+			mappingSlot(router, mappingSlot(c.signer.Address(), big.NewInt(slots.Allowance)).Big()): amount,
+		},
+	}
+	return overrides, nil
+}
+
+// mappingSlot returns the storage slot of key in a Solidity mapping stored at slot
+func mappingSlot(key common.Address, slot *big.Int) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(key.Bytes(), 32), common.LeftPadBytes(slot.Bytes(), 32))
+}
+
+// decodeAmountOut extracts the swap output from the router return data
+func decodeAmountOut(calldata, result []byte) (*big.Int, error) {
+	if len(calldata) >= 4 && bytes.Equal(calldata[:4], routerABI.Methods["multicall"].ID) {
+		values, err := routerABI.Unpack("multicall", result)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode multicall result: %w", err)
+		}
+		results := values[0].([][]byte)
+
+		// The swap may be preceded by a permit and followed by unwrapWETH9, sweepToken or refundETH
+		index, err := swapCallIndex(calldata)
+		if err != nil {
+			return nil, err
+		}
+		if index >= len(results) {
+			return nil, fmt.Errorf("Multicall result has %d entries, the swap is call %d", len(results), index)
+		}
+		result = results[index]
+	}
+
+	values, err := routerABI.Unpack("exactInputSingle", result)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode swap result: %w", err)
+	}
+	return values[0].(*big.Int), nil
+}
+
+// swapCallIndex returns the index of the exactInputSingle call in the multicall calldata
+func swapCallIndex(calldata []byte) (int, error) {
+	values, err := routerABI.Methods["multicall"].Inputs.Unpack(calldata[4:])
+	if err != nil {
+		return 0, fmt.Errorf("Failed to decode multicall calldata: %w", err)
+	}
+	for i, call := range values[0].([][]byte) {
+		if len(call) >= 4 && bytes.Equal(call[:4], routerABI.Methods["exactInputSingle"].ID) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("No exactInputSingle call in the multicall")
+}
+
+// divergenceBps returns the absolute difference between actual and expected, in basis points of expected
+func divergenceBps(actual, expected *big.Int) int64 {
+	if expected.Sign() == 0 {
+		return 0
+	}
+	diff := new(big.Int).Sub(actual, expected)
+	diff.Abs(diff).Mul(diff, big.NewInt(10000)).Quo(diff, expected)
+	return diff.Int64()
+}
+
+// toCallArg converts a call message to the JSON-RPC call object
+func toCallArg(msg ethereum.CallMsg) map[string]interface{} {
+	arg := map[string]interface{}{
+		"from":  msg.From,
+		"to":    msg.To,
+		"input": hexutil.Bytes(msg.Data),
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	return arg
+}
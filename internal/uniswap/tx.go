@@ -4,31 +4,40 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// ReceiptTimeout bounds how long we wait for a sent transaction to be mined
+const ReceiptTimeout = 5 * time.Minute
+
+// ErrTxFailed is returned when a mined transaction reverted
+var ErrTxFailed = fmt.Errorf("transaction reverted")
+
+// transferTopic is the topic of the ERC20 Transfer(address,address,uint256) event
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
 // SendTx Send a real transaction to the blockchain.
-func SendTX(client *ethclient.Client, toAddress common.Address, value *big.Int, data []byte, w *Wallet) (*types.Transaction, error) {
-	signedTx, err := TryTX(client, toAddress, value, data, w)
-	if err != nil {
-		return nil, err
-	}
-	return signedTx, client.SendTransaction(context.Background(), signedTx)
+func SendTX(client *ethclient.Client, toAddress common.Address, value *big.Int, data []byte, signer Signer, nonces *NonceManager) (*types.Transaction, error) {
+	return nonces.Send(context.Background(), client, signer.Address(), func(nonce uint64) (*types.Transaction, error) {
+		return TryTX(client, toAddress, value, data, signer, nonce)
+	})
 }
 
 // Trytx Trying to send a transaction, it just return the transaction hash if success.
-func TryTX(client *ethclient.Client, toAddress common.Address, value *big.Int, data []byte, w *Wallet) (*types.Transaction, error) {
+func TryTX(client *ethclient.Client, toAddress common.Address, value *big.Int, data []byte, signer Signer, nonce uint64) (*types.Transaction, error) {
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
 	gasLimit, err := client.EstimateGas(context.Background(), ethereum.CallMsg{
-		From:     w.PublicKey,
+		From:     signer.Address(),
 		To:       &toAddress,
 		GasPrice: gasPrice,
 		Value:    value,
@@ -38,22 +47,79 @@ func TryTX(client *ethclient.Client, toAddress common.Address, value *big.Int, d
 		return nil, err
 	}
 
-	fmt.Printf("gasLimit=%d,  gasPrice=%d\n", gasLimit, gasPrice.Uint64())
-	nounc, err := client.NonceAt(context.Background(), w.PublicKey, nil)
-	if err != nil {
-		return nil, err
-	}
-	tx := types.NewTransaction(nounc, toAddress, value, gasLimit, gasPrice, data)
+	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
 
 	chainID, err := client.NetworkID(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), w.PrivateKey)
+	signedTx, err := signer.SignTx(tx, chainID)
 	if err != nil {
 		return nil, err
 	}
 
 	return signedTx, nil
 }
+
+// WaitForReceipt waits until the transaction is mined and returns its receipt. A reverted transaction
+// returns its receipt along with ErrTxFailed. It gives up after ReceiptTimeout or once ctx expires.
+func (c *UniswapClient) WaitForReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, ReceiptTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		receipt, err := c.client.TransactionReceipt(ctx, hash)
+		if err == nil {
+			if receipt.Status != types.ReceiptStatusSuccessful {
+				return receipt, fmt.Errorf("%w: %s", ErrTxFailed, hash.String())
+			}
+			return receipt, nil
+		}
+		if err != ethereum.NotFound {
+			return nil, fmt.Errorf("Failed to get receipt of %s: %w", hash.String(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Transaction %s not mined yet: %w", hash.String(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Receipt returns the receipt of a transaction, or nil if it is not mined yet
+func (c *UniswapClient) Receipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, err := c.client.TransactionReceipt(ctx, hash)
+	if err == ethereum.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get receipt of %s: %w", hash.String(), err)
+	}
+	return receipt, nil
+}
+
+// SwapOutput returns the amount of token the pool paid out in a mined swap, read from the Transfer events
+// of token sent by the pool. The pool pays the recipient, or the router when it unwraps WETH.
+func (c *UniswapClient) SwapOutput(receipt *types.Receipt, token common.Address) (*big.Int, error) {
+	amount := new(big.Int)
+	found := false
+	for _, log := range receipt.Logs {
+		if log.Address != token || len(log.Topics) != 3 || log.Topics[0] != transferTopic {
+			continue
+		}
+		if common.BytesToAddress(log.Topics[1].Bytes()) != c.uniswapPoolAddress {
+			continue
+		}
+		amount.Add(amount, new(big.Int).SetBytes(log.Data))
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("No transfer of %s from the pool in transaction %s", token.Hex(), receipt.TxHash.String())
+	}
+	return amount, nil
+}
@@ -6,8 +6,10 @@ import (
 	"math"
 	"math/big"
 	"strconv"
+	"sync"
 	"time"
 
+	"rattrap/arbitrage-bot/internal/logging"
 	"rattrap/arbitrage-bot/internal/uniswap/contracts"
 	"rattrap/arbitrage-bot/internal/utils"
 
@@ -17,34 +19,72 @@ import (
 	"github.com/daoleno/uniswapv3-sdk/periphery"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 )
 
+// Slippage modes used to derive amountOutMinimum
+const (
+	SlippageFixed  = "fixed"  // fixed tolerance in basis points
+	SlippageImpact = "impact" // price impact of the trade plus a tolerance buffer
+	SlippageHedge  = "hedge"  // explicit minimum output derived from the hedge price
+)
+
+// ErrUnprofitable is returned when the pool cannot deliver the minimum output required by the hedge
+var ErrUnprofitable = fmt.Errorf("swap output is below the hedge minimum")
+
+// TradeOptions controls the protection applied to a swap
+type TradeOptions struct {
+	SlippageMode string          // One of SlippageFixed, SlippageImpact or SlippageHedge
+	SlippageBps  int64           // Slippage tolerance, or buffer on top of the price impact, in basis points
+	Deadline     time.Duration   // How long the swap stays valid once signed
+	MinAmountOut decimal.Decimal // Minimum output in token units, required in hedge mode
+}
+
+// SwapResult describes a swap sent (or simulated in paper mode) to the router
+type SwapResult struct {
+	AmountIn     *coreentities.CurrencyAmount // Exact input of the swap
+	ExpectedOut  *coreentities.CurrencyAmount // Output estimated by the local pool model
+	MinAmountOut *coreentities.CurrencyAmount // amountOutMinimum enforced by the router
+	Simulation   *Simulation                  // eth_call simulation of the swap
+	TxHash       common.Hash                  // Hash of the sent transaction, empty in paper mode
+	GasPrice     *big.Int                     // Gas price of the sent transaction, nil in paper mode
+}
+
+// ErrInsufficientEth is returned when a native ETH swap would eat into the gas reserve
+var ErrInsufficientEth = fmt.Errorf("insufficient ETH balance above the gas reserve")
+
 // UniswapClient represents a client to interact with Uniswap
 type UniswapClient struct {
 	client             *ethclient.Client
-	wallet             *Wallet
+	signer             Signer
+	nonces             *NonceManager
 	context            context.Context
 	uniswapPoolAddress common.Address
 	ticklens           *contracts.TickLensCaller
-	pool               *entities.Pool
 	tradingPair        string
 	token0             string
 	token1             string
+	nativeETH          bool     // swap from/to native ETH instead of WETH
+	ethGasReserve      *big.Int // ETH (wei) kept aside for gas, never traded away
+	simulation         SimulationConfig
+	logger             *logrus.Entry
+	snapshotLock       sync.RWMutex   // guards pool, snapshotBlock and snapshotTime
+	pool               *entities.Pool // pool snapshot, replaced as a whole and never modified
+	snapshotBlock      *big.Int       // block the pool snapshot was taken at
+	snapshotTime       time.Time      // when the pool snapshot was last found current
 }
 
 // NewUniswapClient initializes a new Uniswap client
-func NewUniswapClient(tradingPair, ethereumRPCUrl string, uniswapPoolAddress, uniswapTickLensAddress common.Address, ethereumPrivateKey string, ctx context.Context) (error, *UniswapClient) {
-	client, err := ethclient.Dial(ethereumRPCUrl)
+func NewUniswapClient(tradingPair, ethereumRPCUrl string, uniswapPoolAddress, uniswapTickLensAddress common.Address, signer Signer, nonces *NonceManager, nativeETH bool, ethGasReserve *big.Int, simulation SimulationConfig, logger *logging.Logger, ctx context.Context) (error, *UniswapClient) {
+	client, err := dialRPC(ctx, ethereumRPCUrl)
 	if err != nil {
 		return fmt.Errorf("Failed to connect to the Ethereum client"), nil
 	}
-	defer client.Close()
 
-	wallet := InitWallet(ethereumPrivateKey)
-	if wallet == nil {
-		return fmt.Errorf("Failed to initialize the wallet"), nil
+	if signer == nil {
+		return fmt.Errorf("A signer is required"), nil
 	}
 
 	ticklens, err := contracts.NewTickLensCaller(uniswapTickLensAddress, client)
@@ -52,29 +92,128 @@ func NewUniswapClient(tradingPair, ethereumRPCUrl string, uniswapPoolAddress, un
 		return fmt.Errorf("Failed to connect to the TickLens"), nil
 	}
 
-	pool, err := ConstructV3Pool(client, uniswapPoolAddress, ticklens, ctx)
-	if err != nil {
-		return fmt.Errorf("Failed to connect to the Uniswap V3 pool"), nil
+	if ethGasReserve == nil {
+		ethGasReserve = big.NewInt(0)
+	}
+
+	// Clients sending from the same account share a NonceManager, so their transactions never reuse a nonce
+	if nonces == nil {
+		nonces = NewNonceManager()
 	}
 
 	token0, token1 := utils.GetTokensFromTradingPair(tradingPair)
 
-	return nil, &UniswapClient{
+	c := &UniswapClient{
 		client:             client,
-		wallet:             wallet,
+		signer:             signer,
+		nonces:             nonces,
 		context:            ctx,
 		uniswapPoolAddress: uniswapPoolAddress,
 		ticklens:           ticklens,
-		pool:               pool,
 		tradingPair:        tradingPair,
 		token0:             token0,
 		token1:             token1,
+		nativeETH:          nativeETH,
+		ethGasReserve:      ethGasReserve,
+		simulation:         simulation,
+		logger:             logger.WithField("prefix", "uniswap").WithField("market", tradingPair),
+	}
+
+	if err := c.loadPool(); err != nil {
+		return fmt.Errorf("Failed to connect to the Uniswap V3 pool"), nil
 	}
+
+	if nativeETH {
+		pool := c.getPool()
+		weth := coreentities.WETH9[pool.ChainID()]
+		if weth == nil || !pool.InvolvesToken(weth) {
+			return fmt.Errorf("Native ETH trading requires a WETH pool"), nil
+		}
+	}
+
+	return nil, c
+}
+
+// loadPool takes a snapshot of the pool at the latest block. The pool only changes with new blocks, the
+// snapshot is kept until a block is mined.
+func (c *UniswapClient) loadPool() error {
+	blockNumber, err := c.client.BlockNumber(c.context)
+	if err != nil {
+		return err
+	}
+	block := new(big.Int).SetUint64(blockNumber)
+
+	if _, current := c.getSnapshot(); current != nil && block.Cmp(current) <= 0 {
+		c.snapshotLock.Lock()
+		c.snapshotTime = time.Now()
+		c.snapshotLock.Unlock()
+		return nil
+	}
+
+	pool, err := ConstructV3Pool(c.client, c.uniswapPoolAddress, c.ticklens, block, c.context)
+	if err != nil {
+		return err
+	}
+
+	c.snapshotLock.Lock()
+	defer c.snapshotLock.Unlock()
+	// A concurrent refresh may have loaded a later block meanwhile
+	if c.snapshotBlock != nil && block.Cmp(c.snapshotBlock) <= 0 {
+		return nil
+	}
+	c.pool = pool
+	c.snapshotBlock = block
+	c.snapshotTime = time.Now()
+	c.logger.Debugf("Loaded pool snapshot at block %s", block.String())
+	return nil
+}
+
+// getPool returns the current pool snapshot
+func (c *UniswapClient) getPool() *entities.Pool {
+	c.snapshotLock.RLock()
+	defer c.snapshotLock.RUnlock()
+	return c.pool
+}
+
+// getSnapshot returns the current pool snapshot and the block it was taken at
+func (c *UniswapClient) getSnapshot() (*entities.Pool, *big.Int) {
+	c.snapshotLock.RLock()
+	defer c.snapshotLock.RUnlock()
+	return c.pool, c.snapshotBlock
+}
+
+// Refresh takes a new snapshot of the pool if a block was mined since the current one
+func (c *UniswapClient) Refresh() error {
+	return c.loadPool()
+}
+
+// Ping checks that the Ethereum node answers
+func (c *UniswapClient) Ping(ctx context.Context) error {
+	_, err := c.client.BlockNumber(ctx)
+	return err
+}
+
+// Address returns the account transactions are sent from
+func (c *UniswapClient) Address() common.Address {
+	return c.signer.Address()
+}
+
+// GetNonce returns the next nonce of the account, as tracked from the transactions sent
+func (c *UniswapClient) GetNonce() uint64 {
+	return c.nonces.Get(c.signer.Address())
+}
+
+// GetSnapshot returns the block and time of the current pool snapshot
+func (c *UniswapClient) GetSnapshot() (*big.Int, time.Time) {
+	c.snapshotLock.RLock()
+	defer c.snapshotLock.RUnlock()
+	return c.snapshotBlock, c.snapshotTime
 }
 
 // GetPrice returns the current price of a token on Uniswap
 func (c *UniswapClient) GetPrice() (float64, error) {
-	price, err := c.pool.PriceOf(c.pool.Token0)
+	pool := c.getPool()
+	price, err := pool.PriceOf(pool.Token0)
 	if err != nil {
 		return 0, err
 	}
@@ -87,14 +226,68 @@ func (c *UniswapClient) GetPrice() (float64, error) {
 	return priceFloat, nil
 }
 
+// GetTokens returns the tokens of the pool
+func (c *UniswapClient) GetTokens() (*coreentities.Token, *coreentities.Token) {
+	pool := c.getPool()
+	return pool.Token0, pool.Token1
+}
+
+// GetFee returns the pool fee as a fraction of the swap input
+func (c *UniswapClient) GetFee() decimal.Decimal {
+	return decimal.New(int64(c.getPool().Fee), -6)
+}
+
+// Quote returns the output of swapping amount according to the local pool model, pool fee included
+func (c *UniswapClient) Quote(amount *coreentities.CurrencyAmount) (*coreentities.CurrencyAmount, error) {
+	output, _, err := c.getPool().GetOutputAmount(amount.Wrapped(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// QuoteInput returns the input needed to get output out of a swap according to the local pool model, pool
+// fee included
+func (c *UniswapClient) QuoteInput(output *coreentities.CurrencyAmount) (*coreentities.CurrencyAmount, error) {
+	input, _, err := c.getPool().GetInputAmount(output.Wrapped(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// SuggestGasPrice returns the current gas price suggested by the node
+func (c *UniswapClient) SuggestGasPrice() (*big.Int, error) {
+	return c.client.SuggestGasPrice(c.context)
+}
+
 func (c *UniswapClient) GetEthBalance() (*coreentities.CurrencyAmount, error) {
-	balance, err := c.client.BalanceAt(c.context, c.wallet.PublicKey, nil)
+	balance, err := c.client.BalanceAt(c.context, c.signer.Address(), nil)
 	if err != nil {
 		return nil, err
 	}
 	return coreentities.FromRawAmount(coreentities.EtherOnChain(1), balance), nil
 }
 
+// GasReserve returns the ETH kept aside for gas
+func (c *UniswapClient) GasReserve() decimal.Decimal {
+	return decimal.NewFromBigInt(c.ethGasReserve, -18)
+}
+
+// GetSpendableEthBalance returns the ETH balance minus the gas reserve
+func (c *UniswapClient) GetSpendableEthBalance() (*coreentities.CurrencyAmount, error) {
+	balance, err := c.GetEthBalance()
+	if err != nil {
+		return nil, err
+	}
+
+	spendable := new(big.Int).Sub(balance.Quotient(), c.ethGasReserve)
+	if spendable.Sign() < 0 {
+		spendable = big.NewInt(0)
+	}
+	return coreentities.FromRawAmount(balance.Currency, spendable), nil
+}
+
 // BalanceOf returns the balance of a token in the wallet
 func (c *UniswapClient) BalanceOf(token *coreentities.Token) (*coreentities.CurrencyAmount, error) {
 	tokenContract, err := contracts.NewERC20Caller(token.Address, c.client)
@@ -102,7 +295,7 @@ func (c *UniswapClient) BalanceOf(token *coreentities.Token) (*coreentities.Curr
 		return nil, err
 	}
 
-	balance, err := tokenContract.BalanceOf(nil, c.wallet.PublicKey)
+	balance, err := tokenContract.BalanceOf(nil, c.signer.Address())
 	if err != nil {
 		return nil, err
 	}
@@ -112,15 +305,16 @@ func (c *UniswapClient) BalanceOf(token *coreentities.Token) (*coreentities.Curr
 
 // GetBalances returns the balances of the wallet
 func (c *UniswapClient) GetBalances() (*coreentities.CurrencyAmount, *coreentities.CurrencyAmount, error) {
+	pool := c.getPool()
 
-	token0Balance, err := c.BalanceOf(c.pool.Token0)
+	token0Balance, err := c.BalanceOf(pool.Token0)
 	if err != nil {
-		return coreentities.FromRawAmount(c.pool.Token0, big.NewInt(0)), coreentities.FromRawAmount(c.pool.Token1, big.NewInt(0)), err
+		return coreentities.FromRawAmount(pool.Token0, big.NewInt(0)), coreentities.FromRawAmount(pool.Token1, big.NewInt(0)), err
 	}
 
-	token1Balance, err := c.BalanceOf(c.pool.Token1)
+	token1Balance, err := c.BalanceOf(pool.Token1)
 	if err != nil {
-		return coreentities.FromRawAmount(c.pool.Token0, big.NewInt(0)), coreentities.FromRawAmount(c.pool.Token1, big.NewInt(0)), err
+		return coreentities.FromRawAmount(pool.Token0, big.NewInt(0)), coreentities.FromRawAmount(pool.Token1, big.NewInt(0)), err
 	}
 
 	return token0Balance, token1Balance, nil
@@ -128,8 +322,9 @@ func (c *UniswapClient) GetBalances() (*coreentities.CurrencyAmount, *coreentiti
 
 // TargetPriceToSqrtPriceX96 converts a target price to a square root price
 func (c *UniswapClient) TargetPriceToSqrtPriceX96(targetPrice float64) *big.Int {
+	pool := c.getPool()
 	targetSqrtPrice := new(big.Float).SetFloat64(targetPrice)
-	targetSqrtPrice.Quo(targetSqrtPrice, big.NewFloat(math.Pow(10, float64(c.pool.Token0.Decimals()-c.pool.Token1.Decimals()))))
+	targetSqrtPrice.Quo(targetSqrtPrice, big.NewFloat(math.Pow(10, float64(pool.Token0.Decimals()-pool.Token1.Decimals()))))
 	targetSqrtPriceFloat, _ := targetSqrtPrice.Float64()
 	price := PriceToSqrtPriceX96(targetSqrtPriceFloat)
 	return price
@@ -138,10 +333,11 @@ func (c *UniswapClient) TargetPriceToSqrtPriceX96(targetPrice float64) *big.Int
 
 // GetBuyAmount returns the amount of token0 needed to buy token1
 func (c *UniswapClient) GetBuyAmount(targetPrice float64) (*coreentities.CurrencyAmount, error) {
-	outputAmount := coreentities.FromRawAmount(c.pool.Token0, coreentities.MaxUint256)
-	inputAmount, _, err := c.pool.GetInputAmount(outputAmount, c.TargetPriceToSqrtPriceX96(targetPrice))
+	pool := c.getPool()
+	outputAmount := coreentities.FromRawAmount(pool.Token0, coreentities.MaxUint256)
+	inputAmount, _, err := pool.GetInputAmount(outputAmount, c.TargetPriceToSqrtPriceX96(targetPrice))
 	if err != nil {
-		return coreentities.FromRawAmount(c.pool.Token0, big.NewInt(0)), err
+		return coreentities.FromRawAmount(pool.Token0, big.NewInt(0)), err
 	}
 
 	return inputAmount, nil
@@ -149,75 +345,191 @@ func (c *UniswapClient) GetBuyAmount(targetPrice float64) (*coreentities.Currenc
 
 // GetSellAmount returns the amount of token1 needed to sell token0
 func (c *UniswapClient) GetSellAmount(targetPrice float64) (*coreentities.CurrencyAmount, error) {
-	outputAmount := coreentities.FromRawAmount(c.pool.Token1, coreentities.MaxUint256)
-	inputAmount, _, err := c.pool.GetInputAmount(outputAmount, c.TargetPriceToSqrtPriceX96(targetPrice))
+	pool := c.getPool()
+	outputAmount := coreentities.FromRawAmount(pool.Token1, coreentities.MaxUint256)
+	inputAmount, _, err := pool.GetInputAmount(outputAmount, c.TargetPriceToSqrtPriceX96(targetPrice))
 	if err != nil {
-		return coreentities.FromRawAmount(c.pool.Token0, big.NewInt(0)), err
+		return coreentities.FromRawAmount(pool.Token0, big.NewInt(0)), err
 	}
 
 	return inputAmount, nil
 }
 
-// Trade trades tokens on Uniswap
-func (c *UniswapClient) Trade(amount *coreentities.CurrencyAmount, paper bool) error {
-	//0.01%
-	slippageTolerance := coreentities.NewPercent(big.NewInt(1), big.NewInt(1000))
-	//after 5 minutes
-	d := time.Now().Add(time.Minute * time.Duration(15)).Unix()
-	deadline := big.NewInt(d)
+// slippageTolerance returns the tolerance that makes the router enforce the minimum output wanted by opts
+func (c *UniswapClient) slippageTolerance(trade *entities.Trade, opts TradeOptions) (*coreentities.Percent, error) {
+	tolerance := coreentities.NewPercent(big.NewInt(opts.SlippageBps), big.NewInt(10000))
+
+	switch opts.SlippageMode {
+	case SlippageFixed, "":
+		return tolerance, nil
+	case SlippageImpact:
+		impact, err := trade.PriceImpact()
+		if err != nil {
+			return nil, err
+		}
+		return impact.Add(tolerance), nil
+	case SlippageHedge:
+		output := trade.OutputAmount()
+		minOut := opts.MinAmountOut.Shift(int32(output.Currency.Decimals())).Ceil().BigInt()
+		if minOut.Sign() <= 0 {
+			return nil, fmt.Errorf("hedge slippage mode requires a minimum output")
+		}
+		expected := output.Quotient()
+		if expected.Cmp(minOut) < 0 {
+			return nil, fmt.Errorf("%w: expected %s, minimum %s %s", ErrUnprofitable, output.ToExact(), opts.MinAmountOut.String(), output.Currency.Symbol())
+		}
+		// The router enforces amountOut / (1 + tolerance), solve the tolerance for minOut
+		return coreentities.NewPercent(new(big.Int).Sub(expected, minOut), minOut), nil
+	default:
+		return nil, fmt.Errorf("unknown slippage mode %q", opts.SlippageMode)
+	}
+}
+
+// MinimumOutput returns the output of swapping amount according to the local pool model, and the
+// amountOutMinimum the router would enforce for it with opts. Like Trade, it fails with ErrUnprofitable in
+// hedge mode when the pool cannot deliver the hedge minimum.
+func (c *UniswapClient) MinimumOutput(amount *coreentities.CurrencyAmount, opts TradeOptions) (*coreentities.CurrencyAmount, *coreentities.CurrencyAmount, error) {
+	pool := c.getPool()
+	amount = amount.Wrapped()
+
+	output := pool.Token0
+	if amount.Currency.Equal(pool.Token0) {
+		output = pool.Token1
+	}
 
-	var output *coreentities.Token
-	if amount.Currency.Equal(c.pool.Token0) {
-		output = c.pool.Token1
+	r, err := entities.NewRoute([]*entities.Pool{pool}, amount.Currency, output)
+	if err != nil {
+		return nil, nil, err
+	}
+	trade, err := entities.FromRoute(r, amount, coreentities.ExactInput)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slippageTolerance, err := c.slippageTolerance(trade, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	minAmountOut, err := trade.MinimumAmountOut(slippageTolerance, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return trade.OutputAmount(), minAmountOut, nil
+}
+
+// Trade trades tokens on Uniswap. The swap is simulated first, in paper mode it is never sent. It is not
+// sent either once ctx expired.
+func (c *UniswapClient) Trade(ctx context.Context, amount *coreentities.CurrencyAmount, opts TradeOptions, paper bool) (*SwapResult, error) {
+	deadline := big.NewInt(time.Now().Add(opts.Deadline).Unix())
+
+	// The swap is built and simulated against a single snapshot
+	pool, block := c.getSnapshot()
+
+	var output coreentities.Currency
+	if amount.Currency.Equal(pool.Token0) {
+		output = pool.Token1
 	} else {
-		output = c.pool.Token0
+		output = pool.Token0
+	}
+
+	// With native ETH enabled the router wraps the input or unwraps the output for us. In paper mode the
+	// gas reserve is checked against the virtual balance instead of the wallet.
+	if c.nativeETH {
+		ether := coreentities.EtherOnChain(pool.ChainID())
+		if amount.Currency.Wrapped().Equal(ether.Wrapped()) {
+			if !paper {
+				spendable, err := c.GetSpendableEthBalance()
+				if err != nil {
+					return nil, err
+				}
+				if spendable.Quotient().Cmp(amount.Quotient()) < 0 {
+					return nil, fmt.Errorf("%w: need %s ETH, have %s ETH", ErrInsufficientEth, amount.ToExact(), spendable.ToExact())
+				}
+			}
+			amount = coreentities.FromFractionalAmount(ether, amount.Numerator, amount.Denominator)
+		} else if output.Wrapped().Equal(ether.Wrapped()) {
+			output = ether
+		}
 	}
 
 	// single trade input
 	// single-hop exact input
-	r, err := entities.NewRoute([]*entities.Pool{c.pool}, amount.Currency, output)
+	r, err := entities.NewRoute([]*entities.Pool{pool}, amount.Currency, output)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	trade, err := entities.FromRoute(r, amount, coreentities.ExactInput)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	slippageTolerance, err := c.slippageTolerance(trade, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	params, err := periphery.SwapCallParameters([]*entities.Trade{trade}, &periphery.SwapOptions{
 		SlippageTolerance: slippageTolerance,
-		Recipient:         c.wallet.PublicKey,
+		Recipient:         c.signer.Address(),
 		Deadline:          deadline,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var tx *types.Transaction
-	if paper {
-		tx, err = TryTX(c.client, common.HexToAddress(helper.ContractV3SwapRouterV1), big.NewInt(0), params.Calldata, c.wallet)
-		if err != nil {
-			return err
-		}
-	} else {
-		tx, err = SendTX(c.client, common.HexToAddress(helper.ContractV3SwapRouterV1), big.NewInt(0), params.Calldata, c.wallet)
-		if err != nil {
-			return err
+	// An exact input swap spends all the ETH sent, SwapCallParameters only adds refundETH when some is left
+	calldata := params.Calldata
+
+	minAmountOut, err := trade.MinimumAmountOut(slippageTolerance, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SwapResult{
+		AmountIn:     amount,
+		ExpectedOut:  trade.OutputAmount(),
+		MinAmountOut: minAmountOut,
+	}
+
+	router := common.HexToAddress(helper.ContractV3SwapRouterV1)
+	simulation, err := c.Simulate(block, router, params.Value, calldata, amount, trade.OutputAmount(), paper && c.simulation.StateOverride)
+	if err != nil {
+		return result, err
+	}
+	result.Simulation = simulation
+
+	c.logger.Debugf("Simulated swap at block %s: amountOut=%s, expected=%s, gas=%d, divergence=%dbps", simulation.BlockNumber.String(), simulation.AmountOut.ToExact(), simulation.Expected.ToExact(), simulation.GasUsed, simulation.DivergenceBps)
+
+	if simulation.DivergenceBps > c.simulation.MaxDivergenceBps {
+		err = fmt.Errorf("%w: simulated %s, expected %s %s", ErrSimulationDiverged, simulation.AmountOut.ToExact(), simulation.Expected.ToExact(), simulation.Expected.Currency.Symbol())
+		if !paper {
+			return result, err
 		}
+		c.logger.WithError(err).Warn("Simulation diverged from the pool model")
 	}
 
-	fmt.Println(tx.Hash().String())
+	if paper {
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
 
-	pool, err := ConstructV3Pool(c.client, c.uniswapPoolAddress, c.ticklens, c.context)
+	tx, err := SendTX(c.client, router, params.Value, calldata, c.signer, c.nonces)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to the Uniswap V3 pool")
+		return result, err
 	}
+	result.TxHash = tx.Hash()
+	result.GasPrice = tx.GasPrice()
 
-	c.pool = pool
+	c.logger.WithField("tx_hash", tx.Hash().String()).Infof("Sent swap transaction with gas limit %d and gas price %s wei", tx.Gas(), tx.GasPrice().String())
 
-	return nil
+	if err := c.loadPool(); err != nil {
+		return result, fmt.Errorf("Failed to connect to the Uniswap V3 pool")
+	}
 
+	return result, nil
 }
 
 // Close closes the Uniswap client
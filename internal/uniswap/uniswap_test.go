@@ -0,0 +1,89 @@
+package uniswap
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	coreentities "github.com/daoleno/uniswap-sdk-core/entities"
+	"github.com/daoleno/uniswapv3-sdk/constants"
+	"github.com/daoleno/uniswapv3-sdk/entities"
+	"github.com/daoleno/uniswapv3-sdk/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+)
+
+// testClient returns a client holding a full range pool with a 1:1 price between two 18 decimals tokens
+func testClient(t *testing.T) (*UniswapClient, *coreentities.Token) {
+	t.Helper()
+	token0 := coreentities.NewToken(1, common.HexToAddress("0x0000000000000000000000000000000000000001"), 18, "ELON", "Dogelon")
+	token1 := coreentities.NewToken(1, common.HexToAddress("0x0000000000000000000000000000000000000002"), 18, "USDT", "Tether")
+
+	liquidity := new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)
+	spacing := constants.TickSpacings[constants.FeeMedium]
+	ticks, err := entities.NewTickListDataProvider([]entities.Tick{
+		{Index: utils.MinTick / spacing * spacing, LiquidityGross: liquidity, LiquidityNet: liquidity},
+		{Index: utils.MaxTick / spacing * spacing, LiquidityGross: liquidity, LiquidityNet: new(big.Int).Neg(liquidity)},
+	}, spacing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := entities.NewPool(token0, token1, constants.FeeMedium, utils.EncodeSqrtRatioX96(big.NewInt(1), big.NewInt(1)), liquidity, 0, ticks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &UniswapClient{pool: pool}, token0
+}
+
+func TestMinimumOutputHedge(t *testing.T) {
+	client, token0 := testClient(t)
+	amount := coreentities.FromRawAmount(token0, big.NewInt(1e18))
+
+	expected, _, err := client.MinimumOutput(amount, TradeOptions{SlippageMode: SlippageFixed})
+	if err != nil {
+		t.Fatalf("MinimumOutput: %v", err)
+	}
+	quote, err := client.Quote(amount)
+	if err != nil {
+		t.Fatalf("Quote: %v", err)
+	}
+	if expected.Quotient().Cmp(quote.Quotient()) != 0 {
+		t.Errorf("expected output %s, want the quote %s", expected.ToExact(), quote.ToExact())
+	}
+
+	// A hedge minimum the pool can deliver is enforced as is
+	minimum := decimal.RequireFromString("0.99")
+	_, minAmountOut, err := client.MinimumOutput(amount, TradeOptions{SlippageMode: SlippageHedge, MinAmountOut: minimum})
+	if err != nil {
+		t.Fatalf("MinimumOutput: %v", err)
+	}
+	if got := decimal.NewFromBigInt(minAmountOut.Quotient(), -18); !got.Equal(minimum) {
+		t.Errorf("minimum output %s, want the hedge minimum %s", got.String(), minimum.String())
+	}
+
+	// A hedge minimum above the pool output is unprofitable
+	_, _, err = client.MinimumOutput(amount, TradeOptions{SlippageMode: SlippageHedge, MinAmountOut: decimal.NewFromInt(1)})
+	if !errors.Is(err, ErrUnprofitable) {
+		t.Errorf("MinimumOutput above the pool output = %v, want %v", err, ErrUnprofitable)
+	}
+
+	// The hedge mode requires a minimum
+	if _, _, err := client.MinimumOutput(amount, TradeOptions{SlippageMode: SlippageHedge}); err == nil {
+		t.Error("MinimumOutput without a hedge minimum succeeded, want an error")
+	}
+}
+
+func TestMinimumOutputFixed(t *testing.T) {
+	client, token0 := testClient(t)
+	amount := coreentities.FromRawAmount(token0, big.NewInt(1e18))
+
+	expected, minAmountOut, err := client.MinimumOutput(amount, TradeOptions{SlippageMode: SlippageFixed, SlippageBps: 100})
+	if err != nil {
+		t.Fatalf("MinimumOutput: %v", err)
+	}
+	// The router enforces amountOut / (1 + tolerance)
+	want := new(big.Int).Div(new(big.Int).Mul(expected.Quotient(), big.NewInt(10000)), big.NewInt(10100))
+	if minAmountOut.Quotient().Cmp(want) != 0 {
+		t.Errorf("minimum output %s, want %s", minAmountOut.Quotient().String(), want.String())
+	}
+}
@@ -2,11 +2,16 @@ package uniswap
 
 import (
 	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// Wallet signs with a private key held in memory
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  common.Address
@@ -16,15 +21,32 @@ func (w Wallet) PubkeyStr() string {
 	return w.PublicKey.String()
 }
 
-func InitWallet(privateHexKeys string) *Wallet {
+// Address returns the address of the wallet
+func (w *Wallet) Address() common.Address {
+	return w.PublicKey
+}
+
+// SignTx signs a transaction with the private key
+func (w *Wallet) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), w.PrivateKey)
+}
+
+// InitWallet loads a wallet from a hex encoded private key
+func InitWallet(privateHexKeys string) (*Wallet, error) {
 	if privateHexKeys == "" {
-		return nil
+		return nil, fmt.Errorf("Private key is empty")
 	}
-	privateKey, err := crypto.HexToECDSA(privateHexKeys)
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateHexKeys, "0x"))
 	if err != nil {
-		return nil
+		// The error may quote the key, keep it out of logs
+		return nil, fmt.Errorf("Invalid private key")
 	}
 
+	return NewWallet(privateKey), nil
+}
+
+// NewWallet wraps a private key
+func NewWallet(privateKey *ecdsa.PrivateKey) *Wallet {
 	return &Wallet{
 		PrivateKey: privateKey,
 		PublicKey:  crypto.PubkeyToAddress(privateKey.PublicKey),
@@ -1,9 +1,32 @@
 package utils
 
-import "strings"
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
 
 // GetTokensFromTradingPair returns the two tokens from a trading pair
 func GetTokensFromTradingPair(tradingPair string) (string, string) {
 	v := strings.SplitN(tradingPair, "-", 2)
 	return v[0], v[1]
 }
+
+// ParseUnits converts a decimal string (e.g. "0.05") to its integer representation with the given decimals
+func ParseUnits(value string, decimals int32) (*big.Int, error) {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decimal value %q: %w", value, err)
+	}
+	if d.IsNegative() {
+		return nil, fmt.Errorf("negative value %q", value)
+	}
+	return d.Shift(decimals).BigInt(), nil
+}
+
+// FormatUnits converts an integer amount with the given decimals to an exact decimal string
+func FormatUnits(value *big.Int, decimals int32) string {
+	return decimal.NewFromBigInt(value, -decimals).String()
+}